@@ -1,9 +1,12 @@
 package app
 
 import (
+	"context"
 	"fmt"
 	"time"
 
+	"github.com/k3s-io/kine/pkg/composite"
+	"github.com/k3s-io/kine/pkg/drivers/generic"
 	"github.com/k3s-io/kine/pkg/endpoint"
 	"github.com/k3s-io/kine/pkg/metrics"
 	"github.com/k3s-io/kine/pkg/signals"
@@ -12,6 +15,10 @@ import (
 	"github.com/urfave/cli/v2"
 )
 
+const (
+	shutdownTimeout = 10 * time.Second
+)
+
 var (
 	config                 endpoint.Config
 	metricsConfig          metrics.Config
@@ -31,9 +38,14 @@ func New() *cli.App {
 		},
 		&cli.StringFlag{
 			Name:        "endpoint",
-			Usage:       "Storage endpoint (default is sqlite)",
+			Usage:       "Storage endpoint (default is sqlite). MySQL and Postgres endpoints may list several comma-separated hosts, to connect to whichever accepts writes. Credentials may be read from files with the usernameFile and passwordFile parameters, and are re-read when they change.",
 			Destination: &config.Endpoint,
 		},
+		&cli.StringFlag{
+			Name:        "replica-endpoint",
+			Usage:       "Storage endpoint of a read replica of the datastore, used for reads at past revisions and serializable reads. Must use the same scheme as the endpoint. Only supported by MySQL and Postgres.",
+			Destination: &config.ReplicaEndpoint,
+		},
 		&cli.StringFlag{
 			Name:        "ca-file",
 			Usage:       "CA cert for DB connection",
@@ -130,6 +142,11 @@ func New() *cli.App {
 			Destination: &config.EmulatedETCDVersion,
 			Value:       "3.5.13",
 		},
+		&cli.StringFlag{
+			Name:        "admin-token-file",
+			Usage:       "Path to a file containing the token that clients of the kine.v1.Admin GRPC service must present as a bearer token. The admin service is disabled if not set.",
+			Destination: &config.AdminTokenFile,
+		},
 		&cli.DurationFlag{
 			Name:        "compact-interval",
 			Usage:       "Interval between automatic compaction. Default is 5m.",
@@ -166,13 +183,107 @@ func New() *cli.App {
 			Destination: &config.PollBatchSize,
 			Value:       500,
 		},
+		&cli.StringFlag{
+			Name:        "compression",
+			Usage:       "Algorithm used to compress values stored by SQL drivers. Options are 'none', 's2' or 'zstd'. Default is none.",
+			Destination: &config.CompressionConfig.Algorithm,
+			Value:       "none",
+		},
+		&cli.IntFlag{
+			Name:        "compression-min-size",
+			Usage:       "Minimum size in bytes of values to compress. Default is 1024.",
+			Destination: &config.CompressionConfig.MinSize,
+			Value:       1024,
+		},
+		&cli.StringSliceFlag{
+			Name:  "compact-retention",
+			Usage: "Retain the history of keys with the given prefix for a duration after it is replaced or deleted, in the form prefix=duration. May be repeated. Retained revisions older than the compact revision can only be read with the history API; range and watch requests for them fail as compacted. Only supported by SQL drivers.",
+		},
+		&cli.BoolFlag{
+			Name:        "compact-retention-dry-run",
+			Usage:       "Log the number of rows that each retention rule would delete, instead of compacting. Only supported by SQL drivers.",
+			Destination: &config.RetentionConfig.DryRun,
+		},
+		&cli.StringSliceFlag{
+			Name:  "route",
+			Usage: "Store keys with the given prefix in a separate datastore, in the form prefix=endpoint. May be repeated. Route prefixes must not be nested. Revisions are only consistent within a single kine server.",
+		},
+		&cli.StringFlag{
+			Name:        "encryption-config",
+			Usage:       "Path to a file listing the keys used to encrypt stored values. The first key is used to encrypt new values.",
+			Destination: &config.EncryptionConfig.ConfigFile,
+		},
+		&cli.DurationFlag{
+			Name:        "encryption-reencrypt-interval",
+			Usage:       "Interval at which values not encrypted with the primary key are re-encrypted. Default is 1h.",
+			Destination: &config.EncryptionConfig.ReencryptInterval,
+			Value:       time.Hour,
+		},
+		&cli.IntFlag{
+			Name:        "circuit-breaker-failures",
+			Usage:       "Number of consecutive failed or slow backend calls after which requests fail immediately as unhealthy, until a probe of the backend succeeds. The circuit breaker is disabled if 0.",
+			Destination: &config.CircuitBreakerConfig.FailureThreshold,
+			Value:       0,
+		},
+		&cli.DurationFlag{
+			Name:        "circuit-breaker-latency",
+			Usage:       "Duration after which a backend call is counted as failed by the circuit breaker. Latency is not checked if 0.",
+			Destination: &config.CircuitBreakerConfig.LatencyThreshold,
+			Value:       0,
+		},
+		&cli.DurationFlag{
+			Name:        "circuit-breaker-open-timeout",
+			Usage:       "Time that the circuit breaker stays open before probing the backend. Default is 10s.",
+			Destination: &config.CircuitBreakerConfig.OpenTimeout,
+			Value:       10 * time.Second,
+		},
 		&cli.BoolFlag{Name: "debug"},
 	}
+	app.Commands = []*cli.Command{
+		migrateCommand(),
+	}
 	app.Action = run
 	return app
 }
 
 func run(c *cli.Context) error {
+	if err := configureLogging(c); err != nil {
+		return err
+	}
+	ctx := signals.SetupSignalContext()
+
+	rules, err := generic.ParseRetentionRules(c.StringSlice("compact-retention"))
+	if err != nil {
+		return err
+	}
+	config.RetentionConfig.Rules = rules
+
+	routes, err := composite.ParseRoutes(c.StringSlice("route"))
+	if err != nil {
+		return err
+	}
+	config.Routes = routes
+
+	if !metricsIgnoreTLSConfig {
+		metricsConfig.ServerTLSConfig = config.ServerTLSConfig
+	}
+	go metrics.Serve(ctx, metricsConfig)
+	config.MetricsRegisterer = metrics.Registry
+	s, err := endpoint.New(ctx, config)
+	if err != nil {
+		return err
+	}
+	<-ctx.Done()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := s.Shutdown(shutdownCtx); err != nil {
+		logrus.Errorf("Failed to shut down kine: %v", err)
+	}
+	return ctx.Err()
+}
+
+func configureLogging(c *cli.Context) error {
 	if config.LogFormat == "plain" {
 		logrus.SetFormatter(&logrus.TextFormatter{
 			FullTimestamp:   true,
@@ -193,17 +304,5 @@ func run(c *cli.Context) error {
 	if c.Bool("debug") {
 		logrus.SetLevel(logrus.TraceLevel)
 	}
-	ctx := signals.SetupSignalContext()
-
-	if !metricsIgnoreTLSConfig {
-		metricsConfig.ServerTLSConfig = config.ServerTLSConfig
-	}
-	go metrics.Serve(ctx, metricsConfig)
-	config.MetricsRegisterer = metrics.Registry
-	_, err := endpoint.Listen(ctx, config)
-	if err != nil {
-		return err
-	}
-	<-ctx.Done()
-	return ctx.Err()
+	return nil
 }
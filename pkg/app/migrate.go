@@ -0,0 +1,96 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/k3s-io/kine/pkg/endpoint"
+	"github.com/k3s-io/kine/pkg/signals"
+	"github.com/urfave/cli/v2"
+)
+
+var migrateDryRun bool
+
+func migrateCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "migrate",
+		Usage: "Manage the database schema of the storage endpoint",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "status",
+				Usage:  "List schema migrations, and whether they have been applied",
+				Action: migrateStatus,
+			},
+			{
+				Name:  "up",
+				Usage: "Apply all pending schema migrations",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:        "dry-run",
+						Usage:       "Print the statements for pending migrations without applying them",
+						Destination: &migrateDryRun,
+					},
+				},
+				Action: migrateUp,
+			},
+		},
+	}
+}
+
+func migrateStatus(c *cli.Context) error {
+	if err := configureLogging(c); err != nil {
+		return err
+	}
+	ctx := signals.SetupSignalContext()
+
+	migrator, err := endpoint.NewMigrator(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(c.App.Writer, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tDESCRIPTION\tSTATUS")
+	for _, s := range status {
+		fmt.Fprintln(w, s)
+	}
+	return w.Flush()
+}
+
+func migrateUp(c *cli.Context) error {
+	if err := configureLogging(c); err != nil {
+		return err
+	}
+	ctx := signals.SetupSignalContext()
+
+	migrator, err := endpoint.NewMigrator(ctx, config)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	if !migrateDryRun {
+		return migrator.Up(ctx, 0)
+	}
+
+	pending, err := migrator.Pending(ctx, 0)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		fmt.Fprintln(c.App.Writer, "-- schema is up to date")
+	}
+	for _, migration := range pending {
+		fmt.Fprintf(c.App.Writer, "-- migration %d: %s\n", migration.Version, migration.Description)
+		for _, stmt := range migration.Statements {
+			fmt.Fprintf(c.App.Writer, "%s;\n", strings.TrimSuffix(strings.TrimSpace(stmt), ";"))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,357 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/k3s-io/kine/pkg/metrics"
+	"github.com/k3s-io/kine/pkg/server"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// defaultProbeTimeout is the time allowed for a probe to complete, if no latency threshold is set.
+	defaultProbeTimeout = 10 * time.Second
+	// defaultOpenTimeout is the time that the breaker stays open, if no open timeout is set.
+	defaultOpenTimeout = 10 * time.Second
+)
+
+// explicit interface checks
+var (
+	_ server.Backend               = (*Backend)(nil)
+	_ server.HistoryBackend        = (*Backend)(nil)
+	_ server.CapabilitiesBackend   = (*Backend)(nil)
+	_ server.HealthBackend         = (*Backend)(nil)
+	_ server.ManagedCompactBackend = (*Backend)(nil)
+)
+
+// Config configures when the circuit breaker opens, and how long it stays open.
+type Config struct {
+	// FailureThreshold is the number of consecutive failed calls after which the breaker
+	// opens. The breaker is disabled if zero.
+	FailureThreshold int
+	// LatencyThreshold is the duration after which a call is counted as failed, even if it
+	// succeeds. Latency is not checked if zero.
+	LatencyThreshold time.Duration
+	// OpenTimeout is the time that the breaker stays open before probing the backend. Ten
+	// seconds is used if zero.
+	OpenTimeout time.Duration
+}
+
+// State is the state of the circuit breaker.
+type State int
+
+const (
+	// StateClosed passes calls through to the backend.
+	StateClosed State = iota
+	// StateOpen fails calls without passing them to the backend.
+	StateOpen
+	// StateHalfOpen fails calls while the backend is probed, to decide whether to close again.
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// Backend is a server.Backend that stops passing calls to another backend after consecutive
+// calls fail or are slow, so that requests fail immediately with ErrGRPCUnhealthy instead of
+// waiting for a stalled datastore. Once the breaker has been open for the open timeout, it
+// half-opens and probes the backend by reading the current revision; it closes if the probe
+// succeeds, and opens again if not. The backend is reported as unhealthy unless the breaker
+// is closed.
+//
+// Watches are long-lived, and are passed through to the backend in any state.
+type Backend struct {
+	backend server.Backend
+	config  Config
+
+	mu          sync.Mutex
+	ctx         context.Context
+	state       State
+	failures    int
+	healthFuncs []func(healthy bool)
+}
+
+// NewBackend returns a backend that passes calls to the provided backend while the circuit
+// breaker is closed.
+func NewBackend(backend server.Backend, config Config) *Backend {
+	if config.OpenTimeout <= 0 {
+		// without a timeout the backend would be probed continuously while it is failing
+		config.OpenTimeout = defaultOpenTimeout
+	}
+	return &Backend{
+		backend: backend,
+		config:  config,
+		ctx:     context.Background(),
+	}
+}
+
+// Start starts the wrapped backend. Probes are stopped when the context is cancelled.
+func (b *Backend) Start(ctx context.Context) error {
+	b.mu.Lock()
+	b.ctx = ctx
+	b.mu.Unlock()
+	metrics.CircuitBreakerState.Set(float64(StateClosed))
+	return b.backend.Start(ctx)
+}
+
+func (b *Backend) Close() error {
+	return b.backend.Close()
+}
+
+// State returns the current state of the circuit breaker.
+func (b *Backend) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// NotifyHealth calls the function with the current health of the backend, and again each time
+// that the breaker closes, or opens after being closed.
+func (b *Backend) NotifyHealth(f func(healthy bool)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.healthFuncs = append(b.healthFuncs, f)
+	f(b.state == StateClosed)
+}
+
+func (b *Backend) Get(ctx context.Context, key, rangeEnd string, limit, revision int64) (rev int64, kv *server.KeyValue, err error) {
+	err = b.call(func() error {
+		rev, kv, err = b.backend.Get(ctx, key, rangeEnd, limit, revision)
+		return err
+	})
+	return
+}
+
+func (b *Backend) Create(ctx context.Context, key string, value []byte, lease int64) (rev int64, err error) {
+	err = b.call(func() error {
+		rev, err = b.backend.Create(ctx, key, value, lease)
+		return err
+	})
+	return
+}
+
+func (b *Backend) Delete(ctx context.Context, key string, revision int64) (rev int64, kv *server.KeyValue, deleted bool, err error) {
+	err = b.call(func() error {
+		rev, kv, deleted, err = b.backend.Delete(ctx, key, revision)
+		return err
+	})
+	return
+}
+
+func (b *Backend) List(ctx context.Context, prefix, startKey string, limit, revision int64) (rev int64, kvs []*server.KeyValue, err error) {
+	err = b.call(func() error {
+		rev, kvs, err = b.backend.List(ctx, prefix, startKey, limit, revision)
+		return err
+	})
+	return
+}
+
+func (b *Backend) Count(ctx context.Context, prefix, startKey string, revision int64) (rev int64, count int64, err error) {
+	err = b.call(func() error {
+		rev, count, err = b.backend.Count(ctx, prefix, startKey, revision)
+		return err
+	})
+	return
+}
+
+func (b *Backend) Update(ctx context.Context, key string, value []byte, revision, lease int64) (rev int64, kv *server.KeyValue, updated bool, err error) {
+	err = b.call(func() error {
+		rev, kv, updated, err = b.backend.Update(ctx, key, value, revision, lease)
+		return err
+	})
+	return
+}
+
+func (b *Backend) Watch(ctx context.Context, key string, revision int64) server.WatchResult {
+	return b.backend.Watch(ctx, key, revision)
+}
+
+func (b *Backend) DbSize(ctx context.Context) (size int64, err error) {
+	err = b.call(func() error {
+		size, err = b.backend.DbSize(ctx)
+		return err
+	})
+	return
+}
+
+func (b *Backend) CurrentRevision(ctx context.Context) (rev int64, err error) {
+	err = b.call(func() error {
+		rev, err = b.backend.CurrentRevision(ctx)
+		return err
+	})
+	return
+}
+
+func (b *Backend) Compact(ctx context.Context, revision int64) (rev int64, err error) {
+	err = b.call(func() error {
+		rev, err = b.backend.Compact(ctx, revision)
+		return err
+	})
+	return
+}
+
+func (b *Backend) ManagedCompact(ctx context.Context, revision int64) (rev int64, err error) {
+	m, ok := b.backend.(server.ManagedCompactBackend)
+	if !ok {
+		return 0, server.ErrManagedCompactNotSupported
+	}
+	err = b.call(func() error {
+		rev, err = m.ManagedCompact(ctx, revision)
+		return err
+	})
+	return
+}
+
+func (b *Backend) RevisionAt(ctx context.Context, t time.Time) (rev int64, err error) {
+	history, ok := b.backend.(server.HistoryBackend)
+	if !ok {
+		return 0, server.ErrHistoryNotSupported
+	}
+	err = b.call(func() error {
+		rev, err = history.RevisionAt(ctx, t)
+		return err
+	})
+	return
+}
+
+func (b *Backend) History(ctx context.Context, key string, prefix bool, startRevision, endRevision, limit int64) (rev int64, events []*server.Event, err error) {
+	history, ok := b.backend.(server.HistoryBackend)
+	if !ok {
+		return 0, nil, server.ErrHistoryNotSupported
+	}
+	err = b.call(func() error {
+		rev, events, err = history.History(ctx, key, prefix, startRevision, endRevision, limit)
+		return err
+	})
+	return
+}
+
+func (b *Backend) Capabilities() server.Capabilities {
+	if c, ok := b.backend.(server.CapabilitiesBackend); ok {
+		return c.Capabilities()
+	}
+	return server.Capabilities{}
+}
+
+// call calls the function if the breaker is closed, and records the result.
+func (b *Backend) call(f func() error) error {
+	if b.State() != StateClosed {
+		metrics.CircuitBreakerRejectedTotal.Inc()
+		return server.ErrGRPCUnhealthy
+	}
+
+	start := time.Now()
+	err := f()
+	failed, ok := b.failed(time.Since(start), err)
+	if !ok {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// results of calls that started before the breaker opened are ignored
+	if b.state != StateClosed {
+		return err
+	}
+	if !failed {
+		b.failures = 0
+		return err
+	}
+	b.failures++
+	if b.failures >= b.config.FailureThreshold {
+		logrus.Warnf("Circuit breaker opened after %d consecutive failed backend calls: %v", b.failures, err)
+		b.open()
+	}
+	return err
+}
+
+// failed returns whether the call failed or was too slow. False is returned for ok if the
+// call was cancelled by the caller, as that says nothing about the health of the backend.
+func (b *Backend) failed(duration time.Duration, err error) (failed bool, ok bool) {
+	if errors.Is(err, context.Canceled) {
+		return false, false
+	}
+	if b.config.LatencyThreshold > 0 && duration > b.config.LatencyThreshold {
+		return true, true
+	}
+	if err == nil {
+		return false, true
+	}
+	// errors with a gRPC status, such as ErrKeyExists or ErrCompacted, are returned by
+	// backends that are working normally
+	_, isStatus := status.FromError(err)
+	return !isStatus, true
+}
+
+// open opens the breaker, and schedules a probe after the open timeout. The lock must be held.
+func (b *Backend) open() {
+	b.failures = 0
+	b.setState(StateOpen)
+	time.AfterFunc(b.config.OpenTimeout, b.probe)
+}
+
+// probe half-opens the breaker and reads the current revision from the backend. The breaker
+// closes if the probe succeeds, and opens again if not.
+func (b *Backend) probe() {
+	b.mu.Lock()
+	ctx := b.ctx
+	if ctx.Err() != nil {
+		b.mu.Unlock()
+		return
+	}
+	b.setState(StateHalfOpen)
+	b.mu.Unlock()
+
+	timeout := defaultProbeTimeout
+	if b.config.LatencyThreshold > 0 {
+		timeout = b.config.LatencyThreshold
+	}
+	probeCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	_, err := b.backend.CurrentRevision(probeCtx)
+	failed, _ := b.failed(time.Since(start), err)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if ctx.Err() != nil {
+		return
+	}
+	if failed || err != nil {
+		logrus.Warnf("Circuit breaker probe failed, reopening: %v", err)
+		b.open()
+		return
+	}
+	logrus.Infof("Circuit breaker probe succeeded, closing")
+	b.setState(StateClosed)
+}
+
+// setState sets the state of the breaker, and notifies health watchers if the backend has become
+// healthy or unhealthy. The lock must be held.
+func (b *Backend) setState(state State) {
+	wasHealthy := b.state == StateClosed
+	b.state = state
+	metrics.CircuitBreakerState.Set(float64(state))
+
+	if healthy := state == StateClosed; healthy != wasHealthy {
+		for _, f := range b.healthFuncs {
+			f(healthy)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/k3s-io/kine/pkg/drivers/memory"
+	"github.com/k3s-io/kine/pkg/logstructured"
+	"github.com/k3s-io/kine/pkg/server"
+)
+
+var errStalled = errors.New("database stalled")
+
+// faultyBackend is a backend that returns an error or delays reads while faulty.
+type faultyBackend struct {
+	server.Backend
+
+	mu    sync.Mutex
+	err   error
+	delay time.Duration
+}
+
+func (f *faultyBackend) set(err error, delay time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err, f.delay = err, delay
+}
+
+func (f *faultyBackend) fault() error {
+	f.mu.Lock()
+	err, delay := f.err, f.delay
+	f.mu.Unlock()
+	time.Sleep(delay)
+	return err
+}
+
+func (f *faultyBackend) Get(ctx context.Context, key, rangeEnd string, limit, revision int64) (int64, *server.KeyValue, error) {
+	if err := f.fault(); err != nil {
+		return 0, nil, err
+	}
+	return f.Backend.Get(ctx, key, rangeEnd, limit, revision)
+}
+
+func (f *faultyBackend) CurrentRevision(ctx context.Context) (int64, error) {
+	if err := f.fault(); err != nil {
+		return 0, err
+	}
+	return f.Backend.CurrentRevision(ctx)
+}
+
+func setupBackend(t *testing.T, config Config) (*Backend, *faultyBackend) {
+	log, err := memory.NewMemoryLog("", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	faulty := &faultyBackend{Backend: logstructured.New(log)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b := NewBackend(faulty, config)
+	if err := b.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		b.Close()
+	})
+	return b, faulty
+}
+
+func waitForState(t *testing.T, b *Backend, state State) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if b.State() == state {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for state %s, state is %s", state, b.State())
+}
+
+func TestBackend_OpenAndProbe(t *testing.T) {
+	b, faulty := setupBackend(t, Config{FailureThreshold: 3, OpenTimeout: 100 * time.Millisecond})
+	ctx := context.Background()
+
+	var health []bool
+	var mu sync.Mutex
+	b.NotifyHealth(func(healthy bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		health = append(health, healthy)
+	})
+
+	faulty.set(errStalled, 0)
+	for i := 0; i < 3; i++ {
+		if _, _, err := b.Get(ctx, "/a", "", 1, 0); !errors.Is(err, errStalled) {
+			t.Fatalf("expected %v, got %v", errStalled, err)
+		}
+	}
+	if state := b.State(); state != StateOpen {
+		t.Fatalf("expected open breaker, got %s", state)
+	}
+
+	// calls fail immediately while the breaker is open
+	if _, _, err := b.Get(ctx, "/a", "", 1, 0); err != server.ErrGRPCUnhealthy {
+		t.Fatalf("expected %v, got %v", server.ErrGRPCUnhealthy, err)
+	}
+
+	// probes fail until the backend recovers
+	time.Sleep(300 * time.Millisecond)
+	if state := b.State(); state == StateClosed {
+		t.Fatal("expected breaker to stay open while the backend is failing")
+	}
+
+	faulty.set(nil, 0)
+	waitForState(t, b, StateClosed)
+	if _, _, err := b.Get(ctx, "/a", "", 1, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(health) != 3 || !health[0] || health[1] || !health[2] {
+		t.Fatalf("expected healthy, unhealthy, healthy; got %v", health)
+	}
+}
+
+func TestBackend_Latency(t *testing.T) {
+	b, faulty := setupBackend(t, Config{FailureThreshold: 2, LatencyThreshold: 50 * time.Millisecond, OpenTimeout: 100 * time.Millisecond})
+	ctx := context.Background()
+
+	faulty.set(nil, 100*time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if _, _, err := b.Get(ctx, "/a", "", 1, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if state := b.State(); state == StateClosed {
+		t.Fatal("expected breaker to open after slow calls")
+	}
+
+	faulty.set(nil, 0)
+	waitForState(t, b, StateClosed)
+}
+
+func TestBackend_IgnoredErrors(t *testing.T) {
+	b, faulty := setupBackend(t, Config{FailureThreshold: 1, OpenTimeout: time.Minute})
+
+	// errors returned by working backends do not open the breaker
+	for _, err := range []error{server.ErrKeyExists, server.ErrCompacted} {
+		faulty.set(err, 0)
+		if _, _, gerr := b.Get(context.Background(), "/a", "", 1, 0); gerr != err {
+			t.Fatalf("expected %v, got %v", err, gerr)
+		}
+	}
+
+	// nor do calls cancelled by the caller
+	faulty.set(context.Canceled, 0)
+	b.Get(context.Background(), "/a", "", 1, 0)
+
+	if state := b.State(); state != StateClosed {
+		t.Fatalf("expected closed breaker, got %s", state)
+	}
+
+	faulty.set(errStalled, 0)
+	b.Get(context.Background(), "/a", "", 1, 0)
+	if state := b.State(); state != StateOpen {
+		t.Fatalf("expected open breaker, got %s", state)
+	}
+}
+
+func TestBackend_DefaultOpenTimeout(t *testing.T) {
+	b, faulty := setupBackend(t, Config{FailureThreshold: 1})
+	if b.config.OpenTimeout != defaultOpenTimeout {
+		t.Fatalf("expected open timeout %v, got %v", defaultOpenTimeout, b.config.OpenTimeout)
+	}
+
+	faulty.set(errStalled, 0)
+	b.Get(context.Background(), "/a", "", 1, 0)
+
+	// the breaker is not probed until the default open timeout has passed
+	faulty.set(nil, 0)
+	time.Sleep(100 * time.Millisecond)
+	if state := b.State(); state != StateOpen {
+		t.Fatalf("expected open breaker, got %s", state)
+	}
+}
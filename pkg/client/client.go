@@ -4,29 +4,82 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/k3s-io/kine/pkg/endpoint"
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/etcd/api/v3/mvccpb"
 	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
+)
+
+const (
+	// watchRetryInterval is the delay before a watch is re-established after the stream is lost.
+	watchRetryInterval = time.Second
+
+	// putMaxAttempts is the number of compare-and-swap attempts made by Put before giving up.
+	putMaxAttempts = 10
+	// putRetryInterval is the delay before the second attempt made by Put, doubling after each
+	// further attempt.
+	putRetryInterval = 10 * time.Millisecond
 )
 
 type Value struct {
 	Key      []byte
 	Data     []byte
 	Modified int64
+	Lease    int64
+}
+
+// Event is a single change delivered by Watch.
+type Event struct {
+	Delete bool
+	Create bool
+	Value  Value
+	Prev   *Value
+}
+
+// WatchResponse is a batch of events delivered by Watch. If Err is set, no events are included;
+// ErrCompacted indicates that the watch was resumed from CompactRevision and that events between
+// the last delivered revision and CompactRevision have been lost.
+type WatchResponse struct {
+	Events          []Event
+	Revision        int64
+	CompactRevision int64
+	Err             error
+}
+
+// ListResult is a single page of results returned by ListPage. If More is true,
+// Continue holds the start key that should be passed to the next call, along with
+// Revision, to read the next page from the same snapshot.
+type ListResult struct {
+	Values   []Value
+	Revision int64
+	Count    int64
+	More     bool
+	Continue string
 }
 
 var (
-	ErrNotFound = errors.New("etcdwrapper: key not found")
+	ErrNotFound  = errors.New("etcdwrapper: key not found")
+	ErrCompacted = errors.New("etcdwrapper: required revision has been compacted")
+	ErrConflict  = errors.New("etcdwrapper: key was modified concurrently")
 )
 
 type Client interface {
 	List(ctx context.Context, key string, rev int) ([]Value, error)
+	ListPage(ctx context.Context, prefix, startKey string, limit, revision int64) (ListResult, error)
 	Get(ctx context.Context, key string) (Value, error)
 	Put(ctx context.Context, key string, value []byte) error
 	Create(ctx context.Context, key string, value []byte) error
+	CreateWithLease(ctx context.Context, key string, value []byte, lease int64) error
 	Update(ctx context.Context, key string, revision int64, value []byte) error
+	UpdateWithLease(ctx context.Context, key string, revision int64, value []byte, lease int64) error
 	Delete(ctx context.Context, key string, revision int64) error
+	Grant(ctx context.Context, ttl int64) (int64, error)
+	Watch(ctx context.Context, key string, revision int64) <-chan WatchResponse
+	Txn(ctx context.Context) clientv3.Txn
 	Compact(ctx context.Context, revision int64) (int64, error)
 	Close() error
 }
@@ -41,11 +94,16 @@ func New(config endpoint.ETCDConfig) (Client, error) {
 		return nil, err
 	}
 
-	c, err := clientv3.New(clientv3.Config{
+	cfg := clientv3.Config{
 		Endpoints:   config.Endpoints,
 		DialTimeout: 5 * time.Second,
 		TLS:         tlsConfig,
-	})
+	}
+	if config.Dialer != nil {
+		cfg.DialOptions = append(cfg.DialOptions, grpc.WithContextDialer(config.Dialer))
+	}
+
+	c, err := clientv3.New(cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -63,16 +121,51 @@ func (c *client) List(ctx context.Context, key string, rev int) ([]Value, error)
 
 	var vals []Value
 	for _, kv := range resp.Kvs {
-		vals = append(vals, Value{
-			Key:      kv.Key,
-			Data:     kv.Value,
-			Modified: kv.ModRevision,
-		})
+		vals = append(vals, toValue(kv))
 	}
 
 	return vals, nil
 }
 
+// ListPage lists up to limit keys under prefix, starting at startKey. A zero limit returns all keys.
+// A zero revision lists at the current revision; callers reading subsequent pages should pass the
+// Revision from the first page so that all pages are read from the same snapshot.
+func (c *client) ListPage(ctx context.Context, prefix, startKey string, limit, revision int64) (ListResult, error) {
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	if startKey == "" {
+		startKey = prefix
+	}
+
+	resp, err := c.c.Get(ctx, startKey,
+		clientv3.WithRange(clientv3.GetPrefixRangeEnd(prefix)),
+		clientv3.WithLimit(limit),
+		clientv3.WithRev(revision))
+	if err != nil {
+		return ListResult{}, err
+	}
+
+	result := ListResult{
+		Values:   make([]Value, 0, len(resp.Kvs)),
+		Revision: resp.Header.GetRevision(),
+		Count:    resp.Count,
+		More:     resp.More,
+	}
+	if revision != 0 {
+		// the response header holds the current revision, not the revision that was read
+		result.Revision = revision
+	}
+	for _, kv := range resp.Kvs {
+		result.Values = append(result.Values, toValue(kv))
+	}
+	if result.More && len(resp.Kvs) > 0 {
+		result.Continue = string(resp.Kvs[len(resp.Kvs)-1].Key) + "\x00"
+	}
+
+	return result, nil
+}
+
 func (c *client) Get(ctx context.Context, key string) (Value, error) {
 	resp, err := c.c.Get(ctx, key)
 	if err != nil {
@@ -80,31 +173,64 @@ func (c *client) Get(ctx context.Context, key string) (Value, error) {
 	}
 
 	if len(resp.Kvs) == 1 {
-		return Value{
-			Key:      resp.Kvs[0].Key,
-			Data:     resp.Kvs[0].Value,
-			Modified: resp.Kvs[0].ModRevision,
-		}, nil
+		return toValue(resp.Kvs[0]), nil
 	}
 
 	return Value{}, ErrNotFound
 }
 
+// Put creates or updates the key. Each attempt is a single compare-and-swap transaction
+// against the last observed revision, so concurrent writers cannot be silently overwritten
+// by a stale read. Attempts are retried with backoff while the key is being modified
+// concurrently; ErrConflict is returned if every attempt fails.
 func (c *client) Put(ctx context.Context, key string, value []byte) error {
-	val, err := c.Get(ctx, key)
-	if err != nil {
-		return err
-	}
-	if val.Modified == 0 {
-		return c.Create(ctx, key, value)
+	var revision int64
+	delay := putRetryInterval
+	for attempt := 1; ; attempt++ {
+		resp, err := c.c.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(key), "=", revision)).
+			Then(clientv3.OpPut(key, string(value))).
+			Else(clientv3.OpGet(key)).
+			Commit()
+		if err != nil {
+			return err
+		}
+		if resp.Succeeded {
+			return nil
+		}
+
+		expected := revision
+		revision = 0
+		if len(resp.Responses) == 1 {
+			if kvs := resp.Responses[0].GetResponseRange().GetKvs(); len(kvs) == 1 {
+				revision = kvs[0].ModRevision
+			}
+		}
+
+		if attempt == putMaxAttempts {
+			return fmt.Errorf("%w: %s was at revision %d, expected %d after %d attempts", ErrConflict, key, revision, expected, attempt)
+		}
+		// the first retry uses the revision just read, and only later retries are contended
+		if attempt > 1 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+			delay *= 2
+		}
 	}
-	return c.Update(ctx, key, val.Modified, value)
 }
 
 func (c *client) Create(ctx context.Context, key string, value []byte) error {
+	return c.CreateWithLease(ctx, key, value, 0)
+}
+
+// CreateWithLease creates the key, attached to a lease obtained from Grant.
+func (c *client) CreateWithLease(ctx context.Context, key string, value []byte, lease int64) error {
 	resp, err := c.c.Txn(ctx).
 		If(clientv3.Compare(clientv3.ModRevision(key), "=", 0)).
-		Then(clientv3.OpPut(key, string(value))).
+		Then(clientv3.OpPut(key, string(value), clientv3.WithLease(clientv3.LeaseID(lease)))).
 		Commit()
 	if err != nil {
 		return err
@@ -116,9 +242,14 @@ func (c *client) Create(ctx context.Context, key string, value []byte) error {
 }
 
 func (c *client) Update(ctx context.Context, key string, revision int64, value []byte) error {
+	return c.UpdateWithLease(ctx, key, revision, value, 0)
+}
+
+// UpdateWithLease updates the key if its current revision matches, attaching it to a lease obtained from Grant.
+func (c *client) UpdateWithLease(ctx context.Context, key string, revision int64, value []byte, lease int64) error {
 	resp, err := c.c.Txn(ctx).
 		If(clientv3.Compare(clientv3.ModRevision(key), "=", revision)).
-		Then(clientv3.OpPut(key, string(value))).
+		Then(clientv3.OpPut(key, string(value), clientv3.WithLease(clientv3.LeaseID(lease)))).
 		Else(clientv3.OpGet(key)).
 		Commit()
 	if err != nil {
@@ -145,6 +276,112 @@ func (c *client) Delete(ctx context.Context, key string, revision int64) error {
 	return nil
 }
 
+// Grant returns a lease ID with the requested TTL in seconds. Kine leases are not
+// kept alive or revoked; keys attached to the lease are deleted once the TTL expires
+// after their last modification.
+func (c *client) Grant(ctx context.Context, ttl int64) (int64, error) {
+	resp, err := c.c.Grant(ctx, ttl)
+	if err != nil {
+		return 0, err
+	}
+	return int64(resp.ID), nil
+}
+
+// Watch watches a single key, or all keys under the prefix if the key ends with a "/".
+// Events are delivered starting at the requested revision, or the current revision if zero. The watch
+// is transparently re-established if the stream is interrupted; a watch from the current revision resumes
+// after the revision reported when it was created, if no events have been received. If the requested revision
+// has been compacted, a response with ErrCompacted is sent and the watch resumes from the compact revision.
+// The returned channel is closed when the context is cancelled.
+func (c *client) Watch(ctx context.Context, key string, revision int64) <-chan WatchResponse {
+	result := make(chan WatchResponse, 100)
+
+	opts := []clientv3.OpOption{clientv3.WithPrevKV(), clientv3.WithCreatedNotify()}
+	if strings.HasSuffix(key, "/") {
+		opts = append(opts, clientv3.WithPrefix())
+	}
+
+	go func() {
+		defer close(result)
+		for {
+			wctx, cancel := context.WithCancel(ctx)
+			wch := c.c.Watch(wctx, key, append(opts, clientv3.WithRev(revision))...)
+			revision = c.watch(ctx, wch, result, revision)
+			cancel()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(watchRetryInterval):
+				logrus.Debugf("Restarting watch on %s at revision %d", key, revision)
+			}
+		}
+	}()
+
+	return result
+}
+
+// watch relays responses from a single watch stream until it is closed or cancelled,
+// and returns the revision that the next watch stream should start at.
+func (c *client) watch(ctx context.Context, wch clientv3.WatchChan, result chan<- WatchResponse, revision int64) int64 {
+	for resp := range wch {
+		if resp.CompactRevision != 0 {
+			if !send(ctx, result, WatchResponse{Revision: resp.Header.Revision, CompactRevision: resp.CompactRevision, Err: ErrCompacted}) {
+				return revision
+			}
+			return resp.CompactRevision
+		}
+		if err := resp.Err(); err != nil {
+			send(ctx, result, WatchResponse{Revision: resp.Header.Revision, Err: err})
+			return revision
+		}
+		if resp.Created {
+			// a watch from the current revision resumes after the revision it was created at,
+			// so that writes are not lost if the stream is interrupted before the first event
+			if revision == 0 && resp.Header.Revision > 0 {
+				revision = resp.Header.Revision + 1
+			}
+			continue
+		}
+		if len(resp.Events) == 0 {
+			// progress notifications indicate that all events up to the header revision have been sent
+			if resp.IsProgressNotify() && resp.Header.Revision >= revision {
+				revision = resp.Header.Revision + 1
+			}
+			continue
+		}
+
+		wr := WatchResponse{
+			Events:   make([]Event, 0, len(resp.Events)),
+			Revision: resp.Header.Revision,
+		}
+		for _, e := range resp.Events {
+			event := Event{
+				Delete: e.Type == mvccpb.DELETE,
+				Create: e.IsCreate(),
+				Value:  toValue(e.Kv),
+			}
+			if e.PrevKv != nil && e.PrevKv.ModRevision != 0 {
+				prev := toValue(e.PrevKv)
+				event.Prev = &prev
+			}
+			wr.Events = append(wr.Events, event)
+			revision = e.Kv.ModRevision + 1
+		}
+		if !send(ctx, result, wr) {
+			return revision
+		}
+	}
+	return revision
+}
+
+// Txn returns a raw transaction. Note that kine only supports the specific
+// transaction shapes used by the other Client methods and the Kubernetes apiserver;
+// other comparisons or operations will be rejected by the server.
+func (c *client) Txn(ctx context.Context) clientv3.Txn {
+	return c.c.Txn(ctx)
+}
+
 func (c *client) Compact(ctx context.Context, revision int64) (int64, error) {
 	resp, err := c.c.Compact(ctx, revision)
 	if resp != nil {
@@ -156,3 +393,21 @@ func (c *client) Compact(ctx context.Context, revision int64) (int64, error) {
 func (c *client) Close() error {
 	return c.c.Close()
 }
+
+func send(ctx context.Context, result chan<- WatchResponse, wr WatchResponse) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case result <- wr:
+		return true
+	}
+}
+
+func toValue(kv *mvccpb.KeyValue) Value {
+	return Value{
+		Key:      kv.Key,
+		Data:     kv.Value,
+		Modified: kv.ModRevision,
+		Lease:    kv.Lease,
+	}
+}
@@ -0,0 +1,225 @@
+//go:build cgo
+// +build cgo
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/k3s-io/kine/pkg/endpoint"
+	"google.golang.org/grpc"
+)
+
+func setupClient(t *testing.T) Client {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	grpcServer := grpc.NewServer()
+	t.Cleanup(grpcServer.Stop)
+
+	config, err := endpoint.Listen(ctx, endpoint.Config{
+		GRPCServer:       grpcServer,
+		Listener:         "http://127.0.0.1:0",
+		Endpoint:         "sqlite://" + filepath.Join(t.TempDir(), "state.db") + "?_journal=WAL&cache=shared&_busy_timeout=30000",
+		NotifyInterval:   time.Second,
+		CompactInterval:  time.Minute,
+		CompactTimeout:   time.Second,
+		CompactBatchSize: 1000,
+		PollBatchSize:    500,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestClient_Put(t *testing.T) {
+	c := setupClient(t)
+	ctx := context.Background()
+
+	for _, value := range []string{"1", "2"} {
+		if err := c.Put(ctx, "/test/put", []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+		v, err := c.Get(ctx, "/test/put")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(v.Data) != value {
+			t.Fatalf("expected %s, got %s", value, v.Data)
+		}
+	}
+
+	if _, err := c.Get(ctx, "/test/missing"); err != ErrNotFound {
+		t.Fatalf("expected %v, got %v", ErrNotFound, err)
+	}
+}
+
+func TestClient_ListPage(t *testing.T) {
+	c := setupClient(t)
+	ctx := context.Background()
+
+	for i := 0; i < 5; i++ {
+		if err := c.Create(ctx, fmt.Sprintf("/test/list/%d", i), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var keys []string
+	var revision int64
+	startKey := ""
+	for {
+		result, err := c.ListPage(ctx, "/test/list", startKey, 2, revision)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if revision == 0 {
+			if result.Count != 5 {
+				t.Fatalf("expected count 5, got %d", result.Count)
+			}
+			revision = result.Revision
+			// writes after the first page are not visible in later pages
+			if err := c.Create(ctx, "/test/list/5", []byte("v")); err != nil {
+				t.Fatal(err)
+			}
+		} else if result.Revision != revision {
+			t.Fatalf("expected revision %d, got %d", revision, result.Revision)
+		}
+		for _, v := range result.Values {
+			keys = append(keys, string(v.Key))
+		}
+		if !result.More {
+			break
+		}
+		startKey = result.Continue
+	}
+
+	if fmt.Sprint(keys) != "[/test/list/0 /test/list/1 /test/list/2 /test/list/3 /test/list/4]" {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+}
+
+func TestClient_CreateWithLease(t *testing.T) {
+	c := setupClient(t)
+	ctx := context.Background()
+
+	lease, err := c.Grant(ctx, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease != 60 {
+		t.Fatalf("expected lease 60, got %d", lease)
+	}
+	if err := c.CreateWithLease(ctx, "/test/lease", []byte("v"), lease); err != nil {
+		t.Fatal(err)
+	}
+	v, err := c.Get(ctx, "/test/lease")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Lease != lease {
+		t.Fatalf("expected lease %d, got %d", lease, v.Lease)
+	}
+
+	if err := c.CreateWithLease(ctx, "/test/lease", []byte("v"), lease); err == nil {
+		t.Fatal("expected error creating existing key")
+	}
+}
+
+func TestClient_Watch(t *testing.T) {
+	c := setupClient(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := c.Create(ctx, "/test/watch/a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	a, err := c.Get(ctx, "/test/watch/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Update(ctx, "/test/watch/a", a.Modified, []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	a, err = c.Get(ctx, "/test/watch/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete(ctx, "/test/watch/a", a.Modified); err != nil {
+		t.Fatal(err)
+	}
+
+	// events are replayed from the requested revision
+	wch := c.Watch(ctx, "/test/watch/", a.Modified-1)
+	var events []Event
+	timeout := time.After(10 * time.Second)
+	for len(events) < 3 {
+		select {
+		case wr := <-wch:
+			if wr.Err != nil {
+				t.Fatal(wr.Err)
+			}
+			events = append(events, wr.Events...)
+		case <-timeout:
+			t.Fatalf("timed out waiting for events, got %d", len(events))
+		}
+	}
+
+	if !events[0].Create || string(events[0].Value.Data) != "1" {
+		t.Fatalf("expected create event, got %+v", events[0])
+	}
+	if events[1].Create || events[1].Prev == nil || string(events[1].Prev.Data) != "1" || string(events[1].Value.Data) != "2" {
+		t.Fatalf("expected update event with previous value, got %+v", events[1])
+	}
+	if !events[2].Delete {
+		t.Fatalf("expected delete event, got %+v", events[2])
+	}
+
+	cancel()
+	for range wch {
+	}
+}
+
+func TestClient_WatchCurrent(t *testing.T) {
+	c := setupClient(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := c.Create(ctx, "/test/watch/a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	wch := c.Watch(ctx, "/test/watch/", 0)
+	if err := c.Create(ctx, "/test/watch/b", []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+
+	timeout := time.After(10 * time.Second)
+	for found := false; !found; {
+		select {
+		case wr := <-wch:
+			if wr.Err != nil {
+				t.Fatal(wr.Err)
+			}
+			for _, e := range wr.Events {
+				found = found || string(e.Value.Key) == "/test/watch/b"
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for event")
+		}
+	}
+
+	cancel()
+	for range wch {
+	}
+}
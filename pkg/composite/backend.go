@@ -0,0 +1,838 @@
+// Package composite provides a backend that stores keys in different backends by prefix, while
+// presenting a single revision space to clients.
+package composite
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/k3s-io/kine/pkg/server"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// watchHistory is the number of recent events retained to replay to new watches.
+	watchHistory = 10000
+	// syncTimeout is the maximum time to wait for a revision returned by a backend to be
+	// sequenced, before translating it on a best-effort basis.
+	syncTimeout = 5 * time.Second
+	// retryInterval is the delay before restarting a failed backend watch.
+	retryInterval = time.Second
+)
+
+// explicit interface checks
+var (
+	_ server.Backend               = (*Backend)(nil)
+	_ server.ManagedCompactBackend = (*Backend)(nil)
+)
+
+// Route stores keys with a prefix in the backend at an endpoint.
+type Route struct {
+	Prefix   string
+	Endpoint string
+}
+
+// ParseRoutes parses routes in the form prefix=endpoint.
+func ParseRoutes(routes []string) ([]Route, error) {
+	var result []Route
+	for _, route := range routes {
+		prefix, endpoint, ok := strings.Cut(route, "=")
+		if !ok || endpoint == "" {
+			return nil, errors.Errorf("invalid route %q: expected prefix=endpoint", route)
+		}
+		result = append(result, Route{Prefix: prefix, Endpoint: endpoint})
+	}
+	return result, nil
+}
+
+// Backend routes each key to the backend with the longest matching route prefix, or to the
+// default backend if no route matches.
+//
+// The revisions of the backends are merged into a single revision space by sequencing the
+// events from all backends in the order that they are received, and offsetting the revisions
+// of each backend by the sum of the current revisions of all other backends at the time. The
+// merged revision is therefore the sum of the current revisions of all backends, and increases
+// monotonically as long as the revisions of the backends do. Revisions from before the backend
+// was started can be used to update keys, but reads at those revisions fail as compacted, as the
+// backends cannot be read at a consistent point in time. Reads at revisions that are no longer
+// retained for watches also fail as compacted, and keys last modified before then may be
+// reported with later revisions than they were originally. The revision space is specific to this
+// server, so clients must not be balanced across multiple servers sharing the same backends.
+type Backend struct {
+	// backends are ordered by descending prefix length, so that the default backend is last.
+	backends []*routedBackend
+
+	mu sync.Mutex
+	// observed is closed and replaced whenever events are sequenced.
+	observed chan struct{}
+	// revision is the current merged revision.
+	revision int64
+	// startRevision is the lowest merged revision that can be read.
+	startRevision int64
+	// compactRevision is the highest merged revision that can no longer be watched.
+	compactRevision int64
+	// events are the most recently sequenced events, in revision order.
+	events   []*server.Event
+	watchers map[*watcher]struct{}
+
+	wg     sync.WaitGroup
+	cancel context.CancelFunc
+}
+
+type routedBackend struct {
+	prefix  string
+	backend server.Backend
+	// revision is the latest revision of the backend that has been sequenced.
+	revision int64
+	// segments map revisions of the backend to merged revisions, in revision order.
+	segments []segment
+	// closed is set once the backend has been closed, so that it is not closed again if the
+	// composite backend is closed after failing to start.
+	closed bool
+}
+
+// segment is a range of backend revisions with the same offset to the merged revision.
+type segment struct {
+	revision int64
+	offset   int64
+}
+
+type watcher struct {
+	prefix      string
+	checkPrefix bool
+	revision    int64
+	ch          chan []*server.Event
+}
+
+// NewBackend returns a backend that stores keys with the prefixes in the routes in the
+// corresponding backends, and all other keys in the default backend. Route prefixes must not
+// be empty or nested within each other.
+func NewBackend(defaultBackend server.Backend, routes map[string]server.Backend) (*Backend, error) {
+	b := &Backend{
+		observed: make(chan struct{}),
+		watchers: map[*watcher]struct{}{},
+	}
+	for prefix, backend := range routes {
+		if prefix == "" {
+			return nil, errors.New("route prefix must not be empty")
+		}
+		for other := range routes {
+			if other != prefix && strings.HasPrefix(prefix, other) {
+				return nil, errors.Errorf("route %q is nested within route %q", prefix, other)
+			}
+		}
+		b.backends = append(b.backends, &routedBackend{prefix: prefix, backend: backend})
+	}
+	sort.Slice(b.backends, func(i, j int) bool {
+		return len(b.backends[i].prefix) > len(b.backends[j].prefix)
+	})
+	b.backends = append(b.backends, &routedBackend{backend: defaultBackend})
+	return b, nil
+}
+
+// Start starts all backends, and then starts sequencing their events.
+func (b *Backend) Start(ctx context.Context) error {
+	for i, r := range b.backends {
+		if err := r.backend.Start(ctx); err != nil {
+			// the backends that have already been started must not be left running
+			closeBackends(b.backends[:i])
+			return errors.Wrapf(err, "starting backend for route %q", r.prefix)
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, r := range b.backends {
+		rev, err := r.backend.CurrentRevision(ctx)
+		if err != nil {
+			closeBackends(b.backends)
+			return errors.Wrapf(err, "getting current revision for route %q", r.prefix)
+		}
+		r.revision = rev
+		b.revision += rev
+	}
+	for _, r := range b.backends {
+		r.segments = []segment{{offset: b.revision - r.revision}}
+	}
+	b.startRevision = b.revision
+	b.compactRevision = b.revision
+
+	ctx, b.cancel = context.WithCancel(ctx)
+	for _, r := range b.backends {
+		b.wg.Add(1)
+		go func(r *routedBackend) {
+			defer b.wg.Done()
+			b.follow(ctx, r)
+		}(r)
+	}
+	return nil
+}
+
+// Close stops sequencing events, closes all watches, and closes all backends.
+func (b *Backend) Close() error {
+	if b.cancel != nil {
+		b.cancel()
+	}
+	b.wg.Wait()
+
+	b.mu.Lock()
+	for w := range b.watchers {
+		b.unwatch(w)
+	}
+	b.mu.Unlock()
+
+	return closeBackends(b.backends)
+}
+
+// closeBackends closes the backends that have not already been closed, returning the first error.
+func closeBackends(backends []*routedBackend) error {
+	var err error
+	for _, r := range backends {
+		if r.closed {
+			continue
+		}
+		r.closed = true
+		if cerr := r.backend.Close(); cerr != nil {
+			logrus.Errorf("Failed to close backend for route %q: %v", r.prefix, cerr)
+			if err == nil {
+				err = errors.Wrapf(cerr, "closing backend for route %q", r.prefix)
+			}
+		}
+	}
+	return err
+}
+
+// follow sequences the events from the backend until the context is cancelled, restarting
+// the watch if it fails.
+func (b *Backend) follow(ctx context.Context, r *routedBackend) {
+	for {
+		b.mu.Lock()
+		rev := r.revision
+		b.mu.Unlock()
+
+		wr := r.backend.Watch(ctx, "/", rev+1)
+		if wr.CompactRevision != 0 {
+			// the events up to the compact revision have been lost, and can never be sequenced
+			logrus.Errorf("Route %q watch at revision %d failed: %v; events up to revision %d will not be sent to watches", r.prefix, rev+1, server.ErrCompacted, wr.CompactRevision)
+			b.mu.Lock()
+			if wr.CompactRevision > r.revision {
+				b.advance(r, wr.CompactRevision)
+				b.notify()
+			}
+			b.mu.Unlock()
+		} else {
+			for events := range wr.Events {
+				b.observe(r, events)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryInterval):
+		}
+		logrus.Warnf("Route %q watch closed at revision %d, restarting", r.prefix, rev)
+	}
+}
+
+// observe assigns merged revisions to events from the backend, and sends them to any watches.
+func (b *Backend) observe(r *routedBackend, events []*server.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, event := range events {
+		rev := event.KV.ModRevision
+		if rev <= r.revision {
+			continue
+		}
+		b.advance(r, rev)
+
+		if b.route(event.KV.Key) != r {
+			continue
+		}
+		event = &server.Event{
+			Create: event.Create,
+			Delete: event.Delete,
+			KV:     r.translate(event.KV, b.revision),
+			PrevKV: r.translate(event.PrevKV, b.revision),
+		}
+		b.append(event)
+		for w := range b.watchers {
+			if !w.matches(event) {
+				continue
+			}
+			select {
+			case w.ch <- []*server.Event{event}:
+			default:
+				// Slow consumer, drop
+				b.unwatch(w)
+			}
+		}
+	}
+
+	b.notify()
+}
+
+// advance sets the latest sequenced revision of the backend, and advances the merged revision
+// by the same amount. The caller must hold the lock.
+func (b *Backend) advance(r *routedBackend, rev int64) {
+	// the offset is the sum of the current revisions of all other backends
+	offset := b.revision - r.revision
+	if last := r.segments[len(r.segments)-1]; last.offset != offset {
+		r.segments = append(r.segments, segment{revision: r.revision + 1, offset: offset})
+	}
+	r.revision = rev
+	b.revision = rev + offset
+}
+
+// notify wakes any writes waiting for revisions to be sequenced.
+// The caller must hold the lock.
+func (b *Backend) notify() {
+	close(b.observed)
+	b.observed = make(chan struct{})
+}
+
+// append adds an event to the watch history, dropping the oldest events if it is full.
+// The caller must hold the lock.
+func (b *Backend) append(event *server.Event) {
+	if len(b.events) >= watchHistory {
+		drop := watchHistory / 10
+		b.compactRevision = b.events[drop-1].KV.ModRevision
+		b.events = append([]*server.Event(nil), b.events[drop:]...)
+		b.prune()
+	}
+	b.events = append(b.events, event)
+}
+
+// prune advances the start revision to the compact revision, and drops the segments that only
+// map revisions below it. The first remaining segment of each backend is extended back to
+// revision zero, so that revisions of keys last modified before the start revision can still
+// be translated. The caller must hold the lock.
+func (b *Backend) prune() {
+	b.startRevision = b.compactRevision
+	for _, r := range b.backends {
+		i := sort.Search(len(r.segments), func(i int) bool {
+			s := r.segments[i]
+			return s.revision+s.offset > b.startRevision
+		})
+		if i > 1 {
+			r.segments = append([]segment{{offset: r.segments[i-1].offset}}, r.segments[i:]...)
+		}
+	}
+}
+
+// route returns the backend that stores the key.
+func (b *Backend) route(key string) *routedBackend {
+	for _, r := range b.backends {
+		if strings.HasPrefix(key, r.prefix) {
+			return r
+		}
+	}
+	// unreachable, as the default backend matches every key
+	return b.backends[len(b.backends)-1]
+}
+
+// merged returns the merged revision for a revision of the backend. Revisions that have not
+// yet been sequenced are offset from the current merged revision.
+// The caller must hold the lock.
+func (r *routedBackend) merged(rev, current int64) int64 {
+	if rev <= 0 {
+		return rev
+	}
+	if rev > r.revision {
+		return rev + current - r.revision
+	}
+	i := sort.Search(len(r.segments), func(i int) bool {
+		return r.segments[i].revision > rev
+	})
+	return rev + r.segments[i-1].offset
+}
+
+// backendRevision returns the latest revision of the backend at or below a merged revision,
+// and whether the merged revision is exactly that revision of the backend. Zero is returned if
+// the backend has no revisions at or below the merged revision.
+// The caller must hold the lock.
+func (r *routedBackend) backendRevision(merged int64) (int64, bool) {
+	i := sort.Search(len(r.segments), func(i int) bool {
+		s := r.segments[i]
+		return s.revision+s.offset > merged
+	})
+	if i == 0 {
+		return 0, false
+	}
+	s := r.segments[i-1]
+	rev := merged - s.offset
+	exact := true
+	if i < len(r.segments) && rev >= r.segments[i].revision {
+		rev, exact = r.segments[i].revision-1, false
+	}
+	if rev > r.revision {
+		rev, exact = r.revision, false
+	}
+	if rev < s.revision || rev <= 0 {
+		// the first segment starts at revision zero, but zero is not a revision
+		return 0, false
+	}
+	return rev, exact
+}
+
+// translate returns a copy of the key-value with merged revisions.
+// The caller must hold the lock.
+func (r *routedBackend) translate(kv *server.KeyValue, current int64) *server.KeyValue {
+	if kv == nil {
+		return nil
+	}
+	result := *kv
+	result.CreateRevision = r.merged(kv.CreateRevision, current)
+	result.ModRevision = r.merged(kv.ModRevision, current)
+	return &result
+}
+
+// sync waits for the events from the backend up to the revision to be sequenced, so that the
+// revision can be translated consistently. Revisions of keys outside the root prefix are not
+// watched, and are never sequenced; these are translated on a best-effort basis, as are
+// revisions that are not sequenced within the sync timeout.
+func (b *Backend) sync(ctx context.Context, r *routedBackend, key string, rev int64) error {
+	if !strings.HasPrefix(key, "/") {
+		return nil
+	}
+	timeout := time.NewTimer(syncTimeout)
+	defer timeout.Stop()
+	for {
+		b.mu.Lock()
+		if r.revision >= rev {
+			b.mu.Unlock()
+			return nil
+		}
+		observed := b.observed
+		b.mu.Unlock()
+
+		select {
+		case <-observed:
+		case <-timeout.C:
+			logrus.Warnf("Timed out waiting for route %q to sequence revision %d", r.prefix, rev)
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// syncKVs waits for the latest revision of the key-values to be sequenced, and returns copies
+// with merged revisions.
+func (b *Backend) syncKVs(ctx context.Context, r *routedBackend, kvs ...*server.KeyValue) ([]*server.KeyValue, error) {
+	for _, kv := range kvs {
+		if kv != nil {
+			if err := b.sync(ctx, r, kv.Key, kv.ModRevision); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	result := make([]*server.KeyValue, 0, len(kvs))
+	for _, kv := range kvs {
+		result = append(result, r.translate(kv, b.revision))
+	}
+	return result, nil
+}
+
+// read returns the revision of each backend to read at for a merged revision. A zero merged
+// revision reads the current revision of all backends.
+func (b *Backend) read(revision int64) (map[*routedBackend]int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	revs := map[*routedBackend]int64{}
+	if revision == 0 {
+		return revs, nil
+	}
+	if revision > b.revision {
+		return nil, server.ErrFutureRev
+	}
+	if revision < b.startRevision {
+		return nil, server.ErrCompacted
+	}
+	for _, r := range b.backends {
+		revs[r], _ = r.backendRevision(revision)
+	}
+	return revs, nil
+}
+
+// write returns the revision of the backend that must match the current revision of a key for
+// a conditional write to succeed. If the merged revision is not a revision of the backend, a
+// revision that can never match is returned.
+func (b *Backend) write(r *routedBackend, revision int64) int64 {
+	if revision == 0 {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	rev, exact := r.backendRevision(revision)
+	if !exact {
+		return -1
+	}
+	return rev
+}
+
+// currentRevision returns the merged revision after waiting for the revision of the backend to
+// be sequenced.
+func (b *Backend) currentRevision(ctx context.Context, r *routedBackend, key string, rev int64) (int64, error) {
+	if err := b.sync(ctx, r, key, rev); err != nil {
+		return 0, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return max(b.revision, r.merged(rev, b.revision)), nil
+}
+
+func (b *Backend) Get(ctx context.Context, key, rangeEnd string, limit, revision int64) (int64, *server.KeyValue, error) {
+	r := b.route(key)
+	revs, err := b.read(revision)
+	if err != nil {
+		return 0, nil, err
+	}
+	rev, ok := revs[r]
+	if ok && rev == 0 {
+		// the backend has no revisions at the requested revision
+		return revision, nil, nil
+	}
+
+	_, kv, err := r.backend.Get(ctx, key, rangeEnd, limit, rev)
+	if err != nil {
+		return 0, nil, err
+	}
+	kvs, err := b.syncKVs(ctx, r, kv)
+	if err != nil {
+		return 0, nil, err
+	}
+	return b.header(revision), kvs[0], nil
+}
+
+func (b *Backend) Create(ctx context.Context, key string, value []byte, lease int64) (int64, error) {
+	r := b.route(key)
+	rev, err := r.backend.Create(ctx, key, value, lease)
+	if err != nil {
+		return b.header(0), err
+	}
+	return b.currentRevision(ctx, r, key, rev)
+}
+
+func (b *Backend) Delete(ctx context.Context, key string, revision int64) (int64, *server.KeyValue, bool, error) {
+	r := b.route(key)
+	rev, kv, deleted, err := r.backend.Delete(ctx, key, b.write(r, revision))
+	if err != nil {
+		return b.header(0), nil, false, err
+	}
+	kvs, err := b.syncKVs(ctx, r, kv)
+	if err != nil {
+		return 0, nil, false, err
+	}
+	if deleted && kv != nil {
+		if rev, err = b.currentRevision(ctx, r, key, rev); err != nil {
+			return 0, nil, false, err
+		}
+		return rev, kvs[0], deleted, nil
+	}
+	return b.header(0), kvs[0], deleted, nil
+}
+
+func (b *Backend) Update(ctx context.Context, key string, value []byte, revision, lease int64) (int64, *server.KeyValue, bool, error) {
+	r := b.route(key)
+	rev, kv, updated, err := r.backend.Update(ctx, key, value, b.write(r, revision), lease)
+	if err != nil {
+		return b.header(0), nil, false, err
+	}
+	kvs, err := b.syncKVs(ctx, r, kv)
+	if err != nil {
+		return 0, nil, false, err
+	}
+	if updated {
+		if rev, err = b.currentRevision(ctx, r, key, rev); err != nil {
+			return 0, nil, false, err
+		}
+		return rev, kvs[0], updated, nil
+	}
+	return b.header(0), kvs[0], updated, nil
+}
+
+// query is a list or count of keys in a single backend.
+type query struct {
+	backend  *routedBackend
+	prefix   string
+	startKey string
+	revision int64
+	// exclude are the prefixes of routes to other backends within the query prefix.
+	exclude []string
+}
+
+// queries returns the queries needed to list the keys with the prefix from all backends, at
+// the merged revision.
+func (b *Backend) queries(prefix, startKey string, revision int64) ([]query, error) {
+	revs, err := b.read(revision)
+	if err != nil {
+		return nil, err
+	}
+
+	owner := b.route(prefix)
+	q := query{backend: owner, prefix: prefix, startKey: startKey, revision: revs[owner]}
+	var queries []query
+	if prefix == "" || strings.HasSuffix(prefix, "/") {
+		for _, r := range b.backends {
+			if r == owner || !strings.HasPrefix(r.prefix, prefix) {
+				continue
+			}
+			// the route is within the prefix, so list the route prefix from the start key
+			start := startKey
+			if start < r.prefix {
+				start = r.prefix
+			}
+			q.exclude = append(q.exclude, r.prefix)
+			queries = append(queries, query{backend: r, prefix: r.prefix, startKey: start, revision: revs[r]})
+		}
+	}
+	queries = append(queries, q)
+
+	result := queries[:0]
+	for _, q := range queries {
+		if _, ok := revs[q.backend]; ok && q.revision == 0 {
+			// the backend has no revisions at the requested revision
+			continue
+		}
+		result = append(result, q)
+	}
+	return result, nil
+}
+
+// excluded returns true if the key is stored by the backend, but is routed to another backend.
+func (q *query) excluded(key string) bool {
+	for _, prefix := range q.exclude {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Backend) List(ctx context.Context, prefix, startKey string, limit, revision int64) (int64, []*server.KeyValue, error) {
+	queries, err := b.queries(prefix, startKey, revision)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var result []*server.KeyValue
+	for _, q := range queries {
+		kvs, err := b.list(ctx, q, limit)
+		if err != nil {
+			return 0, nil, err
+		}
+		result = append(result, kvs...)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Key < result[j].Key
+	})
+	if limit > 0 && int64(len(result)) > limit {
+		result = result[:limit]
+	}
+	return b.header(revision), result, nil
+}
+
+// list lists up to limit keys from a single backend, skipping any keys routed to other backends.
+func (b *Backend) list(ctx context.Context, q query, limit int64) ([]*server.KeyValue, error) {
+	var result []*server.KeyValue
+	startKey := q.startKey
+	for {
+		_, kvs, err := q.backend.backend.List(ctx, q.prefix, startKey, limit, q.revision)
+		if err != nil {
+			return nil, err
+		}
+		for _, kv := range kvs {
+			if !q.excluded(kv.Key) {
+				result = append(result, kv)
+			}
+		}
+		if limit <= 0 || int64(len(kvs)) < limit || int64(len(result)) >= limit {
+			break
+		}
+		startKey = kvs[len(kvs)-1].Key
+	}
+	return b.syncKVs(ctx, q.backend, result...)
+}
+
+func (b *Backend) Count(ctx context.Context, prefix, startKey string, revision int64) (int64, int64, error) {
+	queries, err := b.queries(prefix, startKey, revision)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var total int64
+	for _, q := range queries {
+		_, count, err := q.backend.backend.Count(ctx, q.prefix, q.startKey, q.revision)
+		if err != nil {
+			return 0, 0, err
+		}
+		// subtract any keys stored by the backend that are routed to other backends
+		for _, exclude := range q.exclude {
+			start := q.startKey
+			if start < exclude {
+				start = exclude
+			}
+			_, excluded, err := q.backend.backend.Count(ctx, exclude, start, q.revision)
+			if err != nil {
+				return 0, 0, err
+			}
+			count -= excluded
+		}
+		total += count
+	}
+	return b.header(revision), total, nil
+}
+
+// header returns the revision for a response header: the requested revision if set, or the
+// current merged revision.
+func (b *Backend) header(revision int64) int64 {
+	if revision != 0 {
+		return revision
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.revision
+}
+
+// Watch returns the events for keys matching the prefix, replaying retained events from the
+// requested revision. The compact revision is set if events at the requested revision are no
+// longer retained.
+func (b *Backend) Watch(ctx context.Context, prefix string, revision int64) server.WatchResult {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if revision > 0 && revision <= b.compactRevision {
+		return server.WatchResult{
+			CurrentRevision: b.revision,
+			CompactRevision: b.compactRevision,
+		}
+	}
+
+	w := &watcher{
+		prefix:      prefix,
+		checkPrefix: strings.HasSuffix(prefix, "/"),
+		revision:    revision,
+		ch:          make(chan []*server.Event, 100),
+	}
+
+	i := sort.Search(len(b.events), func(i int) bool {
+		return b.events[i].KV.ModRevision >= revision
+	})
+	var replay []*server.Event
+	if revision > 0 {
+		for _, event := range b.events[i:] {
+			if w.matches(event) {
+				replay = append(replay, event)
+			}
+		}
+	}
+	if len(replay) > 0 {
+		w.ch <- replay
+	}
+	b.watchers[w] = struct{}{}
+
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		b.unwatch(w)
+		b.mu.Unlock()
+	}()
+
+	return server.WatchResult{
+		CurrentRevision: b.revision,
+		Events:          w.ch,
+	}
+}
+
+// unwatch closes the watcher's channel, if it has not already been closed.
+// The caller must hold the lock.
+func (b *Backend) unwatch(w *watcher) {
+	if _, ok := b.watchers[w]; ok {
+		close(w.ch)
+		delete(b.watchers, w)
+	}
+}
+
+func (w *watcher) matches(event *server.Event) bool {
+	if event.KV.ModRevision < w.revision {
+		return false
+	}
+	return (w.checkPrefix && strings.HasPrefix(event.KV.Key, w.prefix)) || event.KV.Key == w.prefix
+}
+
+func (b *Backend) DbSize(ctx context.Context) (int64, error) {
+	var size int64
+	for _, r := range b.backends {
+		s, err := r.backend.DbSize(ctx)
+		if err != nil {
+			return 0, err
+		}
+		size += s
+	}
+	return size, nil
+}
+
+func (b *Backend) CurrentRevision(ctx context.Context) (int64, error) {
+	return b.header(0), nil
+}
+
+// Compact compacts each backend to its revision at the merged revision.
+func (b *Backend) Compact(ctx context.Context, revision int64) (int64, error) {
+	return b.compact(ctx, revision, func(backend server.Backend) func(context.Context, int64) (int64, error) {
+		return backend.Compact
+	})
+}
+
+// ManagedCompact compacts each backend through its compactor towards its revision at the
+// merged revision. Nothing is compacted unless every backend has a compactor.
+func (b *Backend) ManagedCompact(ctx context.Context, revision int64) (int64, error) {
+	for _, r := range b.backends {
+		if _, ok := r.backend.(server.ManagedCompactBackend); !ok {
+			return b.header(0), server.ErrManagedCompactNotSupported
+		}
+	}
+	return b.compact(ctx, revision, func(backend server.Backend) func(context.Context, int64) (int64, error) {
+		return backend.(server.ManagedCompactBackend).ManagedCompact
+	})
+}
+
+func (b *Backend) compact(ctx context.Context, revision int64, compactor func(server.Backend) func(context.Context, int64) (int64, error)) (int64, error) {
+	revs, err := b.read(revision)
+	if err != nil {
+		return b.header(0), err
+	}
+	for _, r := range b.backends {
+		if rev := revs[r]; rev > 0 {
+			if _, err := compactor(r.backend)(ctx, rev); err != nil && err != server.ErrCompacted {
+				return b.header(0), errors.Wrapf(err, "compacting backend for route %q", r.prefix)
+			}
+		}
+	}
+	return b.header(0), nil
+}
+
+// Capabilities returns the capabilities shared by all backends. Gaps are filled if any backend
+// fills gaps. History is not provided, as it is not merged across backends.
+func (b *Backend) Capabilities() server.Capabilities {
+	capabilities := server.Capabilities{Compact: true}
+	for _, r := range b.backends {
+		var c server.Capabilities
+		if cb, ok := r.backend.(server.CapabilitiesBackend); ok {
+			c = cb.Capabilities()
+		}
+		capabilities.Compact = capabilities.Compact && c.Compact
+		capabilities.GapFill = capabilities.GapFill || c.GapFill
+		capabilities.Encryption = capabilities.Encryption || c.Encryption
+	}
+	return capabilities
+}
@@ -0,0 +1,316 @@
+package composite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/k3s-io/kine/pkg/drivers/memory"
+	"github.com/k3s-io/kine/pkg/logstructured"
+	"github.com/k3s-io/kine/pkg/server"
+	"github.com/k3s-io/kine/pkg/server/backendtest"
+)
+
+func noErr(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expEqualErr(t *testing.T, want, got error) {
+	t.Helper()
+	if !errors.Is(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func expEqual[T comparable](t *testing.T, want, got T) {
+	t.Helper()
+	if got != want {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func newMemoryBackend(t *testing.T) server.Backend {
+	log, err := memory.NewMemoryLog("", 0, 0)
+	noErr(t, err)
+	return logstructured.New(log)
+}
+
+// setupBackend returns a backend that stores events in a separate backend. Each backend
+// creates a health check key at revision 1 when started, so the merged revision starts at 2.
+func setupBackend(t *testing.T) *Backend {
+	b, err := NewBackend(newMemoryBackend(t), map[string]server.Backend{
+		"/registry/events/": newMemoryBackend(t),
+	})
+	noErr(t, err)
+	noErr(t, b.Start(context.Background()))
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+func TestParseRoutes(t *testing.T) {
+	routes, err := ParseRoutes([]string{"/registry/events/=sqlite://events.db?_journal=WAL"})
+	noErr(t, err)
+	expEqual(t, 1, len(routes))
+	expEqual(t, "/registry/events/", routes[0].Prefix)
+	expEqual(t, "sqlite://events.db?_journal=WAL", routes[0].Endpoint)
+
+	_, err = ParseRoutes([]string{"/registry/events/"})
+	if err == nil {
+		t.Fatal("expected error for route without endpoint")
+	}
+}
+
+func TestNewBackend_Nested(t *testing.T) {
+	_, err := NewBackend(newMemoryBackend(t), map[string]server.Backend{
+		"/registry/":        newMemoryBackend(t),
+		"/registry/events/": newMemoryBackend(t),
+	})
+	if err == nil {
+		t.Fatal("expected error for nested routes")
+	}
+}
+
+func TestBackend(t *testing.T) {
+	backendtest.Run(t, func(t *testing.T) server.Backend { return setupBackend(t) })
+}
+
+// TestBackend_Revisions checks that writes to each backend are assigned merged revisions, and
+// that reads and conditional writes use the merged revisions.
+func TestBackend_Revisions(t *testing.T) {
+	b := setupBackend(t)
+	ctx := context.Background()
+
+	rev, err := b.Create(ctx, "/registry/pods/a", []byte("1"), 0)
+	noErr(t, err)
+	expEqual(t, 3, rev)
+
+	rev, err = b.Create(ctx, "/registry/events/a", []byte("1"), 0)
+	noErr(t, err)
+	expEqual(t, 4, rev)
+
+	rev, kv, ok, err := b.Update(ctx, "/registry/pods/a", []byte("2"), 3, 0)
+	noErr(t, err)
+	expEqual(t, true, ok)
+	expEqual(t, 5, rev)
+	expEqual(t, 3, kv.CreateRevision)
+	expEqual(t, 5, kv.ModRevision)
+
+	// revision of a write to the other backend
+	_, _, ok, err = b.Update(ctx, "/registry/pods/a", []byte("3"), 4, 0)
+	noErr(t, err)
+	expEqual(t, false, ok)
+
+	rev, kv, ok, err = b.Update(ctx, "/registry/events/a", []byte("2"), 4, 0)
+	noErr(t, err)
+	expEqual(t, true, ok)
+	expEqual(t, 6, rev)
+	expEqual(t, 4, kv.CreateRevision)
+
+	rev, kv, ok, err = b.Delete(ctx, "/registry/pods/a", 5)
+	noErr(t, err)
+	expEqual(t, true, ok)
+	expEqual(t, 7, rev)
+	expEqual(t, "2", string(kv.Value))
+
+	rev, kv, err = b.Get(ctx, "/registry/events/a", "", 1, 0)
+	noErr(t, err)
+	expEqual(t, 7, rev)
+	expEqual(t, 6, kv.ModRevision)
+
+	// read at historical revisions
+	_, kv, err = b.Get(ctx, "/registry/pods/a", "", 1, 4)
+	noErr(t, err)
+	expEqual(t, "1", string(kv.Value))
+	_, kv, err = b.Get(ctx, "/registry/pods/a", "", 1, 6)
+	noErr(t, err)
+	expEqual(t, "2", string(kv.Value))
+	_, kv, err = b.Get(ctx, "/registry/events/a", "", 1, 3)
+	noErr(t, err)
+	if kv != nil {
+		t.Fatalf("expected missing key, got %v", kv)
+	}
+
+	_, _, err = b.Get(ctx, "/registry/pods/a", "", 1, 10)
+	expEqualErr(t, server.ErrFutureRev, err)
+	_, _, err = b.Get(ctx, "/registry/pods/a", "", 1, 1)
+	expEqualErr(t, server.ErrCompacted, err)
+}
+
+// TestBackend_ListRoutes checks that lists and counts spanning routes merge the keys of each
+// backend in order.
+func TestBackend_ListRoutes(t *testing.T) {
+	b := setupBackend(t)
+	ctx := context.Background()
+
+	for _, key := range []string{"/registry/pods/b", "/registry/events/a", "/registry/pods/a", "/registry/events/b", "/registry/services/a"} {
+		_, err := b.Create(ctx, key, nil, 0)
+		noErr(t, err)
+	}
+
+	rev, kvs, err := b.List(ctx, "/registry/", "", 0, 0)
+	noErr(t, err)
+	expEqual(t, 7, rev)
+	var keys []string
+	for _, kv := range kvs {
+		keys = append(keys, kv.Key)
+	}
+	expEqual(t, "/registry/events/a /registry/events/b /registry/health /registry/pods/a /registry/pods/b /registry/services/a", strings.Join(keys, " "))
+
+	_, kvs, err = b.List(ctx, "/registry/", "/registry/events/a", 2, 0)
+	noErr(t, err)
+	expEqual(t, 2, len(kvs))
+	expEqual(t, "/registry/events/b", kvs[0].Key)
+	expEqual(t, "/registry/health", kvs[1].Key)
+
+	_, kvs, err = b.List(ctx, "/registry/events/", "", 0, 0)
+	noErr(t, err)
+	expEqual(t, 2, len(kvs))
+
+	// list at the revision before the second event was created
+	_, kvs, err = b.List(ctx, "/registry/", "", 0, 5)
+	noErr(t, err)
+	expEqual(t, 4, len(kvs))
+
+	_, count, err := b.Count(ctx, "/registry/", "", 0)
+	noErr(t, err)
+	expEqual(t, 6, count)
+
+	_, count, err = b.Count(ctx, "/registry/", "/registry/events/a", 0)
+	noErr(t, err)
+	expEqual(t, 5, count)
+}
+
+// TestBackend_WatchRoutes checks that watches spanning routes receive the events of each
+// backend in revision order.
+func TestBackend_WatchRoutes(t *testing.T) {
+	b := setupBackend(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err := b.Create(ctx, "/registry/pods/a", []byte("1"), 0)
+	noErr(t, err)
+	_, err = b.Create(ctx, "/registry/events/a", []byte("1"), 0)
+	noErr(t, err)
+
+	wr := b.Watch(ctx, "/registry/", 3)
+
+	_, _, _, err = b.Update(ctx, "/registry/pods/a", []byte("2"), 3, 0)
+	noErr(t, err)
+	_, err = b.Create(ctx, "/registry/events/b", []byte("1"), 0)
+	noErr(t, err)
+
+	var events []*server.Event
+	timeout := time.After(5 * time.Second)
+	for len(events) < 4 {
+		select {
+		case e := <-wr.Events:
+			events = append(events, e...)
+		case <-timeout:
+			t.Fatalf("timed out waiting for events, got %d", len(events))
+		}
+	}
+
+	for i, key := range []string{"/registry/pods/a", "/registry/events/a", "/registry/pods/a", "/registry/events/b"} {
+		expEqual(t, key, events[i].KV.Key)
+		expEqual(t, int64(i+3), events[i].KV.ModRevision)
+	}
+	expEqual(t, int64(3), events[2].PrevKV.ModRevision)
+
+	// events from before the backend was started cannot be replayed
+	wr = b.Watch(ctx, "/registry/", 2)
+	expEqual(t, 2, wr.CompactRevision)
+}
+
+func TestBackend_Capabilities(t *testing.T) {
+	b := setupBackend(t)
+	c := b.Capabilities()
+	expEqual(t, false, c.Compact)
+	expEqual(t, false, c.GapFill)
+	expEqual(t, false, c.History)
+}
+
+func TestBackend_PruneSegments(t *testing.T) {
+	b := setupBackend(t)
+	ctx := context.Background()
+
+	// alternating writes to each backend start a new segment for both backends
+	var first int64
+	for i := 0; i < watchHistory; i++ {
+		rev, err := b.Create(ctx, fmt.Sprintf("/registry/pods/%d", i), nil, 0)
+		noErr(t, err)
+		if i == 0 {
+			first = rev
+		}
+		_, err = b.Create(ctx, fmt.Sprintf("/registry/events/%d", i), nil, 0)
+		noErr(t, err)
+	}
+
+	b.mu.Lock()
+	startRevision := b.startRevision
+	for _, r := range b.backends {
+		if len(r.segments) > watchHistory/2+1 {
+			t.Errorf("route %q has %d segments", r.prefix, len(r.segments))
+		}
+		expEqual(t, 0, r.segments[0].revision)
+		for _, s := range r.segments[1:] {
+			if s.revision+s.offset <= startRevision {
+				t.Errorf("route %q has segment starting at merged revision %d below start revision %d", r.prefix, s.revision+s.offset, startRevision)
+			}
+		}
+	}
+	b.mu.Unlock()
+
+	_, _, err := b.Get(ctx, "/registry/pods/0", "", 1, startRevision-1)
+	expEqualErr(t, server.ErrCompacted, err)
+
+	// keys last modified in a pruned segment can still be updated at their current revision
+	_, kv, err := b.Get(ctx, "/registry/pods/0", "", 1, 0)
+	noErr(t, err)
+	if kv.ModRevision < first {
+		t.Fatalf("expected revision at or after %d, got %d", first, kv.ModRevision)
+	}
+	_, _, ok, err := b.Update(ctx, "/registry/pods/0", []byte("1"), kv.ModRevision, 0)
+	noErr(t, err)
+	expEqual(t, true, ok)
+}
+
+func TestBackend_StartFailure(t *testing.T) {
+	started := &closeRecorder{Backend: newMemoryBackend(t)}
+	failed := &closeRecorder{Backend: newMemoryBackend(t), startErr: errors.New("start failed")}
+	b, err := NewBackend(failed, map[string]server.Backend{"/registry/events/": started})
+	noErr(t, err)
+
+	expEqualErr(t, failed.startErr, b.Start(context.Background()))
+	expEqual(t, 1, started.closed)
+
+	// backends closed when starting failed are not closed again
+	noErr(t, b.Close())
+	expEqual(t, 1, started.closed)
+	expEqual(t, 1, failed.closed)
+}
+
+// closeRecorder is a backend that counts the times it is closed, and optionally fails to start.
+type closeRecorder struct {
+	server.Backend
+	startErr error
+	closed   int
+}
+
+func (c *closeRecorder) Start(ctx context.Context) error {
+	if c.startErr != nil {
+		return c.startErr
+	}
+	return c.Backend.Start(ctx)
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed++
+	return c.Backend.Close()
+}
@@ -0,0 +1,668 @@
+package bolt
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/k3s-io/kine/pkg/drivers"
+	"github.com/k3s-io/kine/pkg/server"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	defaultFile      = "./db/state.bolt"
+	defaultBatchSize = 1000
+	openTimeout      = 5 * time.Second
+	ttlInterval      = time.Second
+	watchBufferSize  = 100
+)
+
+// explicit interface check
+var _ server.Backend = (*Backend)(nil)
+
+// Backend is a server.Backend that stores the revision log and an index of the latest
+// revision of each key in a bbolt database. Writes are serialized, and watchers are
+// notified in-process as soon as each write is committed.
+type Backend struct {
+	// mu serializes writes, so that watchers are notified in revision order.
+	mu sync.Mutex
+
+	db               *bolt.DB
+	wg               sync.WaitGroup
+	cancel           context.CancelFunc
+	compactInterval  time.Duration
+	compactMinRetain int64
+	compactBatchSize int64
+	watchers         map[*watcher]struct{}
+
+	leaseMu sync.Mutex
+	leases  map[string]lease
+}
+
+type watcher struct {
+	prefix      string
+	checkPrefix bool
+	ch          chan []*server.Event
+}
+
+// lease tracks the expiration of a key that was written with a lease.
+type lease struct {
+	modRevision int64
+	expiresAt   time.Time
+}
+
+// New opens the bbolt database at the path given by the data source name,
+// creating it if necessary.
+func New(ctx context.Context, cfg *drivers.Config) (bool, server.Backend, error) {
+	path := cfg.DataSourceName
+	if path == "" {
+		path = defaultFile
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return false, nil, err
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return false, nil, errors.Wrapf(err, "opening bolt database %s", path)
+	}
+	if err := db.Update(createBuckets); err != nil {
+		db.Close()
+		return false, nil, errors.Wrap(err, "creating bolt buckets")
+	}
+
+	batchSize := cfg.CompactBatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+
+	return false, &Backend{
+		db:               db,
+		compactInterval:  cfg.CompactInterval,
+		compactMinRetain: cfg.CompactMinRetain,
+		compactBatchSize: batchSize,
+		watchers:         map[*watcher]struct{}{},
+		leases:           map[string]lease{},
+	}, nil
+}
+
+// Start creates the health check key, and starts the TTL and compaction loops.
+func (b *Backend) Start(ctx context.Context) error {
+	ctx, b.cancel = context.WithCancel(ctx)
+
+	// See https://github.com/kubernetes/kubernetes/blob/442a69c3bdf6fe8e525b05887e57d89db1e2f3a5/staging/src/k8s.io/apiserver/pkg/storage/storagebackend/factory/etcd3.go#L97
+	if _, err := b.Create(ctx, "/registry/health", []byte(`{"health":"true"}`), 0); err != nil {
+		if err != server.ErrKeyExists {
+			logrus.Errorf("Failed to create health check key: %v", err)
+		}
+	}
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return iterate(tx, "/", "", 0, false, func(rev int64, r *record) bool {
+			if r.Lease > 0 {
+				b.addLease(r.Key, rev, r.Lease)
+			}
+			return true
+		})
+	})
+	if err != nil {
+		return errors.Wrap(err, "loading leases")
+	}
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		b.ttl(ctx)
+	}()
+
+	if b.compactInterval > 0 {
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			b.compactor(ctx)
+		}()
+	}
+	return nil
+}
+
+// Close stops the TTL and compaction loops, closes all watches, and closes the database.
+func (b *Backend) Close() error {
+	if b.cancel != nil {
+		b.cancel()
+	}
+	b.wg.Wait()
+
+	b.mu.Lock()
+	for w := range b.watchers {
+		b.unwatch(w)
+	}
+	b.mu.Unlock()
+
+	return b.db.Close()
+}
+
+func (b *Backend) Get(ctx context.Context, key, rangeEnd string, limit, revision int64) (revRet int64, kvRet *server.KeyValue, errRet error) {
+	defer func() {
+		logrus.Tracef("GET %s, rev=%d => rev=%d, kv=%v, err=%v", key, revision, revRet, kvRet != nil, errRet)
+	}()
+
+	rev, kvs, err := b.List(ctx, key, rangeEnd, 1, revision)
+	if err != nil || len(kvs) == 0 {
+		return rev, nil, err
+	}
+	return rev, kvs[0], nil
+}
+
+func (b *Backend) List(ctx context.Context, prefix, startKey string, limit, revision int64) (revRet int64, kvRet []*server.KeyValue, errRet error) {
+	defer func() {
+		logrus.Tracef("LIST %s, start=%s, limit=%d, rev=%d => rev=%d, kvs=%d, err=%v", prefix, startKey, limit, revision, revRet, len(kvRet), errRet)
+	}()
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		current, err := checkRevision(tx, revision)
+		revRet = current
+		if err != nil {
+			return err
+		}
+		if revision != 0 {
+			revRet = revision
+		}
+		return iterate(tx, prefix, startKey, revision, false, func(rev int64, r *record) bool {
+			kvRet = append(kvRet, r.kv(rev))
+			return limit <= 0 || int64(len(kvRet)) < limit
+		})
+	})
+	if err != nil {
+		return revRet, nil, err
+	}
+	return revRet, kvRet, nil
+}
+
+func (b *Backend) Count(ctx context.Context, prefix, startKey string, revision int64) (revRet int64, count int64, errRet error) {
+	defer func() {
+		logrus.Tracef("COUNT %s, rev=%d => rev=%d, count=%d, err=%v", prefix, revision, revRet, count, errRet)
+	}()
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		current, err := checkRevision(tx, revision)
+		revRet = current
+		if err != nil {
+			return err
+		}
+		return iterate(tx, prefix, startKey, revision, false, func(int64, *record) bool {
+			count++
+			return true
+		})
+	})
+	if err != nil {
+		return revRet, 0, err
+	}
+	return revRet, count, nil
+}
+
+func (b *Backend) Create(ctx context.Context, key string, value []byte, lease int64) (revRet int64, errRet error) {
+	defer func() {
+		logrus.Tracef("CREATE %s, size=%d, lease=%d => rev=%d, err=%v", key, len(value), lease, revRet, errRet)
+	}()
+
+	var e *server.Event
+	err := b.update(func(tx *bolt.Tx) error {
+		prevRev, prev, err := latest(tx, key, 0)
+		if err != nil {
+			return err
+		}
+		if prev != nil && !prev.Delete {
+			revRet, _ = revisions(tx)
+			return server.ErrKeyExists
+		}
+
+		r := &record{
+			Key:          key,
+			Create:       true,
+			PrevRevision: prevRev,
+			Lease:        lease,
+			Value:        value,
+		}
+		if revRet, err = appendRecord(tx, r); err != nil {
+			return err
+		}
+		e = &server.Event{Create: true, KV: r.kv(revRet)}
+		return nil
+	}, func() []*server.Event {
+		return []*server.Event{e}
+	})
+	if err != nil {
+		return revRet, err
+	}
+
+	if lease > 0 {
+		b.addLease(key, revRet, lease)
+	}
+	return revRet, nil
+}
+
+func (b *Backend) Update(ctx context.Context, key string, value []byte, revision, lease int64) (revRet int64, kvRet *server.KeyValue, updateRet bool, errRet error) {
+	defer func() {
+		kvRev := int64(0)
+		if kvRet != nil {
+			kvRev = kvRet.ModRevision
+		}
+		logrus.Tracef("UPDATE %s, value=%d, rev=%d, lease=%v => rev=%d, kvrev=%d, updated=%v, err=%v", key, len(value), revision, lease, revRet, kvRev, updateRet, errRet)
+	}()
+
+	var e *server.Event
+	err := b.update(func(tx *bolt.Tx) error {
+		revRet, _ = revisions(tx)
+		prevRev, prev, err := latest(tx, key, 0)
+		if err != nil || prev == nil || prev.Delete {
+			return err
+		}
+		kvRet = prev.kv(prevRev)
+		if prevRev != revision {
+			return nil
+		}
+
+		r := &record{
+			Key:            key,
+			CreateRevision: prev.CreateRevision,
+			PrevRevision:   prevRev,
+			Lease:          lease,
+			Value:          value,
+		}
+		if revRet, err = appendRecord(tx, r); err != nil {
+			return err
+		}
+		e = &server.Event{KV: r.kv(revRet), PrevKV: kvRet}
+		kvRet = e.KV
+		updateRet = true
+		return nil
+	}, func() []*server.Event {
+		if e == nil {
+			return nil
+		}
+		return []*server.Event{e}
+	})
+	if err != nil {
+		return revRet, nil, false, err
+	}
+
+	if updateRet {
+		b.addLease(key, revRet, lease)
+	}
+	return revRet, kvRet, updateRet, nil
+}
+
+func (b *Backend) Delete(ctx context.Context, key string, revision int64) (revRet int64, kvRet *server.KeyValue, deletedRet bool, errRet error) {
+	defer func() {
+		logrus.Tracef("DELETE %s, rev=%d => rev=%d, kv=%v, deleted=%v, err=%v", key, revision, revRet, kvRet != nil, deletedRet, errRet)
+	}()
+
+	var e *server.Event
+	err := b.update(func(tx *bolt.Tx) error {
+		revRet, _ = revisions(tx)
+		prevRev, prev, err := latest(tx, key, 0)
+		if err != nil {
+			return err
+		}
+		if prev == nil {
+			deletedRet = true
+			return nil
+		}
+		kvRet = prev.kv(prevRev)
+		if prev.Delete {
+			deletedRet = true
+			return nil
+		}
+		if revision != 0 && prevRev != revision {
+			return nil
+		}
+
+		r := &record{
+			Key:            key,
+			Delete:         true,
+			CreateRevision: prev.CreateRevision,
+			PrevRevision:   prevRev,
+			Lease:          prev.Lease,
+			Value:          prev.Value,
+		}
+		if revRet, err = appendRecord(tx, r); err != nil {
+			return err
+		}
+		e = &server.Event{Delete: true, KV: r.kv(revRet), PrevKV: kvRet}
+		deletedRet = true
+		return nil
+	}, func() []*server.Event {
+		if e == nil {
+			return nil
+		}
+		return []*server.Event{e}
+	})
+	if err != nil {
+		return revRet, nil, false, err
+	}
+
+	if e != nil {
+		b.removeLease(key, e.PrevKV.ModRevision)
+	}
+	return revRet, kvRet, deletedRet, nil
+}
+
+// update runs f in a write transaction and, once the transaction has been committed,
+// sends the events returned by events to any matching watchers.
+func (b *Backend) update(f func(tx *bolt.Tx) error, events func() []*server.Event) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.db.Update(f); err != nil {
+		return err
+	}
+	b.notify(events())
+	return nil
+}
+
+func (b *Backend) Watch(ctx context.Context, prefix string, revision int64) server.WatchResult {
+	logrus.Tracef("WATCH %s, revision=%d", prefix, revision)
+
+	// start watching right away so we don't miss anything
+	ctx, cancel := context.WithCancel(ctx)
+	w := b.watch(ctx, prefix)
+
+	result := make(chan []*server.Event, watchBufferSize)
+	errc := make(chan error, 1)
+	wr := server.WatchResult{Events: result, Errorc: errc}
+
+	// include the requested revision in the replayed events
+	fromCurrent := revision <= 0
+	if !fromCurrent {
+		revision--
+	}
+
+	var events []*server.Event
+	err := b.db.View(func(tx *bolt.Tx) error {
+		current, compact := revisions(tx)
+		wr.CurrentRevision = current
+		if fromCurrent {
+			revision = current
+			return nil
+		}
+		if revision < compact {
+			wr.CompactRevision = compact
+			return server.ErrCompacted
+		}
+
+		checkPrefix := strings.HasSuffix(prefix, "/")
+		c := tx.Bucket(logBucket).Cursor()
+		for k, v := c.Seek(encodeRevision(revision + 1)); k != nil; k, v = c.Next() {
+			r, err := decodeRecord(v)
+			if err != nil {
+				return err
+			}
+			if !matches(r.Key, checkPrefix, prefix) {
+				continue
+			}
+			e, err := event(tx, decodeRevision(k), r)
+			if err != nil {
+				return err
+			}
+			events = append(events, e)
+		}
+		return nil
+	})
+	if err != nil {
+		if err != server.ErrCompacted {
+			logrus.Errorf("Failed to list %s for revision %d: %v", prefix, revision, err)
+			errc <- server.ErrGRPCUnhealthy
+		}
+		cancel()
+	}
+
+	go func() {
+		defer cancel()
+		defer close(result)
+
+		lastRevision := revision
+		if len(events) > 0 {
+			lastRevision = events[len(events)-1].KV.ModRevision
+			result <- events
+		}
+
+		// always ensure we fully read the channel
+		for events := range w.ch {
+			for len(events) > 0 && events[0].KV.ModRevision <= lastRevision {
+				events = events[1:]
+			}
+			if len(events) > 0 {
+				result <- events
+			}
+		}
+	}()
+
+	return wr
+}
+
+// watch registers a watcher for the prefix. The watcher's channel is closed when the context is cancelled.
+func (b *Backend) watch(ctx context.Context, prefix string) *watcher {
+	w := &watcher{
+		prefix:      prefix,
+		checkPrefix: strings.HasSuffix(prefix, "/"),
+		ch:          make(chan []*server.Event, watchBufferSize),
+	}
+
+	b.mu.Lock()
+	b.watchers[w] = struct{}{}
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		b.unwatch(w)
+		b.mu.Unlock()
+	}()
+
+	return w
+}
+
+// unwatch closes the watcher's channel, if it has not already been closed.
+// The caller must hold the lock.
+func (b *Backend) unwatch(w *watcher) {
+	if _, ok := b.watchers[w]; ok {
+		close(w.ch)
+		delete(b.watchers, w)
+	}
+}
+
+// notify sends events to all matching watchers. The caller must hold the lock.
+func (b *Backend) notify(events []*server.Event) {
+	for _, e := range events {
+		for w := range b.watchers {
+			if !matches(e.KV.Key, w.checkPrefix, w.prefix) {
+				continue
+			}
+			select {
+			case w.ch <- []*server.Event{e}:
+			default:
+				// Slow consumer, drop
+				b.unwatch(w)
+			}
+		}
+	}
+}
+
+func (b *Backend) DbSize(ctx context.Context) (int64, error) {
+	var size int64
+	err := b.db.View(func(tx *bolt.Tx) error {
+		size = tx.Size()
+		return nil
+	})
+	return size, err
+}
+
+func (b *Backend) CurrentRevision(ctx context.Context) (int64, error) {
+	var current int64
+	err := b.db.View(func(tx *bolt.Tx) error {
+		current, _ = revisions(tx)
+		return nil
+	})
+	return current, err
+}
+
+// Capabilities reports no optional features. The store can still be compacted by the etcd
+// Compact RPC, but has no compactor for the admin Compact RPC to compact through.
+func (b *Backend) Capabilities() server.Capabilities {
+	return server.Capabilities{}
+}
+
+// Compact removes all records at or below the requested revision that have been superseded
+// by a later record for the same key, along with any deletions. Compaction is done in batches
+// of revisions, each in its own transaction, so that writes are not blocked for long.
+func (b *Backend) Compact(ctx context.Context, revision int64) (int64, error) {
+	start := time.Now()
+
+	var current, compactRev int64
+	err := b.db.View(func(tx *bolt.Tx) error {
+		current, compactRev = revisions(tx)
+		return nil
+	})
+	if err != nil {
+		return current, err
+	}
+	if revision > current {
+		return current, server.ErrFutureRev
+	}
+	if revision <= compactRev {
+		return current, server.ErrCompacted
+	}
+
+	var deleted int64
+	for compactRev < revision {
+		if err := ctx.Err(); err != nil {
+			return current, err
+		}
+		batchRevision := compactRev + b.compactBatchSize
+		if batchRevision > revision {
+			batchRevision = revision
+		}
+		err := b.update(func(tx *bolt.Tx) error {
+			count, err := compact(tx, batchRevision)
+			deleted += count
+			return err
+		}, func() []*server.Event {
+			return nil
+		})
+		if err != nil {
+			return current, errors.Wrapf(err, "compacting to revision %d", batchRevision)
+		}
+		compactRev = batchRevision
+	}
+
+	logrus.Infof("COMPACT deleted %d rows in %s - compacted to %d/%d", deleted, time.Since(start), revision, current)
+	return current, nil
+}
+
+// compactor periodically compacts the log, retaining at least compactMinRetain revisions.
+func (b *Backend) compactor(ctx context.Context) {
+	t := time.NewTicker(b.compactInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		var current, compactRev int64
+		if err := b.db.View(func(tx *bolt.Tx) error {
+			current, compactRev = revisions(tx)
+			return nil
+		}); err != nil {
+			logrus.Errorf("Compact failed to get revisions: %v", err)
+			continue
+		}
+
+		target := current - b.compactMinRetain
+		if target <= compactRev {
+			continue
+		}
+		if _, err := b.Compact(ctx, target); err != nil {
+			logrus.Errorf("Compact failed: %v", err)
+		}
+	}
+}
+
+// addLease records the expiration of a key written with a lease, replacing any previous lease
+// on the key. Keys written without a lease are removed from the lease table.
+func (b *Backend) addLease(key string, modRevision, ttl int64) {
+	b.leaseMu.Lock()
+	defer b.leaseMu.Unlock()
+
+	if ttl <= 0 {
+		delete(b.leases, key)
+		return
+	}
+	b.leases[key] = lease{
+		modRevision: modRevision,
+		expiresAt:   time.Now().Add(time.Duration(ttl) * time.Second),
+	}
+}
+
+// removeLease removes the lease on a key, if it has not been replaced by a later revision.
+func (b *Backend) removeLease(key string, modRevision int64) {
+	b.leaseMu.Lock()
+	defer b.leaseMu.Unlock()
+
+	if l, ok := b.leases[key]; ok && l.modRevision <= modRevision {
+		delete(b.leases, key)
+	}
+}
+
+// ttl periodically deletes keys whose lease has expired. If the key has been modified since
+// the lease was recorded, the delete fails and any lease for the newer revision is kept.
+func (b *Backend) ttl(ctx context.Context) {
+	t := time.NewTicker(ttlInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		now := time.Now()
+		expired := map[string]int64{}
+		b.leaseMu.Lock()
+		for key, l := range b.leases {
+			if now.After(l.expiresAt) {
+				expired[key] = l.modRevision
+			}
+		}
+		b.leaseMu.Unlock()
+
+		for key, modRevision := range expired {
+			logrus.Tracef("TTL delete key=%v, modRev=%v", key, modRevision)
+			if _, _, _, err := b.Delete(ctx, key, modRevision); err != nil {
+				logrus.Errorf("TTL delete trigger failed for key=%v: %v", key, err)
+				continue
+			}
+			b.removeLease(key, modRevision)
+		}
+	}
+}
+
+func matches(key string, checkPrefix bool, prefix string) bool {
+	if checkPrefix {
+		return strings.HasPrefix(key, prefix)
+	}
+	return key == prefix
+}
+
+func init() {
+	drivers.Register("bolt", New)
+}
@@ -0,0 +1,143 @@
+package bolt
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/k3s-io/kine/pkg/drivers"
+	"github.com/k3s-io/kine/pkg/server"
+	"github.com/k3s-io/kine/pkg/server/backendtest"
+)
+
+func noErr(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expEqualErr(t *testing.T, want, got error) {
+	t.Helper()
+	if !errors.Is(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func expEqual[T comparable](t *testing.T, want, got T) {
+	t.Helper()
+	if got != want {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func setupBackend(t *testing.T, path string) *Backend {
+	_, b, err := New(context.Background(), &drivers.Config{DataSourceName: path})
+	noErr(t, err)
+	noErr(t, b.Start(context.Background()))
+	return b.(*Backend)
+}
+
+func TestBackend(t *testing.T) {
+	backendtest.Run(t, func(t *testing.T) server.Backend {
+		b := setupBackend(t, filepath.Join(t.TempDir(), "state.bolt"))
+		t.Cleanup(func() { b.Close() })
+		return b
+	})
+}
+
+func TestBackend_Compact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.bolt")
+	b := setupBackend(t, path)
+
+	ctx := context.Background()
+
+	_, err := b.Create(ctx, "/a", []byte("1"), 0)
+	noErr(t, err)
+	_, _, _, err = b.Update(ctx, "/a", []byte("2"), 2, 0)
+	noErr(t, err)
+	_, err = b.Create(ctx, "/b", []byte("1"), 0)
+	noErr(t, err)
+	_, _, _, err = b.Delete(ctx, "/b", 4)
+	noErr(t, err)
+
+	_, err = b.Compact(ctx, 5)
+	noErr(t, err)
+
+	_, _, err = b.List(ctx, "/", "", 0, 3)
+	expEqualErr(t, server.ErrCompacted, err)
+
+	// reopen to ensure that the compacted state was persisted
+	noErr(t, b.Close())
+	b = setupBackend(t, path)
+	defer b.Close()
+
+	_, kv, err := b.Get(ctx, "/a", "", 1, 0)
+	noErr(t, err)
+	expEqual(t, "2", string(kv.Value))
+
+	_, kvs, err := b.List(ctx, "/", "", 0, 5)
+	noErr(t, err)
+	expEqual(t, 2, len(kvs))
+
+	rev, err := b.Create(ctx, "/b", []byte("2"), 0)
+	noErr(t, err)
+	expEqual(t, 6, rev)
+}
+
+func TestBackend_WatchFromFirstRevision(t *testing.T) {
+	b := setupBackend(t, filepath.Join(t.TempDir(), "state.bolt"))
+	defer b.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err := b.Create(ctx, "/a/a", []byte("1"), 0)
+	noErr(t, err)
+	_, err = b.Create(ctx, "/a/b", []byte("1"), 0)
+	noErr(t, err)
+
+	// all events are replayed, rather than starting at the current revision
+	wr := b.Watch(ctx, "/a/", 1)
+
+	var events []*server.Event
+	timeout := time.After(5 * time.Second)
+	for len(events) < 2 {
+		select {
+		case e := <-wr.Events:
+			events = append(events, e...)
+		case <-timeout:
+			t.Fatalf("timed out waiting for events, got %d", len(events))
+		}
+	}
+
+	expEqual(t, "/a/a", events[0].KV.Key)
+	expEqual(t, "/a/b", events[1].KV.Key)
+
+	cancel()
+	for range wr.Events {
+	}
+}
+
+func TestBackend_TTL(t *testing.T) {
+	b := setupBackend(t, filepath.Join(t.TempDir(), "state.bolt"))
+	defer b.Close()
+
+	ctx := context.Background()
+
+	_, err := b.Create(ctx, "/a", []byte("1"), 1)
+	noErr(t, err)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		_, kv, err := b.Get(ctx, "/a", "", 1, 0)
+		noErr(t, err)
+		if kv == nil {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatal("key was not deleted after lease expired")
+}
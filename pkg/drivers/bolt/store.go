@@ -0,0 +1,257 @@
+package bolt
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+
+	"github.com/k3s-io/kine/pkg/server"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// logBucket maps each revision to the record written at that revision.
+	logBucket = []byte("log")
+	// indexBucket maps each key to the revision of its latest record, including deletions.
+	indexBucket = []byte("index")
+	// metaBucket holds the current and compact revisions.
+	metaBucket = []byte("meta")
+
+	currentRevisionKey = []byte("current_revision")
+	compactRevisionKey = []byte("compact_revision")
+)
+
+// record is a single entry in the revision log. The revision of the record is the key
+// that it is stored under, and is not included in the encoded value. Deletions retain the
+// value of the deleted key so that watchers can be sent the deleted value.
+type record struct {
+	Key            string `json:"key"`
+	Create         bool   `json:"create,omitempty"`
+	Delete         bool   `json:"delete,omitempty"`
+	CreateRevision int64  `json:"createRevision"`
+	PrevRevision   int64  `json:"prevRevision,omitempty"`
+	Lease          int64  `json:"lease,omitempty"`
+	Value          []byte `json:"value,omitempty"`
+}
+
+func (r *record) kv(revision int64) *server.KeyValue {
+	return &server.KeyValue{
+		Key:            r.Key,
+		CreateRevision: r.CreateRevision,
+		ModRevision:    revision,
+		Value:          r.Value,
+		Lease:          r.Lease,
+	}
+}
+
+func encodeRevision(revision int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(revision))
+	return b
+}
+
+func decodeRevision(b []byte) int64 {
+	if len(b) != 8 {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+func createBuckets(tx *bolt.Tx) error {
+	for _, name := range [][]byte{logBucket, indexBucket, metaBucket} {
+		if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// revisions returns the current and compact revisions.
+func revisions(tx *bolt.Tx) (int64, int64) {
+	meta := tx.Bucket(metaBucket)
+	return decodeRevision(meta.Get(currentRevisionKey)), decodeRevision(meta.Get(compactRevisionKey))
+}
+
+// checkRevision returns an error if the requested revision is in the future or has been compacted.
+func checkRevision(tx *bolt.Tx, revision int64) (int64, error) {
+	current, compact := revisions(tx)
+	if revision > current {
+		return current, server.ErrFutureRev
+	}
+	if revision > 0 && revision < compact {
+		return current, server.ErrCompacted
+	}
+	return current, nil
+}
+
+// getRecord returns the record at the given revision, or nil if it does not exist or has been compacted.
+func getRecord(tx *bolt.Tx, revision int64) (*record, error) {
+	data := tx.Bucket(logBucket).Get(encodeRevision(revision))
+	if data == nil {
+		return nil, nil
+	}
+	return decodeRecord(data)
+}
+
+func decodeRecord(data []byte) (*record, error) {
+	r := &record{}
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// latest returns the latest record for the key at or below the requested revision, along with
+// its revision. A zero revision returns the current record. Older records are found by following
+// the chain of previous revisions; if the chain ends above the requested revision, the key did not
+// exist at that revision.
+func latest(tx *bolt.Tx, key string, revision int64) (int64, *record, error) {
+	rev := decodeRevision(tx.Bucket(indexBucket).Get([]byte(key)))
+	for rev != 0 {
+		r, err := getRecord(tx, rev)
+		if err != nil || r == nil {
+			return 0, nil, err
+		}
+		if revision <= 0 || rev <= revision {
+			return rev, r, nil
+		}
+		rev = r.PrevRevision
+	}
+	return 0, nil, nil
+}
+
+// iterate calls f with the latest record at the requested revision for each key matching
+// the prefix, in key order, until f returns false. An empty prefix matches all keys. If the
+// prefix does not end with a "/" only the exact key is matched, and the start key is ignored.
+func iterate(tx *bolt.Tx, prefix, startKey string, revision int64, includeDeletes bool, f func(int64, *record) bool) error {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		rev, r, err := latest(tx, prefix, revision)
+		if err != nil {
+			return err
+		}
+		if r != nil && (includeDeletes || !r.Delete) {
+			f(rev, r)
+		}
+		return nil
+	}
+
+	if startKey == prefix {
+		startKey = ""
+	}
+
+	c := tx.Bucket(indexBucket).Cursor()
+	for k, _ := c.Seek([]byte(prefix)); k != nil && strings.HasPrefix(string(k), prefix); k, _ = c.Next() {
+		if string(k) <= startKey {
+			continue
+		}
+		rev, r, err := latest(tx, string(k), revision)
+		if err != nil {
+			return err
+		}
+		if r == nil || (r.Delete && !includeDeletes) {
+			continue
+		}
+		if !f(rev, r) {
+			break
+		}
+	}
+	return nil
+}
+
+// appendRecord writes the record at the next revision and points the index at it.
+func appendRecord(tx *bolt.Tx, r *record) (int64, error) {
+	current, _ := revisions(tx)
+	rev := current + 1
+	if r.Create {
+		r.CreateRevision = rev
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Bucket(logBucket).Put(encodeRevision(rev), data); err != nil {
+		return 0, err
+	}
+	if err := tx.Bucket(indexBucket).Put([]byte(r.Key), encodeRevision(rev)); err != nil {
+		return 0, err
+	}
+	if err := tx.Bucket(metaBucket).Put(currentRevisionKey, encodeRevision(rev)); err != nil {
+		return 0, err
+	}
+	return rev, nil
+}
+
+// event converts a record to a watch event, looking up the previous value for updates and deletes.
+func event(tx *bolt.Tx, revision int64, r *record) (*server.Event, error) {
+	e := &server.Event{
+		Create: r.Create,
+		Delete: r.Delete,
+		KV:     r.kv(revision),
+	}
+	if !r.Create && r.PrevRevision != 0 {
+		prev, err := getRecord(tx, r.PrevRevision)
+		if err != nil {
+			return nil, err
+		}
+		if prev != nil {
+			e.PrevKV = prev.kv(r.PrevRevision)
+		}
+	}
+	return e, nil
+}
+
+// compact removes all records at or below the requested revision that have been superseded
+// by a later record for the same key, along with any deletions, and returns the number of
+// records removed. Records are only examined starting after the previous compact revision.
+func compact(tx *bolt.Tx, revision int64) (int64, error) {
+	_, compactRev := revisions(tx)
+	log := tx.Bucket(logBucket)
+	index := tx.Bucket(indexBucket)
+
+	var (
+		superseded []int64
+		deletes    []int64
+		deleteKeys []string
+	)
+	c := log.Cursor()
+	for k, v := c.Seek(encodeRevision(compactRev + 1)); k != nil; k, v = c.Next() {
+		rev := decodeRevision(k)
+		if rev > revision {
+			break
+		}
+		r, err := decodeRecord(v)
+		if err != nil {
+			return 0, err
+		}
+		if r.PrevRevision != 0 {
+			superseded = append(superseded, r.PrevRevision)
+		}
+		if r.Delete {
+			deletes = append(deletes, rev)
+			deleteKeys = append(deleteKeys, r.Key)
+		}
+	}
+
+	var deleted int64
+	for _, rev := range append(superseded, deletes...) {
+		key := encodeRevision(rev)
+		if log.Get(key) == nil {
+			continue
+		}
+		if err := log.Delete(key); err != nil {
+			return 0, err
+		}
+		deleted++
+	}
+	for i, key := range deleteKeys {
+		// drop deleted keys from the index, unless they have been recreated since
+		if decodeRevision(index.Get([]byte(key))) == deletes[i] {
+			if err := index.Delete([]byte(key)); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return deleted, tx.Bucket(metaBucket).Put(compactRevisionKey, encodeRevision(revision))
+}
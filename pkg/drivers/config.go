@@ -13,7 +13,11 @@ type Config struct {
 	Endpoint              string
 	Scheme                string
 	DataSourceName        string
+	ReplicaEndpoint       string
+	ReplicaDataSourceName string
 	ConnectionPoolConfig  generic.ConnectionPoolConfig
+	CompressionConfig     generic.CompressionConfig
+	RetentionConfig       generic.RetentionConfig
 	BackendTLSConfig      tls.Config
 	CompactInterval       time.Duration
 	CompactIntervalJitter int
@@ -21,4 +25,6 @@ type Config struct {
 	CompactMinRetain      int64
 	CompactBatchSize      int64
 	PollBatchSize         int64
+	// Route is the prefix of the keys routed to the backend, or empty for the default backend.
+	Route string
 }
@@ -0,0 +1,395 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/k3s-io/kine/pkg/server"
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	healthKey       = "/registry/health"
+	watchBufferSize = 100
+)
+
+// explicit interface check
+var _ server.Backend = (*Backend)(nil)
+
+// Backend is a server.Backend that stores data in etcd, using the kine key-value
+// operations that map directly onto etcd transactions. Kine leases are specified
+// as a TTL in seconds, so a new etcd lease with that TTL is granted for each write
+// that uses a lease; the lease IDs returned by reads are etcd lease IDs.
+type Backend struct {
+	client *clientv3.Client
+	// ctx is the context passed to Start; watches are closed when it is cancelled.
+	ctx context.Context
+	// closeFunc is called after the client is closed, to release any other resources held by the backend.
+	closeFunc func() error
+}
+
+// NewBackend returns a backend that uses the provided client. The closeFunc, if not nil,
+// is called when the backend is closed, after the client has been closed.
+func NewBackend(client *clientv3.Client, closeFunc func() error) *Backend {
+	return &Backend{
+		client:    client,
+		ctx:       context.Background(),
+		closeFunc: closeFunc,
+	}
+}
+
+// Start creates the health check key.
+func (b *Backend) Start(ctx context.Context) error {
+	b.ctx = ctx
+	// See https://github.com/kubernetes/kubernetes/blob/442a69c3bdf6fe8e525b05887e57d89db1e2f3a5/staging/src/k8s.io/apiserver/pkg/storage/storagebackend/factory/etcd3.go#L97
+	if _, err := b.Create(ctx, healthKey, []byte(`{"health":"true"}`), 0); err != nil {
+		if err != server.ErrKeyExists {
+			logrus.Errorf("Failed to create health check key: %v", err)
+		}
+	}
+	return nil
+}
+
+// Close closes the client, and then calls the close function.
+func (b *Backend) Close() error {
+	err := b.client.Close()
+	if errors.Is(err, context.Canceled) {
+		// in-process clients have no connection to close, and always return the cancelled context error
+		err = nil
+	}
+	if b.closeFunc != nil {
+		if cerr := b.closeFunc(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func (b *Backend) Get(ctx context.Context, key, rangeEnd string, limit, revision int64) (revRet int64, kvRet *server.KeyValue, errRet error) {
+	defer func() {
+		logrus.Tracef("GET %s, rev=%d => rev=%d, kv=%v, err=%v", key, revision, revRet, kvRet != nil, errRet)
+	}()
+
+	rev, kvs, err := b.List(ctx, key, rangeEnd, 1, revision)
+	if err != nil || len(kvs) == 0 {
+		return rev, nil, err
+	}
+	return rev, kvs[0], nil
+}
+
+func (b *Backend) List(ctx context.Context, prefix, startKey string, limit, revision int64) (revRet int64, kvRet []*server.KeyValue, errRet error) {
+	defer func() {
+		logrus.Tracef("LIST %s, start=%s, limit=%d, rev=%d => rev=%d, kvs=%d, err=%v", prefix, startKey, limit, revision, revRet, len(kvRet), errRet)
+	}()
+
+	key, opts := rangeOptions(prefix, startKey)
+	opts = append(opts, clientv3.WithLimit(limit), clientv3.WithRev(revision))
+	resp, err := b.client.Get(ctx, key, opts...)
+	if err != nil {
+		return 0, nil, translateErr(err)
+	}
+
+	kvs := make([]*server.KeyValue, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		kvs = append(kvs, toKV(kv))
+	}
+	return resp.Header.Revision, kvs, nil
+}
+
+func (b *Backend) Count(ctx context.Context, prefix, startKey string, revision int64) (revRet int64, count int64, errRet error) {
+	defer func() {
+		logrus.Tracef("COUNT %s, rev=%d => rev=%d, count=%d, err=%v", prefix, revision, revRet, count, errRet)
+	}()
+
+	key, opts := rangeOptions(prefix, startKey)
+	opts = append(opts, clientv3.WithCountOnly(), clientv3.WithRev(revision))
+	resp, err := b.client.Get(ctx, key, opts...)
+	if err != nil {
+		return 0, 0, translateErr(err)
+	}
+	return resp.Header.Revision, resp.Count, nil
+}
+
+func (b *Backend) Create(ctx context.Context, key string, value []byte, lease int64) (revRet int64, errRet error) {
+	defer func() {
+		logrus.Tracef("CREATE %s, size=%d, lease=%d => rev=%d, err=%v", key, len(value), lease, revRet, errRet)
+	}()
+
+	leaseID, err := b.grant(ctx, lease)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := b.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, string(value), clientv3.WithLease(leaseID))).
+		Commit()
+	if err != nil {
+		return 0, translateErr(err)
+	}
+	if !resp.Succeeded {
+		return resp.Header.Revision, server.ErrKeyExists
+	}
+	return resp.Header.Revision, nil
+}
+
+func (b *Backend) Update(ctx context.Context, key string, value []byte, revision, lease int64) (revRet int64, kvRet *server.KeyValue, updateRet bool, errRet error) {
+	defer func() {
+		kvRev := int64(0)
+		if kvRet != nil {
+			kvRev = kvRet.ModRevision
+		}
+		logrus.Tracef("UPDATE %s, value=%d, rev=%d, lease=%v => rev=%d, kvrev=%d, updated=%v, err=%v", key, len(value), revision, lease, revRet, kvRev, updateRet, errRet)
+	}()
+
+	// a zero revision would match a key that does not exist; updates only apply to existing keys
+	if revision <= 0 {
+		rev, kv, err := b.Get(ctx, key, "", 1, 0)
+		return rev, kv, false, err
+	}
+
+	leaseID, err := b.grant(ctx, lease)
+	if err != nil {
+		return 0, nil, false, err
+	}
+
+	resp, err := b.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(key), "=", revision)).
+		Then(clientv3.OpPut(key, string(value), clientv3.WithLease(leaseID), clientv3.WithPrevKV())).
+		Else(clientv3.OpGet(key)).
+		Commit()
+	if err != nil {
+		return 0, nil, false, translateErr(err)
+	}
+
+	rev := resp.Header.Revision
+	if !resp.Succeeded {
+		if kvs := resp.Responses[0].GetResponseRange().GetKvs(); len(kvs) > 0 {
+			return rev, toKV(kvs[0]), false, nil
+		}
+		return rev, nil, false, nil
+	}
+
+	kv := &server.KeyValue{
+		Key:            key,
+		CreateRevision: rev,
+		ModRevision:    rev,
+		Value:          value,
+		Lease:          int64(leaseID),
+	}
+	if put := resp.Responses[0].GetResponsePut(); put != nil && put.PrevKv != nil {
+		kv.CreateRevision = put.PrevKv.CreateRevision
+	}
+	return rev, kv, true, nil
+}
+
+func (b *Backend) Delete(ctx context.Context, key string, revision int64) (revRet int64, kvRet *server.KeyValue, deletedRet bool, errRet error) {
+	defer func() {
+		logrus.Tracef("DELETE %s, rev=%d => rev=%d, kv=%v, deleted=%v, err=%v", key, revision, revRet, kvRet != nil, deletedRet, errRet)
+	}()
+
+	txn := b.client.Txn(ctx)
+	if revision != 0 {
+		txn = txn.If(clientv3.Compare(clientv3.ModRevision(key), "=", revision))
+	}
+	resp, err := txn.
+		Then(clientv3.OpDelete(key, clientv3.WithPrevKV())).
+		Else(clientv3.OpGet(key)).
+		Commit()
+	if err != nil {
+		return 0, nil, false, translateErr(err)
+	}
+
+	rev := resp.Header.Revision
+	if !resp.Succeeded {
+		kvs := resp.Responses[0].GetResponseRange().GetKvs()
+		if len(kvs) == 0 {
+			// the key does not exist
+			return rev, nil, true, nil
+		}
+		return rev, toKV(kvs[0]), false, nil
+	}
+	if kvs := resp.Responses[0].GetResponseDeleteRange().GetPrevKvs(); len(kvs) > 0 {
+		return rev, toKV(kvs[0]), true, nil
+	}
+	return rev, nil, true, nil
+}
+
+func (b *Backend) Watch(ctx context.Context, prefix string, revision int64) server.WatchResult {
+	logrus.Tracef("WATCH %s, revision=%d", prefix, revision)
+
+	result := make(chan []*server.Event, watchBufferSize)
+	errc := make(chan error, 1)
+	wr := server.WatchResult{Events: result, Errorc: errc}
+
+	opts := []clientv3.OpOption{clientv3.WithPrevKV(), clientv3.WithRev(revision)}
+	if strings.HasSuffix(prefix, "/") {
+		opts = append(opts, clientv3.WithPrefix())
+	}
+
+	ctx, cancel := context.WithCancel(clientv3.WithRequireLeader(ctx))
+	stop := context.AfterFunc(b.ctx, cancel)
+	wch := b.client.Watch(ctx, prefix, opts...)
+
+	// etcd only reports that the start revision has been compacted once the watch is
+	// established, but the caller expects compaction to be reported synchronously.
+	if revision > 0 {
+		if _, err := b.client.Get(ctx, prefix, clientv3.WithRev(revision), clientv3.WithCountOnly()); errors.Is(err, rpctypes.ErrCompacted) {
+			for resp := range wch {
+				if resp.CompactRevision != 0 {
+					wr.CompactRevision = resp.CompactRevision
+					wr.CurrentRevision = resp.Header.Revision
+					break
+				}
+			}
+			stop()
+			cancel()
+			close(result)
+			return wr
+		}
+	}
+
+	go func() {
+		defer stop()
+		defer cancel()
+		defer close(result)
+
+		for resp := range wch {
+			if resp.CompactRevision != 0 {
+				errc <- server.ErrCompacted
+				return
+			}
+			if err := resp.Err(); err != nil {
+				if ctx.Err() == nil {
+					logrus.Errorf("Watch on %s failed: %v", prefix, err)
+					errc <- server.ErrGRPCUnhealthy
+				}
+				return
+			}
+
+			events := make([]*server.Event, 0, len(resp.Events))
+			for _, e := range resp.Events {
+				events = append(events, toEvent(e))
+			}
+			if len(events) > 0 {
+				result <- events
+			}
+		}
+	}()
+
+	return wr
+}
+
+func (b *Backend) DbSize(ctx context.Context) (int64, error) {
+	var endpoint string
+	if endpoints := b.client.Endpoints(); len(endpoints) > 0 {
+		endpoint = endpoints[0]
+	}
+	resp, err := b.client.Status(ctx, endpoint)
+	if err != nil {
+		return 0, translateErr(err)
+	}
+	return resp.DbSize, nil
+}
+
+func (b *Backend) CurrentRevision(ctx context.Context) (int64, error) {
+	resp, err := b.client.Get(ctx, healthKey, clientv3.WithCountOnly())
+	if err != nil {
+		return 0, translateErr(err)
+	}
+	return resp.Header.Revision, nil
+}
+
+// Capabilities reports no optional features. The store can still be compacted by the etcd
+// Compact RPC, but has no compactor for the admin Compact RPC to compact through.
+func (b *Backend) Capabilities() server.Capabilities {
+	return server.Capabilities{}
+}
+
+func (b *Backend) Compact(ctx context.Context, revision int64) (int64, error) {
+	resp, err := b.client.Compact(ctx, revision)
+	if err != nil {
+		return 0, translateErr(err)
+	}
+	return resp.Header.Revision, nil
+}
+
+// grant grants a lease with the requested TTL in seconds. A zero TTL returns clientv3.NoLease.
+func (b *Backend) grant(ctx context.Context, ttl int64) (clientv3.LeaseID, error) {
+	if ttl <= 0 {
+		return clientv3.NoLease, nil
+	}
+	resp, err := b.client.Grant(ctx, ttl)
+	if err != nil {
+		return clientv3.NoLease, translateErr(err)
+	}
+	return resp.ID, nil
+}
+
+// rangeOptions returns the key and options for a kine list. If the prefix ends with a "/",
+// all keys under the prefix that follow the start key are matched; if it is empty, all keys
+// that follow the start key are matched. Otherwise only the exact key is matched.
+func rangeOptions(prefix, startKey string) (string, []clientv3.OpOption) {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		return prefix, nil
+	}
+
+	key := prefix
+	if startKey != "" && startKey != prefix {
+		key = startKey + "\x00"
+	}
+	if prefix == "" {
+		return key, []clientv3.OpOption{clientv3.WithFromKey()}
+	}
+	return key, []clientv3.OpOption{clientv3.WithRange(clientv3.GetPrefixRangeEnd(prefix))}
+}
+
+// translateErr converts client errors into the errors that the kine server expects,
+// and returns other etcd errors as GRPC status errors so that their codes are preserved.
+func translateErr(err error) error {
+	switch {
+	case errors.Is(err, rpctypes.ErrCompacted):
+		return server.ErrCompacted
+	case errors.Is(err, rpctypes.ErrFutureRev):
+		return server.ErrFutureRev
+	}
+	var etcdErr rpctypes.EtcdError
+	if errors.As(err, &etcdErr) {
+		return status.Error(etcdErr.Code(), etcdErr.Error())
+	}
+	return err
+}
+
+func toKV(kv *mvccpb.KeyValue) *server.KeyValue {
+	return &server.KeyValue{
+		Key:            string(kv.Key),
+		CreateRevision: kv.CreateRevision,
+		ModRevision:    kv.ModRevision,
+		Value:          kv.Value,
+		Lease:          kv.Lease,
+	}
+}
+
+// toEvent converts an etcd event to a kine event. Etcd deletions do not include the
+// deleted value, so it is copied from the previous key-value to match kine deletions.
+func toEvent(e *clientv3.Event) *server.Event {
+	event := &server.Event{
+		Create: e.IsCreate(),
+		Delete: e.Type == mvccpb.DELETE,
+		KV:     toKV(e.Kv),
+	}
+	if e.PrevKv != nil {
+		event.PrevKV = toKV(e.PrevKv)
+		if event.Delete {
+			event.KV.CreateRevision = e.PrevKv.CreateRevision
+			event.KV.Value = e.PrevKv.Value
+			event.KV.Lease = e.PrevKv.Lease
+		}
+	}
+	return event
+}
@@ -0,0 +1,100 @@
+package etcd
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/k3s-io/kine/pkg/drivers"
+	"github.com/k3s-io/kine/pkg/server"
+	"github.com/k3s-io/kine/pkg/server/backendtest"
+	"go.etcd.io/etcd/server/v3/embed"
+	"go.etcd.io/etcd/server/v3/etcdserver/api/v3client"
+)
+
+func noErr(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expEqual[T comparable](t *testing.T, want, got T) {
+	t.Helper()
+	if got != want {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func setupBackend(t *testing.T) server.Backend {
+	_, b, err := NewEmbedded(context.Background(), &drivers.Config{DataSourceName: t.TempDir()})
+	noErr(t, err)
+	noErr(t, b.Start(context.Background()))
+	t.Cleanup(func() {
+		noErr(t, b.Close())
+	})
+	return b
+}
+
+func TestBackend(t *testing.T) {
+	backendtest.Run(t, setupBackend)
+}
+
+func TestBackend_WatchCompacted(t *testing.T) {
+	b := setupBackend(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rev, err := b.Create(ctx, "/a/a", []byte("1"), 0)
+	noErr(t, err)
+	_, _, _, err = b.Update(ctx, "/a/a", []byte("2"), rev, 0)
+	noErr(t, err)
+
+	_, err = b.Compact(ctx, rev+1)
+	noErr(t, err)
+
+	wr := b.Watch(ctx, "/a/", rev)
+	expEqual(t, rev+1, wr.CompactRevision)
+}
+
+func TestProxy(t *testing.T) {
+	config := embed.NewConfig()
+	config.Dir = t.TempDir()
+	config.LogLevel = "error"
+	config.ListenClientUrls = []url.URL{{Scheme: "http", Host: "127.0.0.1:0"}}
+	config.AdvertiseClientUrls = config.ListenClientUrls
+	config.ListenPeerUrls = []url.URL{}
+
+	e, err := embed.StartEtcd(config)
+	noErr(t, err)
+	defer e.Close()
+
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(time.Minute):
+		t.Fatal("timed out waiting for etcd to start")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, b, err := drivers.New(ctx, &drivers.Config{Endpoint: "etcd://" + e.Clients[0].Addr().String()})
+	noErr(t, err)
+	noErr(t, b.Start(ctx))
+	defer b.Close()
+
+	rev, err := b.Create(ctx, "/a", []byte("1"), 0)
+	noErr(t, err)
+
+	_, kv, err := b.Get(ctx, "/a", "", 1, 0)
+	noErr(t, err)
+	expEqual(t, rev, kv.ModRevision)
+	expEqual(t, "1", string(kv.Value))
+
+	// ensure that the write went to the external etcd
+	resp, err := v3client.New(e.Server).Get(ctx, "/a")
+	noErr(t, err)
+	expEqual(t, 1, len(resp.Kvs))
+}
@@ -0,0 +1,71 @@
+package etcd
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/k3s-io/kine/pkg/drivers"
+	"github.com/k3s-io/kine/pkg/server"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/etcd/server/v3/embed"
+	"go.etcd.io/etcd/server/v3/etcdserver/api/v3client"
+)
+
+const (
+	defaultDataDir = "./db/etcd"
+	startTimeout   = time.Minute
+)
+
+// NewEmbedded starts a single-node etcd server in-process, using the data source name
+// as the data directory. The server does not listen on any client or peer addresses;
+// requests are served by kine through an in-process client. If compaction is enabled,
+// etcd's revision-based auto-compaction is used to retain CompactMinRetain revisions.
+func NewEmbedded(ctx context.Context, cfg *drivers.Config) (bool, server.Backend, error) {
+	dataDir := cfg.DataSourceName
+	if dataDir == "" {
+		dataDir = defaultDataDir
+	}
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return false, nil, err
+	}
+
+	config := embed.NewConfig()
+	config.Dir = dataDir
+	config.LogLevel = "error"
+	config.ListenClientUrls = []url.URL{}
+	config.AdvertiseClientUrls = []url.URL{}
+	config.ListenPeerUrls = []url.URL{}
+	if cfg.CompactInterval > 0 && cfg.CompactMinRetain > 0 {
+		config.AutoCompactionMode = embed.CompactorModeRevision
+		config.AutoCompactionRetention = strconv.FormatInt(cfg.CompactMinRetain, 10)
+	}
+
+	e, err := embed.StartEtcd(config)
+	if err != nil {
+		return false, nil, errors.Wrap(err, "starting embedded etcd")
+	}
+
+	select {
+	case <-e.Server.ReadyNotify():
+	case err := <-e.Err():
+		e.Close()
+		return false, nil, errors.Wrap(err, "starting embedded etcd")
+	case <-time.After(startTimeout):
+		e.Close()
+		return false, nil, errors.New("timed out waiting for embedded etcd to start")
+	}
+	logrus.Infof("Embedded etcd started with data directory %s", dataDir)
+
+	return false, NewBackend(v3client.New(e.Server), func() error {
+		e.Close()
+		return nil
+	}), nil
+}
+
+func init() {
+	drivers.Register("etcd-embedded", NewEmbedded)
+}
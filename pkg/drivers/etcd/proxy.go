@@ -0,0 +1,60 @@
+package etcd
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/k3s-io/kine/pkg/drivers"
+	"github.com/k3s-io/kine/pkg/server"
+	"github.com/pkg/errors"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const dialTimeout = 5 * time.Second
+
+// NewProxy returns a backend that proxies requests to an external etcd cluster, so that
+// kine features such as metrics apply to etcd-backed clusters. The data source name is a
+// comma-separated list of etcd addresses; the "etcds" scheme connects using TLS, with the
+// backend TLS configuration. Unlike the http and https drivers, which hand the endpoints
+// through to the client, all requests are served by kine.
+func NewProxy(ctx context.Context, cfg *drivers.Config) (bool, server.Backend, error) {
+	if cfg.DataSourceName == "" {
+		return false, nil, errors.New("at least one etcd address must be specified")
+	}
+
+	urlScheme := "http"
+	if cfg.Scheme == "etcds" {
+		urlScheme = "https"
+	}
+
+	var endpoints []string
+	for _, address := range strings.Split(cfg.DataSourceName, ",") {
+		if !strings.Contains(address, "://") {
+			address = urlScheme + "://" + address
+		}
+		endpoints = append(endpoints, address)
+	}
+
+	tlsConfig, err := cfg.BackendTLSConfig.ClientConfig()
+	if err != nil {
+		return false, nil, errors.Wrap(err, "creating etcd client TLS config")
+	}
+
+	client, err := clientv3.New(clientv3.Config{
+		Context:     ctx,
+		Endpoints:   endpoints,
+		DialTimeout: dialTimeout,
+		TLS:         tlsConfig,
+	})
+	if err != nil {
+		return false, nil, errors.Wrap(err, "creating etcd client")
+	}
+
+	return true, NewBackend(client, nil), nil
+}
+
+func init() {
+	drivers.Register("etcd", NewProxy)
+	drivers.Register("etcds", NewProxy)
+}
@@ -5,14 +5,22 @@ import (
 	"errors"
 	"strings"
 
+	"github.com/k3s-io/kine/pkg/drivers/generic"
 	"github.com/k3s-io/kine/pkg/server"
 	"github.com/k3s-io/kine/pkg/util"
 )
 
-var ErrUnknownDriver = errors.New("unknown driver")
+var (
+	ErrUnknownDriver = errors.New("unknown driver")
+	ErrNoMigrations  = errors.New("driver does not support schema migrations")
+	ErrNoReplicas    = errors.New("driver does not support read replicas")
+)
 
 func New(ctx context.Context, cfg *Config) (leaderElect bool, backend server.Backend, err error) {
 	if cfg.Endpoint == "" {
+		if cfg.ReplicaEndpoint != "" {
+			return false, nil, errors.New("replica endpoint requires a datastore endpoint")
+		}
 		driver := GetDefault()
 		if driver == nil {
 			return false, nil, errors.New("no default driver found")
@@ -30,9 +38,46 @@ func New(ctx context.Context, cfg *Config) (leaderElect bool, backend server.Bac
 	if !ok {
 		return false, nil, ErrUnknownDriver
 	}
+
+	if cfg.ReplicaEndpoint != "" {
+		if !SupportsReplicas(cfg.Scheme) {
+			return false, nil, ErrNoReplicas
+		}
+		if err := validateDSNuri(cfg.ReplicaEndpoint); err != nil {
+			return false, nil, err
+		}
+		var scheme string
+		scheme, cfg.ReplicaDataSourceName = util.SchemeAndAddress(cfg.ReplicaEndpoint)
+		if scheme != cfg.Scheme {
+			return false, nil, errors.New("replica endpoint must use the same scheme as the datastore endpoint")
+		}
+	}
+
 	return driver(ctx, cfg)
 }
 
+// NewMigrator returns a schema migrator for the configured endpoint, or ErrNoMigrations
+// if the driver does not store its data in a SQL schema.
+func NewMigrator(ctx context.Context, cfg *Config) (*generic.Migrator, error) {
+	scheme := defaultScheme
+	if cfg.Endpoint != "" {
+		if err := validateDSNuri(cfg.Endpoint); err != nil {
+			return nil, err
+		}
+		cfg.Scheme, cfg.DataSourceName = util.SchemeAndAddress(cfg.Endpoint)
+		scheme = cfg.Scheme
+	}
+
+	if _, ok := Get(scheme); !ok {
+		return nil, ErrUnknownDriver
+	}
+	migrator, ok := GetMigrator(scheme)
+	if !ok {
+		return nil, ErrNoMigrations
+	}
+	return migrator(ctx, cfg)
+}
+
 // validateDSNuri ensure that the given string is of that format <scheme://<authority>
 func validateDSNuri(str string) error {
 	parts := strings.SplitN(str, "://", 2)
@@ -0,0 +1,195 @@
+package generic
+
+import (
+	"context"
+	"database/sql/driver"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/k3s-io/kine/pkg/util"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	usernameFileParam = "usernameFile"
+	passwordFileParam = "passwordFile"
+)
+
+// Credentials are the files that the username and password used to connect to the database are
+// read from, so that they do not have to be included in the data source name. The files are read
+// each time a connection is opened, so that credentials rotated by a secret manager are used for
+// new connections without restarting.
+type Credentials struct {
+	UsernameFile string
+	PasswordFile string
+}
+
+// SetCredentialsFunc returns the data source name with the username and password set. Empty
+// values leave the username or password in the data source name unchanged.
+type SetCredentialsFunc func(dataSourceName, username, password string) (string, error)
+
+// ParseCredentials removes the usernameFile and passwordFile parameters from the query string of
+// the data source name, and returns the remaining data source name along with the credential files.
+func ParseCredentials(dataSourceName string) (string, Credentials, error) {
+	var credentials Credentials
+	base, query, ok := strings.Cut(dataSourceName, "?")
+	if !ok {
+		return dataSourceName, credentials, nil
+	}
+
+	var params []string
+	for _, param := range strings.Split(query, "&") {
+		name, value, _ := strings.Cut(param, "=")
+		if name != usernameFileParam && name != passwordFileParam {
+			params = append(params, param)
+			continue
+		}
+		value, err := url.QueryUnescape(value)
+		if err != nil {
+			return "", credentials, errors.Wrapf(err, "parsing %s", name)
+		}
+		if name == usernameFileParam {
+			credentials.UsernameFile = value
+		} else {
+			credentials.PasswordFile = value
+		}
+	}
+
+	if len(params) == 0 {
+		return base, credentials, nil
+	}
+	return base + "?" + strings.Join(params, "&"), credentials, nil
+}
+
+// IsSet returns true if the username or password are read from a file.
+func (c Credentials) IsSet() bool {
+	return c.UsernameFile != "" || c.PasswordFile != ""
+}
+
+// Read returns the contents of the credential files. Empty values are returned for files that
+// are not set.
+func (c Credentials) Read() (username, password string, err error) {
+	if c.UsernameFile != "" {
+		if username, err = util.ReadSecretFile(c.UsernameFile); err != nil {
+			return "", "", errors.Wrap(err, "reading username file")
+		}
+	}
+	if c.PasswordFile != "" {
+		if password, err = util.ReadSecretFile(c.PasswordFile); err != nil {
+			return "", "", errors.Wrap(err, "reading password file")
+		}
+	}
+	return username, password, nil
+}
+
+// Apply returns the data source name with the username and password read from the credential
+// files, if any.
+func (c Credentials) Apply(dataSourceName string, setCredentials SetCredentialsFunc) (string, error) {
+	if !c.IsSet() {
+		return dataSourceName, nil
+	}
+	username, password, err := c.Read()
+	if err != nil {
+		return "", err
+	}
+	return setCredentials(dataSourceName, username, password)
+}
+
+// NewConnector returns a connector that opens connections to the data source name with the driver.
+// If credential files are set, they are read each time a connection is opened, and a new connector
+// is created with the updated data source name when their contents change. Connections that are
+// already open are not affected.
+func NewConnector(d driver.Driver, dataSourceName string, credentials Credentials, setCredentials SetCredentialsFunc) (driver.Connector, error) {
+	if !credentials.IsSet() {
+		return openConnector(d, dataSourceName)
+	}
+
+	c := &credentialsConnector{
+		driver:         d,
+		dataSourceName: dataSourceName,
+		credentials:    credentials,
+		setCredentials: setCredentials,
+	}
+	if _, err := c.current(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func openConnector(d driver.Driver, dataSourceName string) (driver.Connector, error) {
+	if dc, ok := d.(driver.DriverContext); ok {
+		return dc.OpenConnector(dataSourceName)
+	}
+	return dsnConnector{driver: d, dataSourceName: dataSourceName}, nil
+}
+
+// dsnConnector opens connections with drivers that do not implement driver.DriverContext.
+type dsnConnector struct {
+	driver         driver.Driver
+	dataSourceName string
+}
+
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dataSourceName)
+}
+
+func (c dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// credentialsConnector opens connections with the credentials currently in the credential files.
+type credentialsConnector struct {
+	driver         driver.Driver
+	dataSourceName string
+	credentials    Credentials
+	setCredentials SetCredentialsFunc
+
+	mu        sync.Mutex
+	username  string
+	password  string
+	connector driver.Connector
+}
+
+// current returns the connector for the contents of the credential files, creating a new
+// connector if they have changed since the last connection was opened.
+func (c *credentialsConnector) current() (driver.Connector, error) {
+	username, password, err := c.credentials.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.connector != nil && username == c.username && password == c.password {
+		return c.connector, nil
+	}
+
+	dataSourceName, err := c.setCredentials(c.dataSourceName, username, password)
+	if err != nil {
+		return nil, err
+	}
+	connector, err := openConnector(c.driver, dataSourceName)
+	if err != nil {
+		return nil, err
+	}
+	if c.connector != nil {
+		logrus.Infof("Database credentials have changed, using the new credentials for new connections")
+	}
+	c.connector, c.username, c.password = connector, username, password
+	return connector, nil
+}
+
+func (c *credentialsConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	connector, err := c.current()
+	if err != nil {
+		return nil, err
+	}
+	return connector.Connect(ctx)
+}
+
+func (c *credentialsConnector) Driver() driver.Driver {
+	return c.driver
+}
@@ -0,0 +1,167 @@
+package generic
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/k3s-io/kine/pkg/util"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	renewLeaseSQL = `
+		UPDATE kine_compact_lease
+		SET holder = ?, expires = ?
+		WHERE id = 1 AND (holder = ? OR expires < ?)`
+	releaseLeaseSQL = `
+		UPDATE kine_compact_lease
+		SET holder = '', expires = 0
+		WHERE id = 1 AND holder = ?`
+)
+
+// Elector elects a single leader among the kine servers sharing a database. It is used to
+// ensure that only one server compacts the database at a time.
+type Elector interface {
+	// Elect acquires or renews leadership, and returns true if this server is the leader.
+	// Leadership is held for at least ttl, unless lost due to an error; Elect must be called
+	// again before it expires to retain leadership.
+	Elect(ctx context.Context, ttl time.Duration) (bool, error)
+	// Close releases leadership, if held.
+	Close() error
+}
+
+// LockElector elects a leader by holding a session-level lock, such as a postgres advisory lock
+// or a mysql named lock. The lock is held on a dedicated connection for as long as the connection
+// is alive; if the connection is lost, the lock is released by the database and another server
+// may acquire it.
+type LockElector struct {
+	DB *sql.DB
+	// TryLockSQL attempts to acquire the lock without waiting, and returns true if it was acquired.
+	TryLockSQL string
+	UnlockSQL  string
+
+	mu   sync.Mutex
+	conn *sql.Conn
+}
+
+// NewLockElector returns an elector for the given lock statements. References to the default
+// table in the statements are replaced by the table name, so that servers sharing a database but
+// using different tables elect separate leaders.
+func NewLockElector(db *sql.DB, table, tryLockSQL, unlockSQL string) *LockElector {
+	return &LockElector{
+		DB:         db,
+		TryLockSQL: TableSQL(table, tryLockSQL),
+		UnlockSQL:  TableSQL(table, unlockSQL),
+	}
+}
+
+func (e *LockElector) Elect(ctx context.Context, _ time.Duration) (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.conn != nil {
+		err := e.conn.PingContext(ctx)
+		if err == nil {
+			return true, nil
+		}
+		// the lock is released along with the session, so the connection can be discarded
+		e.discard()
+		return false, err
+	}
+
+	conn, err := e.DB.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	logrus.Tracef("ELECT LOCK : %v", util.Stripped(e.TryLockSQL))
+	var acquired sql.NullBool
+	if err := conn.QueryRowContext(ctx, e.TryLockSQL).Scan(&acquired); err != nil {
+		conn.Close()
+		return false, err
+	}
+	if !acquired.Bool {
+		conn.Close()
+		return false, nil
+	}
+
+	e.conn = conn
+	return true, nil
+}
+
+func (e *LockElector) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.conn == nil {
+		return nil
+	}
+	if _, err := e.conn.ExecContext(context.Background(), e.UnlockSQL); err != nil {
+		// make sure that the lock is not left held by a connection returned to the pool
+		e.discard()
+		return err
+	}
+	err := e.conn.Close()
+	e.conn = nil
+	return err
+}
+
+// discard closes the lock connection without returning it to the pool.
+func (e *LockElector) discard() {
+	_ = e.conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	_ = e.conn.Close()
+	e.conn = nil
+}
+
+// LeaseElector elects a leader by recording a lease in the compaction lease table. The leader
+// renews the lease each time Elect is called; if it fails to do so before the lease expires,
+// another server may take it over. The lease table must contain a single row with id 1.
+type LeaseElector struct {
+	DB     *sql.DB
+	Holder string
+
+	renewSQL   string
+	releaseSQL string
+}
+
+// NewLeaseElector returns an elector using the lease table named after the table. The lease is
+// held under a name identifying this process.
+func NewLeaseElector(db *sql.DB, table, paramCharacter string, numbered bool) *LeaseElector {
+	return &LeaseElector{
+		DB:         db,
+		Holder:     holderName(),
+		renewSQL:   q(TableSQL(table, renewLeaseSQL), paramCharacter, numbered),
+		releaseSQL: q(TableSQL(table, releaseLeaseSQL), paramCharacter, numbered),
+	}
+}
+
+// holderName returns a name identifying this process among the servers sharing a database.
+func holderName() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), strconv.FormatInt(time.Now().UnixNano(), 36))
+}
+
+func (e *LeaseElector) Elect(ctx context.Context, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	logrus.Tracef("ELECT LEASE %v : %v", e.Holder, util.Stripped(e.renewSQL))
+	result, err := e.DB.ExecContext(ctx, e.renewSQL, e.Holder, now.Add(ttl).Unix(), e.Holder, now.Unix())
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+func (e *LeaseElector) Close() error {
+	_, err := e.DB.Exec(e.releaseSQL, e.Holder)
+	return err
+}
@@ -0,0 +1,39 @@
+package generic
+
+import (
+	"context"
+
+	"github.com/k3s-io/kine/pkg/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+// failover discards idle connections after a write fails because the database is read-only,
+// as happens when the server has been demoted to a replica, so that new connections are made
+// to the current writer. It must be called after the failed statement has released its
+// connection, so that the connection is discarded along with the other idle connections.
+// Connections that are in use are discarded when they fail in turn.
+func (d *Generic) failover(err error) {
+	d.failovers.Add(1)
+	metrics.SQLFailoverTotal.Inc()
+	logrus.Warnf("Database is read-only, reconnecting to the current writer: %v", err)
+
+	d.DB.SetMaxIdleConns(0)
+	d.DB.SetMaxIdleConns(d.maxIdleConns)
+}
+
+// Failovers returns the number of times that writes have found the database to be read-only,
+// and connections have been moved to the current writer.
+func (d *Generic) Failovers() int64 {
+	return d.failovers.Load()
+}
+
+// AdvanceRevision ensures that rows inserted after it returns have a revision greater than the
+// given revision, by filling the revision if the current revision is behind it.
+func (d *Generic) AdvanceRevision(ctx context.Context, revision int64) error {
+	if d.AdvanceRevisionSQL != "" {
+		if _, err := d.execute(ctx, d.AdvanceRevisionSQL, revision); err != nil {
+			return err
+		}
+	}
+	return d.Fill(ctx, revision)
+}
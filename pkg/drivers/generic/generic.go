@@ -3,12 +3,14 @@ package generic
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Rican7/retry/backoff"
@@ -29,16 +31,37 @@ const (
 var _ server.Dialect = (*Generic)(nil)
 
 var (
-	columns = "kv.id AS theid, kv.name AS thename, kv.created, kv.deleted, kv.create_revision, kv.prev_revision, kv.lease, kv.value, kv.old_value"
+	columns = "kv.id AS theid, kv.name AS thename, kv.created, kv.deleted, kv.create_revision, kv.prev_revision, kv.lease, kv.value, kv.old_value, kv.compression, kv.compression AS old_compression"
 	revSQL  = `
 		SELECT MAX(rkv.id) AS id
 		FROM kine AS rkv`
 
+	timeRevSQL = `
+		SELECT trkv.id
+		FROM kine AS trkv
+		WHERE trkv.created_at <= ?
+		ORDER BY trkv.id DESC
+		LIMIT 1`
+
 	compactRevSQL = `
 		SELECT MAX(crkv.prev_revision) AS prev_revision
 		FROM kine AS crkv
 		WHERE crkv.name = 'compact_rev_key'`
 
+	setPollRevisionSQL = `
+		INSERT INTO kine_poll_revision(holder, revision, expires)
+		VALUES(?, ?, ?)
+		ON CONFLICT(holder) DO UPDATE SET revision = excluded.revision, expires = excluded.expires`
+
+	minPollRevisionSQL = `
+		SELECT MIN(revision)
+		FROM kine_poll_revision
+		WHERE holder <> ? AND expires >= ?`
+
+	deletePollRevisionSQL = `
+		DELETE FROM kine_poll_revision
+		WHERE holder = ? OR expires < ?`
+
 	listSQL = fmt.Sprintf(`
 		SELECT *
 		FROM (
@@ -58,11 +81,27 @@ var (
 		) AS lkv
 		ORDER BY lkv.thename ASC
 		`, revSQL, compactRevSQL, columns)
+
+	// prevColumns resolves the previous value from the row at prev_revision if the old value
+	// is not stored in the row itself. The previous value is only used by watch events,
+	// so list queries use the stored old value and avoid the join.
+	prevColumns = `kv.id AS theid, kv.name AS thename, kv.created, kv.deleted, kv.create_revision, kv.prev_revision, kv.lease, kv.value,
+		COALESCE(kv.old_value, pkv.value) AS old_value, kv.compression,
+		CASE WHEN kv.old_value IS NULL THEN pkv.compression ELSE kv.compression END AS old_compression`
+	prevJoin = `LEFT JOIN kine AS pkv ON pkv.id = kv.prev_revision AND pkv.name = kv.name`
 )
 
 type ErrRetry func(error) bool
 type TranslateErr func(error) error
 type ErrCode func(error) string
+type Listener func(context.Context) <-chan int64
+
+// CompressionConfig selects the algorithm used to compress values. Rows are only
+// compressed if the combined size of the value and previous value is at least MinSize.
+type CompressionConfig struct {
+	Algorithm string // empty or "none" disables compression; otherwise one of "s2" or "zstd"
+	MinSize   int
+}
 
 type ConnectionPoolConfig struct {
 	MaxIdle     int           // zero means defaultMaxIdleConns; negative means 0
@@ -76,14 +115,19 @@ type Generic struct {
 	LockWrites            bool
 	LastInsertID          bool
 	DB                    *sql.DB
+	Table                 string
 	GetCurrentSQL         string
 	GetRevisionSQL        string
 	RevisionSQL           string
+	TimeRevisionSQL       string
+	CompactRevisionSQL    string
 	ListRevisionStartSQL  string
 	GetRevisionAfterSQL   string
 	CountCurrentSQL       string
 	CountRevisionSQL      string
 	AfterSQL              string
+	HistorySQL            string
+	KeyHistorySQL         string
 	DeleteSQL             string
 	CompactSQL            string
 	UpdateCompactSQL      string
@@ -96,7 +140,52 @@ type Generic struct {
 	InsertRetry           ErrRetry
 	TranslateErr          TranslateErr
 	ErrCode               ErrCode
+	Listener              Listener
 	FillRetryDuration     time.Duration
+	Compression           util.Compression
+	CompressionMinSize    int
+	// RetentionDryRun disables removal of rows by compaction; the rows that would be
+	// removed are reported instead.
+	RetentionDryRun bool
+	// OmitOldValue disables storing the previous value in each row; it is instead
+	// resolved from the row at prev_revision when read. Drivers enable it once the schema
+	// migration that clears stored previous values has been applied, as older versions of
+	// kine return empty previous values for watch events on rows written without them.
+	OmitOldValue bool
+	// CompactElector elects the server that compacts the database, if several servers share
+	// it. If nil, every server compacts.
+	CompactElector Elector
+	// PollRevisions records the revision read by each server's poll loop in the poll revision
+	// table, so that compaction does not remove the previous values of rows that other servers
+	// have yet to send to watchers. Drivers enable it once the schema migration that creates
+	// the table has been applied.
+	PollRevisions bool
+	// SetPollRevisionSQL inserts or updates the poll revision of a server.
+	SetPollRevisionSQL string
+	// Replica is the connection pool for a read replica of the database, if one is configured.
+	Replica *sql.DB
+	// ReadOnly returns true if a write failed because the database is read-only. The write is
+	// retried after reconnecting, which is expected to find the current writer if the database
+	// has failed over.
+	ReadOnly ErrRetry
+	// AdvanceRevisionSQL advances the revision sequence to at least the given revision, for
+	// databases where inserting a row with an explicit revision does not.
+	AdvanceRevisionSQL string
+
+	paramCharacter   string
+	numbered         bool
+	retentionRules   []RetentionRule
+	compactSQL       string
+	compactReportSQL string
+	maxIdleConns     int
+	failovers        atomic.Int64
+	// replicaRevision is the latest revision known to have been replicated to the replica,
+	// or -1 if the replica is unavailable.
+	replicaRevision atomic.Int64
+	// holder identifies this server in the poll revision table.
+	holder                string
+	minPollRevisionSQL    string
+	deletePollRevisionSQL string
 }
 
 func q(sql, param string, numbered bool) string {
@@ -119,7 +208,7 @@ func (d *Generic) Migrate(ctx context.Context) {
 	var (
 		count     = 0
 		countKV   = d.queryRow(ctx, "SELECT COUNT(*) FROM key_value")
-		countKine = d.queryRow(ctx, "SELECT COUNT(*) FROM kine")
+		countKine = d.queryRow(ctx, TableSQL(d.Table, "SELECT COUNT(*) FROM kine"))
 	)
 
 	if err := countKV.Scan(&count); err != nil || count == 0 {
@@ -131,17 +220,19 @@ func (d *Generic) Migrate(ctx context.Context) {
 	}
 
 	logrus.Infof("Migrating content from old table")
-	_, err := d.execute(ctx,
+	_, err := d.execute(ctx, TableSQL(d.Table,
 		`INSERT INTO kine(deleted, create_revision, prev_revision, name, value, created, lease)
 					SELECT 0, 0, 0, kv.name, kv.value, 1, CASE WHEN kv.ttl > 0 THEN 15 ELSE 0 END
 					FROM key_value kv
-						WHERE kv.id IN (SELECT MAX(kvd.id) FROM key_value kvd GROUP BY kvd.name)`)
+						WHERE kv.id IN (SELECT MAX(kvd.id) FROM key_value kvd GROUP BY kvd.name)`))
 	if err != nil {
 		logrus.Errorf("Migration failed: %v", err)
 	}
 }
 
-func configureConnectionPooling(connPoolConfig ConnectionPoolConfig, db *sql.DB, driverName string) {
+// configureConnectionPooling configures the connection pool, and returns the maximum number of
+// idle connections.
+func configureConnectionPooling(connPoolConfig ConnectionPoolConfig, db *sql.DB, driverName string) int {
 	// behavior copied from database/sql - zero means defaultMaxIdleConns; negative means 0
 	if connPoolConfig.MaxIdle < 0 {
 		connPoolConfig.MaxIdle = 0
@@ -153,10 +244,11 @@ func configureConnectionPooling(connPoolConfig ConnectionPoolConfig, db *sql.DB,
 	db.SetMaxIdleConns(connPoolConfig.MaxIdle)
 	db.SetMaxOpenConns(connPoolConfig.MaxOpen)
 	db.SetConnMaxLifetime(connPoolConfig.MaxLifetime)
+	return connPoolConfig.MaxIdle
 }
 
-func openAndTest(driverName, dataSourceName string) (*sql.DB, error) {
-	db, err := sql.Open(driverName, dataSourceName)
+func openAndTest(open func() (*sql.DB, error)) (*sql.DB, error) {
+	db, err := open()
 	if err != nil {
 		return nil, err
 	}
@@ -171,14 +263,28 @@ func openAndTest(driverName, dataSourceName string) (*sql.DB, error) {
 	return db, nil
 }
 
-func Open(ctx context.Context, driverName, dataSourceName string, connPoolConfig ConnectionPoolConfig, paramCharacter string, numbered bool, metricsRegisterer prometheus.Registerer) (*Generic, error) {
+func Open(ctx context.Context, driverName, dataSourceName, table string, connPoolConfig ConnectionPoolConfig, paramCharacter string, numbered bool, metricsRegisterer prometheus.Registerer) (*Generic, error) {
+	return open(ctx, driverName, func() (*sql.DB, error) {
+		return sql.Open(driverName, dataSourceName)
+	}, table, connPoolConfig, paramCharacter, numbered, metricsRegisterer)
+}
+
+// OpenConnector is like Open, but opens connections with the connector, such as one returned by
+// NewConnector that reads credentials from files.
+func OpenConnector(ctx context.Context, driverName string, connector driver.Connector, table string, connPoolConfig ConnectionPoolConfig, paramCharacter string, numbered bool, metricsRegisterer prometheus.Registerer) (*Generic, error) {
+	return open(ctx, driverName, func() (*sql.DB, error) {
+		return sql.OpenDB(connector), nil
+	}, table, connPoolConfig, paramCharacter, numbered, metricsRegisterer)
+}
+
+func open(ctx context.Context, driverName string, openDB func() (*sql.DB, error), table string, connPoolConfig ConnectionPoolConfig, paramCharacter string, numbered bool, metricsRegisterer prometheus.Registerer) (*Generic, error) {
 	var (
 		db  *sql.DB
 		err error
 	)
 
 	for i := 0; i < 300; i++ {
-		db, err = openAndTest(driverName, dataSourceName)
+		db, err = openAndTest(openDB)
 		if err == nil {
 			break
 		}
@@ -191,81 +297,128 @@ func Open(ctx context.Context, driverName, dataSourceName string, connPoolConfig
 		}
 	}
 
-	configureConnectionPooling(connPoolConfig, db, driverName)
+	maxIdleConns := configureConnectionPooling(connPoolConfig, db, driverName)
 
 	if metricsRegisterer != nil {
 		metricsRegisterer.MustRegister(collectors.NewDBStatsCollector(db, "kine"))
 	}
 
-	return &Generic{
-		DB: db,
+	tq := func(sql string) string {
+		return q(TableSQL(table, sql), paramCharacter, numbered)
+	}
 
-		GetRevisionSQL: q(fmt.Sprintf(`
+	return &Generic{
+		DB:             db,
+		Table:          table,
+		paramCharacter: paramCharacter,
+		numbered:       numbered,
+		maxIdleConns:   maxIdleConns,
+		holder:         holderName(),
+
+		SetPollRevisionSQL:    tq(setPollRevisionSQL),
+		minPollRevisionSQL:    tq(minPollRevisionSQL),
+		deletePollRevisionSQL: tq(deletePollRevisionSQL),
+
+		RevisionSQL:        TableSQL(table, revSQL),
+		CompactRevisionSQL: TableSQL(table, compactRevSQL),
+		TimeRevisionSQL:    tq(timeRevSQL),
+
+		GetRevisionSQL: tq(fmt.Sprintf(`
 			SELECT
 			0, 0, %s
 			FROM kine AS kv
-			WHERE kv.id = ?`, columns), paramCharacter, numbered),
+			%s
+			WHERE kv.id = ?`, prevColumns, prevJoin)),
 
-		GetCurrentSQL:        q(fmt.Sprintf(listSQL, "AND mkv.name > ?"), paramCharacter, numbered),
-		ListRevisionStartSQL: q(fmt.Sprintf(listSQL, "AND mkv.id <= ?"), paramCharacter, numbered),
-		GetRevisionAfterSQL:  q(fmt.Sprintf(listSQL, "AND mkv.name > ? AND mkv.id <= ?"), paramCharacter, numbered),
+		GetCurrentSQL:        tq(fmt.Sprintf(listSQL, "AND mkv.name > ?")),
+		ListRevisionStartSQL: tq(fmt.Sprintf(listSQL, "AND mkv.id <= ?")),
+		GetRevisionAfterSQL:  tq(fmt.Sprintf(listSQL, "AND mkv.name > ? AND mkv.id <= ?")),
 
-		CountCurrentSQL: q(fmt.Sprintf(`
+		CountCurrentSQL: tq(fmt.Sprintf(`
 			SELECT (%s), COUNT(c.theid)
 			FROM (
 				%s
-			) c`, revSQL, fmt.Sprintf(listSQL, "AND mkv.name > ?")), paramCharacter, numbered),
+			) c`, revSQL, fmt.Sprintf(listSQL, "AND mkv.name > ?"))),
 
-		CountRevisionSQL: q(fmt.Sprintf(`
+		CountRevisionSQL: tq(fmt.Sprintf(`
 			SELECT (%s), COUNT(c.theid)
 			FROM (
 				%s
-			) c`, revSQL, fmt.Sprintf(listSQL, "AND mkv.name > ? AND mkv.id <= ?")), paramCharacter, numbered),
+			) c`, revSQL, fmt.Sprintf(listSQL, "AND mkv.name > ? AND mkv.id <= ?"))),
 
-		AfterSQL: q(fmt.Sprintf(`
+		AfterSQL: tq(fmt.Sprintf(`
 			SELECT (%s), (%s), %s
 			FROM kine AS kv
+			%s
 			WHERE
 				kv.name LIKE ? AND
 				kv.id > ?
-			ORDER BY kv.id ASC`, revSQL, compactRevSQL, columns), paramCharacter, numbered),
+			ORDER BY kv.id ASC`, revSQL, compactRevSQL, prevColumns, prevJoin)),
+
+		HistorySQL: tq(fmt.Sprintf(`
+			SELECT (%s), (%s), %s
+			FROM kine AS kv
+			%s
+			WHERE
+				kv.name LIKE ? AND
+				kv.id >= ? AND
+				kv.id <= ?
+			ORDER BY kv.id ASC`, revSQL, compactRevSQL, prevColumns, prevJoin)),
 
-		DeleteSQL: q(`
+		KeyHistorySQL: tq(fmt.Sprintf(`
+			SELECT (%s), (%s), %s
+			FROM kine AS kv
+			%s
+			WHERE
+				kv.name = ? AND
+				kv.id >= ? AND
+				kv.id <= ?
+			ORDER BY kv.id ASC`, revSQL, compactRevSQL, prevColumns, prevJoin)),
+
+		DeleteSQL: tq(`
 			DELETE FROM kine AS kv
-			WHERE kv.id = ?`, paramCharacter, numbered),
+			WHERE kv.id = ?`),
 
-		UpdateCompactSQL: q(`
+		UpdateCompactSQL: tq(`
 			UPDATE kine
 			SET prev_revision = ?
-			WHERE name = 'compact_rev_key'`, paramCharacter, numbered),
+			WHERE name = 'compact_rev_key'`),
 
-		InsertLastInsertIDSQL: q(`INSERT INTO kine(name, created, deleted, create_revision, prev_revision, lease, value, old_value)
-			values(?, ?, ?, ?, ?, ?, ?, ?)`, paramCharacter, numbered),
+		InsertLastInsertIDSQL: tq(`INSERT INTO kine(name, created, deleted, create_revision, prev_revision, lease, value, old_value, compression, created_at)
+			values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
 
-		InsertSQL: q(`INSERT INTO kine(name, created, deleted, create_revision, prev_revision, lease, value, old_value)
-			values(?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`, paramCharacter, numbered),
+		InsertSQL: tq(`INSERT INTO kine(name, created, deleted, create_revision, prev_revision, lease, value, old_value, compression, created_at)
+			values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`),
 
-		FillSQL: q(`INSERT INTO kine(id, name, created, deleted, create_revision, prev_revision, lease, value, old_value)
-			values(?, ?, ?, ?, ?, ?, ?, ?, ?)`, paramCharacter, numbered),
+		FillSQL: tq(`INSERT INTO kine(id, name, created, deleted, create_revision, prev_revision, lease, value, old_value, created_at)
+			values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
 	}, err
 }
 
 func (d *Generic) query(ctx context.Context, sql string, args ...interface{}) (result *sql.Rows, err error) {
+	return d.queryDB(ctx, d.DB, sql, args...)
+}
+
+func (d *Generic) queryDB(ctx context.Context, db *sql.DB, sql string, args ...interface{}) (result *sql.Rows, err error) {
 	logrus.Tracef("QUERY %v : %s", args, util.Stripped(sql))
 	startTime := time.Now()
 	defer func() {
 		metrics.ObserveSQL(startTime, d.ErrCode(err), util.Stripped(sql), args)
 	}()
-	return d.DB.QueryContext(ctx, sql, args...)
+	return db.QueryContext(ctx, sql, args...)
 }
 
 func (d *Generic) queryRow(ctx context.Context, sql string, args ...interface{}) (result *sql.Row) {
+	return d.queryRowDB(ctx, d.DB, sql, args...)
+}
+
+func (d *Generic) queryRowDB(ctx context.Context, db *sql.DB, sql string, args ...interface{}) (result *sql.Row) {
 	logrus.Tracef("QUERY ROW %v : %s", args, util.Stripped(sql))
 	startTime := time.Now()
 	defer func() {
 		metrics.ObserveSQL(startTime, d.ErrCode(result.Err()), util.Stripped(sql), args)
 	}()
-	return d.DB.QueryRowContext(ctx, sql, args...)
+	return db.QueryRowContext(ctx, sql, args...)
 }
 
 func (d *Generic) execute(ctx context.Context, sql string, args ...interface{}) (result sql.Result, err error) {
@@ -280,6 +433,11 @@ func (d *Generic) execute(ctx context.Context, sql string, args ...interface{})
 		startTime := time.Now()
 		result, err = d.DB.ExecContext(ctx, sql, args...)
 		metrics.ObserveSQL(startTime, d.ErrCode(err), util.Stripped(sql), args)
+		if err != nil && d.ReadOnly != nil && d.ReadOnly(err) {
+			d.failover(err)
+			wait(i)
+			continue
+		}
 		if err != nil && d.Retry != nil && d.Retry(err) {
 			wait(i)
 			continue
@@ -291,7 +449,7 @@ func (d *Generic) execute(ctx context.Context, sql string, args ...interface{})
 
 func (d *Generic) GetCompactRevision(ctx context.Context) (int64, error) {
 	var id int64
-	row := d.queryRow(ctx, compactRevSQL)
+	row := d.queryRow(ctx, d.CompactRevisionSQL)
 	err := row.Scan(&id)
 	if err == sql.ErrNoRows {
 		return 0, nil
@@ -305,17 +463,26 @@ func (d *Generic) SetCompactRevision(ctx context.Context, revision int64) error
 	return err
 }
 
+// Compact deletes rows that were replaced or deleted at or before the revision. Rows that
+// are the previous revision of a row after the compact revision are not deleted, as their
+// value may still be read as the previous value of a watch event.
 func (d *Generic) Compact(ctx context.Context, revision int64) (int64, error) {
 	logrus.Tracef("COMPACT %v", revision)
-	res, err := d.execute(ctx, d.CompactSQL, revision, revision)
+	res, err := d.execute(ctx, d.compactSQL, d.compactArgs(revision)...)
 	if err != nil {
 		return 0, err
 	}
 	return res.RowsAffected()
 }
 
+// PostCompact runs the dialect's post-compact statement, if any, and removes expired poll revisions.
 func (d *Generic) PostCompact(ctx context.Context) error {
 	logrus.Trace("POSTCOMPACT")
+	if d.PollRevisions {
+		if _, err := d.execute(ctx, d.deletePollRevisionSQL, "", time.Now().Unix()); err != nil {
+			return err
+		}
+	}
 	if d.PostCompactSQL != "" {
 		_, err := d.execute(ctx, d.PostCompactSQL)
 		return err
@@ -323,6 +490,37 @@ func (d *Generic) PostCompact(ctx context.Context) error {
 	return nil
 }
 
+// SetPollRevision records that this server has read rows up to the revision. The record expires
+// after ttl, unless it is set again before then.
+func (d *Generic) SetPollRevision(ctx context.Context, revision int64, ttl time.Duration) error {
+	if !d.PollRevisions {
+		return nil
+	}
+	_, err := d.execute(ctx, d.SetPollRevisionSQL, d.holder, revision, time.Now().Add(ttl).Unix())
+	return err
+}
+
+// MinPollRevision returns the lowest revision recorded by another server whose record has not
+// expired, or zero if there are no such records. This server's own poll revision is excluded, as
+// the caller knows its current value.
+func (d *Generic) MinPollRevision(ctx context.Context) (int64, error) {
+	if !d.PollRevisions {
+		return 0, nil
+	}
+	var rev sql.NullInt64
+	err := d.queryRow(ctx, d.minPollRevisionSQL, d.holder, time.Now().Unix()).Scan(&rev)
+	return rev.Int64, err
+}
+
+// CompactLeader returns true if this server should compact the database. Leadership is
+// held for at least ttl, and must be renewed by calling CompactLeader again before then.
+func (d *Generic) CompactLeader(ctx context.Context, ttl time.Duration) (bool, error) {
+	if d.CompactElector == nil {
+		return true, nil
+	}
+	return d.CompactElector.Elect(ctx, ttl)
+}
+
 func (d *Generic) GetRevision(ctx context.Context, revision int64) (*sql.Rows, error) {
 	return d.query(ctx, d.GetRevisionSQL, revision)
 }
@@ -338,7 +536,7 @@ func (d *Generic) ListCurrent(ctx context.Context, prefix, startKey string, limi
 	if limit > 0 {
 		sql = fmt.Sprintf("%s LIMIT %d", sql, limit)
 	}
-	return d.query(ctx, sql, prefix, startKey, includeDeleted)
+	return d.queryDB(ctx, d.reader(ctx, 0), sql, prefix, startKey, includeDeleted)
 }
 
 func (d *Generic) List(ctx context.Context, prefix, startKey string, limit, revision int64, includeDeleted bool) (*sql.Rows, error) {
@@ -347,14 +545,14 @@ func (d *Generic) List(ctx context.Context, prefix, startKey string, limit, revi
 		if limit > 0 {
 			sql = fmt.Sprintf("%s LIMIT %d", sql, limit)
 		}
-		return d.query(ctx, sql, prefix, revision, includeDeleted)
+		return d.queryDB(ctx, d.reader(ctx, revision), sql, prefix, revision, includeDeleted)
 	}
 
 	sql := d.GetRevisionAfterSQL
 	if limit > 0 {
 		sql = fmt.Sprintf("%s LIMIT %d", sql, limit)
 	}
-	return d.query(ctx, sql, prefix, startKey, revision, includeDeleted)
+	return d.queryDB(ctx, d.reader(ctx, revision), sql, prefix, startKey, revision, includeDeleted)
 }
 
 func (d *Generic) CountCurrent(ctx context.Context, prefix, startKey string) (int64, int64, error) {
@@ -363,7 +561,7 @@ func (d *Generic) CountCurrent(ctx context.Context, prefix, startKey string) (in
 		id  int64
 	)
 
-	row := d.queryRow(ctx, d.CountCurrentSQL, prefix, startKey, false)
+	row := d.queryRowDB(ctx, d.reader(ctx, 0), d.CountCurrentSQL, prefix, startKey, false)
 	err := row.Scan(&rev, &id)
 	return rev.Int64, id, err
 }
@@ -374,14 +572,40 @@ func (d *Generic) Count(ctx context.Context, prefix, startKey string, revision i
 		id  int64
 	)
 
-	row := d.queryRow(ctx, d.CountRevisionSQL, prefix, startKey, revision, false)
+	row := d.queryRowDB(ctx, d.reader(ctx, revision), d.CountRevisionSQL, prefix, startKey, revision, false)
 	err := row.Scan(&rev, &id)
 	return rev.Int64, id, err
 }
 
 func (d *Generic) CurrentRevision(ctx context.Context) (int64, error) {
 	var id int64
-	row := d.queryRow(ctx, revSQL)
+	row := d.queryRow(ctx, d.RevisionSQL)
+	err := row.Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+// History returns the rows for the key, or for keys with the key as a prefix, between the start
+// and end revisions inclusive.
+func (d *Generic) History(ctx context.Context, key string, prefix bool, startRevision, endRevision, limit int64) (*sql.Rows, error) {
+	sql := d.KeyHistorySQL
+	if prefix {
+		sql = d.HistorySQL
+		key += "%"
+	}
+	if limit > 0 {
+		sql = fmt.Sprintf("%s LIMIT %d", sql, limit)
+	}
+	return d.query(ctx, sql, key, startRevision, endRevision)
+}
+
+// RevisionAt returns the latest revision created at or before the given time, or zero if
+// there are no such rows. Rows written before the creation time was recorded are ignored.
+func (d *Generic) RevisionAt(ctx context.Context, t time.Time) (int64, error) {
+	var id int64
+	row := d.queryRow(ctx, d.TimeRevisionSQL, t.Unix())
 	err := row.Scan(&id)
 	if err == sql.ErrNoRows {
 		return 0, nil
@@ -398,7 +622,7 @@ func (d *Generic) After(ctx context.Context, prefix string, rev, limit int64) (*
 }
 
 func (d *Generic) Fill(ctx context.Context, revision int64) error {
-	_, err := d.execute(ctx, d.FillSQL, revision, fmt.Sprintf("gap-%d", revision), 0, 1, 0, 0, 0, nil, nil)
+	_, err := d.execute(ctx, d.FillSQL, revision, fmt.Sprintf("gap-%d", revision), 0, 1, 0, 0, 0, nil, nil, time.Now().Unix())
 	return err
 }
 
@@ -425,8 +649,25 @@ func (d *Generic) Insert(ctx context.Context, key string, create, delete bool, c
 		dVal = 1
 	}
 
+	if d.OmitOldValue && len(prevValue) > 0 {
+		// the saved bytes are only counted once the row has been inserted
+		saved := float64(len(prevValue))
+		defer func() {
+			if err == nil {
+				metrics.OldValueSavedBytes.Add(saved)
+			}
+		}()
+		prevValue = nil
+	}
+
+	compression, value, prevValue, err := d.compress(value, prevValue)
+	if err != nil {
+		return 0, err
+	}
+
+	createdAt := time.Now().Unix()
 	if d.LastInsertID {
-		row, err := d.execute(ctx, d.InsertLastInsertIDSQL, key, cVal, dVal, createRevision, previousRevision, ttl, value, prevValue)
+		row, err := d.execute(ctx, d.InsertLastInsertIDSQL, key, cVal, dVal, createRevision, previousRevision, ttl, value, prevValue, compression, createdAt)
 		if err != nil {
 			return 0, err
 		}
@@ -440,9 +681,15 @@ func (d *Generic) Insert(ctx context.Context, key string, create, delete bool, c
 	// duplicate key error to the client.
 	wait := strategy.Backoff(backoff.Linear(100 + time.Millisecond))
 	for i := uint(0); i < 20; i++ {
-		row := d.queryRow(ctx, d.InsertSQL, key, cVal, dVal, createRevision, previousRevision, ttl, value, prevValue)
+		row := d.queryRow(ctx, d.InsertSQL, key, cVal, dVal, createRevision, previousRevision, ttl, value, prevValue, compression, createdAt)
 		err = row.Scan(&id)
 
+		if err != nil && d.ReadOnly != nil && d.ReadOnly(err) {
+			d.failover(err)
+			wait(i)
+			continue
+		}
+
 		if err != nil && d.InsertRetry != nil && d.InsertRetry(err) {
 			logrus.Warnf("retriable insert error for key %v: %v", key, err)
 			metrics.InsertErrorsTotal.WithLabelValues("true").Inc()
@@ -460,6 +707,42 @@ func (d *Generic) Insert(ctx context.Context, key string, create, delete bool, c
 	return
 }
 
+// ConfigureCompression sets the algorithm used to compress values on insert.
+func (d *Generic) ConfigureCompression(config CompressionConfig) error {
+	compression, err := util.ParseCompression(config.Algorithm)
+	if err != nil {
+		return err
+	}
+	d.Compression = compression
+	d.CompressionMinSize = config.MinSize
+	return nil
+}
+
+// compress returns the compressed value and previous value, along with the algorithm used to
+// compress them. Values are stored uncompressed if they are too small, or do not compress well.
+func (d *Generic) compress(value, prevValue []byte) (util.Compression, []byte, []byte, error) {
+	size := len(value) + len(prevValue)
+	if d.Compression == util.CompressionNone || size == 0 || size < d.CompressionMinSize {
+		return util.CompressionNone, value, prevValue, nil
+	}
+
+	cValue, err := d.Compression.Compress(value)
+	if err != nil {
+		return util.CompressionNone, nil, nil, err
+	}
+	cPrevValue, err := d.Compression.Compress(prevValue)
+	if err != nil {
+		return util.CompressionNone, nil, nil, err
+	}
+
+	compressedSize := len(cValue) + len(cPrevValue)
+	metrics.CompressionRatio.WithLabelValues(d.Compression.String()).Observe(float64(compressedSize) / float64(size))
+	if compressedSize >= size {
+		return util.CompressionNone, value, prevValue, nil
+	}
+	return d.Compression, cValue, cPrevValue, nil
+}
+
 func (d *Generic) GetSize(ctx context.Context) (int64, error) {
 	if d.GetSizeSQL == "" {
 		return 0, errors.New("driver does not support size reporting")
@@ -475,3 +758,34 @@ func (d *Generic) GetSize(ctx context.Context) (int64, error) {
 func (d *Generic) FillRetryDelay(ctx context.Context) {
 	time.Sleep(d.FillRetryDuration)
 }
+
+// Notifications returns a channel that receives the revision of each row inserted by any client,
+// if supported by the database. The channel is closed when the context is cancelled.
+// A nil channel is returned if the database does not support notifications.
+func (d *Generic) Notifications(ctx context.Context) <-chan int64 {
+	if d.Listener == nil {
+		return nil
+	}
+	return d.Listener(ctx)
+}
+
+// Close closes the database connection pool, waiting for any in-progress queries to complete.
+func (d *Generic) Close() error {
+	logrus.Tracef("CLOSE")
+	if d.CompactElector != nil {
+		if err := d.CompactElector.Close(); err != nil {
+			logrus.Warnf("Failed to release compaction leadership: %v", err)
+		}
+	}
+	if d.PollRevisions {
+		if _, err := d.DB.Exec(d.deletePollRevisionSQL, d.holder, time.Now().Unix()); err != nil {
+			logrus.Warnf("Failed to remove poll revision: %v", err)
+		}
+	}
+	if d.Replica != nil {
+		if err := d.Replica.Close(); err != nil {
+			logrus.Warnf("Failed to close read replica connection pool: %v", err)
+		}
+	}
+	return d.DB.Close()
+}
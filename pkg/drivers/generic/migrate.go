@@ -0,0 +1,276 @@
+package generic
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/k3s-io/kine/pkg/util"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const initialDescription = "initial schema"
+
+var (
+	createSchemaTableSQL = `
+		CREATE TABLE IF NOT EXISTS kine_schema
+			(
+				version INTEGER PRIMARY KEY,
+				description VARCHAR(255),
+				applied BIGINT
+			)`
+	schemaVersionsSQL = `SELECT version, applied FROM kine_schema`
+	insertVersionSQL  = `INSERT INTO kine_schema(version, description, applied) values(?, ?, ?)`
+	kineExistsSQL     = `SELECT 1 FROM kine LIMIT 1`
+)
+
+// Migration is a versioned change to the database schema. Manual migrations may lock or
+// rewrite the kine table, and are only applied on startup if enabled by StartupVersion.
+type Migration struct {
+	Version     int
+	Description string
+	Statements  []string
+	Manual      bool
+}
+
+// MigrationStatus is a migration along with the time that it was applied.
+// The applied time is zero if the migration has not been applied.
+type MigrationStatus struct {
+	Migration
+	Applied time.Time
+}
+
+// Migrator applies schema migrations in order, recording the applied versions in the
+// kine_schema table. Version 1 is the initial schema, which always creates the latest
+// version of the kine table and indexes; on a new database all later migrations are
+// recorded as applied without being run. Migrations are run while holding the lock
+// acquired by LockSQL, if set.
+type Migrator struct {
+	DB         *sql.DB
+	Table      string
+	Schema     []string
+	Migrations []Migration
+	LockSQL    string
+	UnlockSQL  string
+	IgnoreErr  func(error) bool
+	// TableNotExist returns true if the error was returned because the table does not
+	// exist. The database is only treated as new if the kine table does not exist, and as
+	// having no applied versions if the schema table does not exist.
+	TableNotExist func(error) bool
+
+	insertVersionSQL string
+}
+
+// NewMigrator returns a migrator for the table. References to the default table in the
+// schema and migrations are replaced by the table name, and the applied versions are
+// recorded in a schema table named after it.
+func NewMigrator(db *sql.DB, table, paramCharacter string, numbered bool, schema []string, migrations []Migration) *Migrator {
+	return &Migrator{
+		DB:               db,
+		Table:            table,
+		Schema:           schema,
+		Migrations:       migrations,
+		insertVersionSQL: q(TableSQL(table, insertVersionSQL), paramCharacter, numbered),
+	}
+}
+
+// StartupVersion returns the highest version of manual migration that should be applied when
+// kine starts. Manual migrations are only applied on startup if enabled by the
+// KINE_SCHEMA_MIGRATION environment variable, which sets the number of migrations after the
+// initial schema to apply. The kine migrate command can be used to apply all pending migrations.
+func StartupVersion() int {
+	migrations, _ := strconv.ParseUint(os.Getenv("KINE_SCHEMA_MIGRATION"), 10, 64)
+	return int(migrations) + 1
+}
+
+// Status returns all known migrations, along with the time they were applied.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := m.appliedVersions(ctx, m.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	var status []MigrationStatus
+	for _, migration := range m.all() {
+		status = append(status, MigrationStatus{
+			Migration: migration,
+			Applied:   applied[migration.Version],
+		})
+	}
+	return status, nil
+}
+
+// Applied returns true if the migration with the given version has been applied.
+func (m *Migrator) Applied(ctx context.Context, version int) (bool, error) {
+	applied, err := m.appliedVersions(ctx, m.DB)
+	if err != nil {
+		return false, err
+	}
+	_, ok := applied[version]
+	return ok, nil
+}
+
+// Pending returns the migrations that would be run by Up with the same target.
+func (m *Migrator) Pending(ctx context.Context, target int) ([]Migration, error) {
+	applied, err := m.appliedVersions(ctx, m.DB)
+	if err != nil {
+		return nil, err
+	}
+	return m.pending(applied, target), nil
+}
+
+// Up applies pending migrations in order. Manual migrations with a version above the
+// target are skipped; a target of zero or less applies all migrations.
+func (m *Migrator) Up(ctx context.Context, target int) error {
+	logrus.Infof("Configuring database table schema and indexes, this may take a moment...")
+
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if m.LockSQL != "" {
+		lockSQL := TableSQL(m.Table, m.LockSQL)
+		logrus.Tracef("SETUP LOCK : %v", util.Stripped(lockSQL))
+		if _, err := conn.ExecContext(ctx, lockSQL); err != nil {
+			return errors.Wrap(err, "acquiring schema lock")
+		}
+		defer func() {
+			if _, err := conn.ExecContext(context.Background(), TableSQL(m.Table, m.UnlockSQL)); err != nil {
+				logrus.Warnf("Failed to release schema lock: %v", err)
+			}
+		}()
+	}
+
+	if _, err := conn.ExecContext(ctx, TableSQL(m.Table, createSchemaTableSQL)); err != nil {
+		return errors.Wrap(err, "creating schema table")
+	}
+
+	// read the applied versions while holding the lock, in case another client has
+	// applied migrations since we last checked
+	applied, err := m.appliedVersions(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	newDB := false
+	if len(applied) == 0 {
+		err := conn.QueryRowContext(ctx, TableSQL(m.Table, kineExistsSQL)).Scan(new(int))
+		switch {
+		case err == nil || err == sql.ErrNoRows:
+		case m.TableNotExist != nil && m.TableNotExist(err):
+			newDB = true
+		default:
+			return errors.Wrap(err, "checking for existing table")
+		}
+	}
+
+	for _, migration := range m.pending(applied, target) {
+		logrus.Infof("Applying schema migration %d: %s", migration.Version, migration.Description)
+		for _, stmt := range migration.Statements {
+			logrus.Tracef("SETUP EXEC MIGRATION %d: %v", migration.Version, util.Stripped(stmt))
+			if _, err := conn.ExecContext(ctx, stmt); err != nil {
+				if m.IgnoreErr == nil || !m.IgnoreErr(err) {
+					return errors.Wrapf(err, "applying schema migration %d", migration.Version)
+				}
+			}
+		}
+		if err := m.record(ctx, conn, migration); err != nil {
+			return err
+		}
+
+		// the initial schema is always the latest revision, so there is nothing else to do
+		// on a new database
+		if newDB && migration.Version == 1 {
+			for _, migration := range m.Migrations {
+				if err := m.record(ctx, conn, migration); err != nil {
+					return err
+				}
+			}
+			break
+		}
+	}
+
+	logrus.Infof("Database tables and indexes are up to date")
+	return nil
+}
+
+// Close closes the database. It should only be called if the migrator owns the database.
+func (m *Migrator) Close() error {
+	return m.DB.Close()
+}
+
+// all returns the initial schema and migrations, with references to the default table replaced.
+func (m *Migrator) all() []Migration {
+	var all []Migration
+	for _, migration := range append([]Migration{{Version: 1, Description: initialDescription, Statements: m.Schema}}, m.Migrations...) {
+		stmts := make([]string, len(migration.Statements))
+		for i, stmt := range migration.Statements {
+			stmts[i] = TableSQL(m.Table, stmt)
+		}
+		migration.Statements = stmts
+		all = append(all, migration)
+	}
+	return all
+}
+
+func (m *Migrator) pending(applied map[int]time.Time, target int) []Migration {
+	var pending []Migration
+	for _, migration := range m.all() {
+		if migration.Manual && target > 0 && migration.Version > target {
+			continue
+		}
+		if _, ok := applied[migration.Version]; !ok {
+			pending = append(pending, migration)
+		}
+	}
+	return pending
+}
+
+func (m *Migrator) record(ctx context.Context, conn *sql.Conn, migration Migration) error {
+	if _, err := conn.ExecContext(ctx, m.insertVersionSQL, migration.Version, migration.Description, time.Now().Unix()); err != nil {
+		return errors.Wrapf(err, "recording schema migration %d", migration.Version)
+	}
+	return nil
+}
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// appliedVersions returns the applied schema versions, and the time they were applied.
+// If the schema table does not exist, no versions have been applied.
+func (m *Migrator) appliedVersions(ctx context.Context, db queryer) (map[int]time.Time, error) {
+	applied := map[int]time.Time{}
+	rows, err := db.QueryContext(ctx, TableSQL(m.Table, schemaVersionsSQL))
+	if err != nil {
+		if m.TableNotExist != nil && m.TableNotExist(err) {
+			return applied, nil
+		}
+		return nil, errors.Wrap(err, "reading schema versions")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var version int
+		var timestamp int64
+		if err := rows.Scan(&version, &timestamp); err != nil {
+			return nil, err
+		}
+		applied[version] = time.Unix(timestamp, 0)
+	}
+	return applied, rows.Err()
+}
+
+func (s MigrationStatus) String() string {
+	state := "pending"
+	if !s.Applied.IsZero() {
+		state = "applied " + s.Applied.UTC().Format(time.RFC3339)
+	}
+	return fmt.Sprintf("%d\t%s\t%s", s.Version, s.Description, state)
+}
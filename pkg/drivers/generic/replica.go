@@ -0,0 +1,116 @@
+package generic
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"time"
+
+	"github.com/k3s-io/kine/pkg/metrics"
+	"github.com/k3s-io/kine/pkg/server"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/sirupsen/logrus"
+)
+
+// replicaCheckInterval is the interval at which the revision of the read replica is checked.
+const replicaCheckInterval = time.Second
+
+// OpenReplica opens a connection pool to a read replica of the database. Reads at a revision
+// that the replica has replicated, and serializable reads, are served by the replica; writes,
+// polling, and reads at the current revision use the primary database. The replica is checked
+// for availability and lag until the context is cancelled. Connection errors are not fatal, as
+// reads fall back to the primary database while the replica is unavailable.
+func (d *Generic) OpenReplica(ctx context.Context, driverName, dataSourceName string, connPoolConfig ConnectionPoolConfig, metricsRegisterer prometheus.Registerer) error {
+	db, err := sql.Open(driverName, dataSourceName)
+	if err != nil {
+		return err
+	}
+	d.openReplica(ctx, db, driverName, connPoolConfig, metricsRegisterer)
+	return nil
+}
+
+// OpenReplicaConnector is like OpenReplica, but opens connections with the connector.
+func (d *Generic) OpenReplicaConnector(ctx context.Context, driverName string, connector driver.Connector, connPoolConfig ConnectionPoolConfig, metricsRegisterer prometheus.Registerer) {
+	d.openReplica(ctx, sql.OpenDB(connector), driverName, connPoolConfig, metricsRegisterer)
+}
+
+func (d *Generic) openReplica(ctx context.Context, db *sql.DB, driverName string, connPoolConfig ConnectionPoolConfig, metricsRegisterer prometheus.Registerer) {
+	configureConnectionPooling(connPoolConfig, db, driverName+" replica")
+
+	if metricsRegisterer != nil {
+		metricsRegisterer.MustRegister(collectors.NewDBStatsCollector(db, "kine_replica"))
+	}
+
+	d.Replica = db
+	d.replicaRevision.Store(-1)
+	d.checkReplica(ctx)
+
+	go func() {
+		ticker := time.NewTicker(replicaCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				d.checkReplica(ctx)
+			}
+		}
+	}()
+}
+
+// checkReplica updates the revision of the replica and the replication lag.
+func (d *Generic) checkReplica(ctx context.Context) {
+	replicaRevision, err := d.queryReplicaRevision(ctx)
+	if err != nil {
+		if d.replicaRevision.Swap(-1) >= 0 {
+			logrus.Warnf("Read replica is unavailable, reading from the primary database: %v", err)
+		}
+		metrics.ReplicaLag.Set(-1)
+		return
+	}
+	if d.replicaRevision.Swap(replicaRevision) < 0 {
+		logrus.Infof("Read replica is available at revision %d", replicaRevision)
+	}
+
+	revision, err := d.CurrentRevision(ctx)
+	if err != nil {
+		logrus.Debugf("Failed to get current revision for replica lag: %v", err)
+		return
+	}
+	metrics.ReplicaLag.Set(float64(max(revision-replicaRevision, 0)))
+}
+
+func (d *Generic) queryReplicaRevision(ctx context.Context) (int64, error) {
+	var id sql.NullInt64
+	row := d.queryRowDB(ctx, d.Replica, d.RevisionSQL)
+	if err := row.Scan(&id); err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+	return id.Int64, nil
+}
+
+// reader returns the connection pool to read from. Reads at a revision use the replica if it
+// had replicated the revision when it was last checked; the replica is not checked again on
+// each read, so reads at revisions it has since replicated use the primary database until the
+// next check. Reads at the current revision use the primary database, unless the read is
+// serializable and the replica is available.
+func (d *Generic) reader(ctx context.Context, revision int64) *sql.DB {
+	if d.Replica == nil {
+		return d.DB
+	}
+
+	replicaRevision := d.replicaRevision.Load()
+	if revision == 0 {
+		if server.IsSerializable(ctx) && replicaRevision >= 0 {
+			return d.Replica
+		}
+		return d.DB
+	}
+
+	if replicaRevision >= revision {
+		return d.Replica
+	}
+	return d.DB
+}
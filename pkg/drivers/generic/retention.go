@@ -0,0 +1,190 @@
+package generic
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// compactCandidatesSQL selects the rows removed by compaction: rows replaced by a later
+	// revision, and deletion markers, at or before the compact revision. Retention rules are
+	// evaluated against the replacing row or deletion marker, so that history is retained for
+	// the configured time after it was replaced.
+	compactCandidatesSQL = `
+		SELECT kp.prev_revision AS id
+		FROM kine AS kp
+		WHERE
+			kp.name != 'compact_rev_key' AND
+			kp.prev_revision != 0 AND
+			kp.id <= ?
+			%[1]s
+		UNION
+		SELECT kd.id AS id
+		FROM kine AS kd
+		WHERE
+			kd.deleted != 0 AND
+			kd.id <= ?
+			%[2]s`
+
+	// compactReportSQL counts the rows that would be removed by compaction, grouped by the
+	// index of the matching retention rule, or -1 for rows not matched by any rule.
+	compactReportSQL = `
+		SELECT r.rule, COUNT(*)
+		FROM (
+			SELECT %s AS rule
+			FROM kine AS kv
+			WHERE kv.id IN (%s)
+		) AS r
+		GROUP BY r.rule`
+)
+
+// RetentionRule retains the history of keys with the given prefix until MaxAge after it was
+// replaced or deleted, even if it has fallen behind the compact revision. The compact revision
+// is not held back by retained rows, so they can only be read through the history API; range
+// and watch requests at revisions before the compact revision still return ErrCompacted.
+type RetentionRule struct {
+	Prefix string
+	MaxAge time.Duration
+}
+
+// RetentionConfig configures the retention rules applied when compacting. Keys are matched
+// against the rule with the longest prefix; the history of keys that do not match any rule is
+// removed as soon as it falls behind the compact revision. If DryRun is set, compaction only
+// reports the number of rows each rule would remove.
+type RetentionConfig struct {
+	Rules  []RetentionRule
+	DryRun bool
+}
+
+// ParseRetentionRules parses retention rules in the form prefix=duration.
+func ParseRetentionRules(rules []string) ([]RetentionRule, error) {
+	var result []RetentionRule
+	for _, rule := range rules {
+		i := strings.LastIndex(rule, "=")
+		if i < 0 {
+			return nil, errors.Errorf("invalid retention rule %q: expected prefix=duration", rule)
+		}
+		maxAge, err := time.ParseDuration(rule[i+1:])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid retention rule %q", rule)
+		}
+		result = append(result, RetentionRule{Prefix: rule[:i], MaxAge: maxAge})
+	}
+	return result, nil
+}
+
+// ConfigureRetention sets the retention rules applied when compacting, and renders the
+// compaction statements. It must be called after CompactSQL is set.
+func (d *Generic) ConfigureRetention(config RetentionConfig) error {
+	rules := append([]RetentionRule{}, config.Rules...)
+	prefixes := map[string]bool{}
+	for _, rule := range rules {
+		if rule.Prefix == "" {
+			return errors.New("retention rule prefix must not be empty")
+		}
+		if rule.MaxAge < 0 {
+			return errors.Errorf("retention rule for %q has negative age", rule.Prefix)
+		}
+		if prefixes[rule.Prefix] {
+			return errors.Errorf("duplicate retention rule for %q", rule.Prefix)
+		}
+		prefixes[rule.Prefix] = true
+	}
+	// the first matching rule is applied, so more specific prefixes must come first
+	sort.SliceStable(rules, func(i, j int) bool {
+		return len(rules[i].Prefix) > len(rules[j].Prefix)
+	})
+
+	candidates := fmt.Sprintf(compactCandidatesSQL, retentionCondition("kp", rules), retentionCondition("kd", rules))
+	d.retentionRules = rules
+	d.RetentionDryRun = config.DryRun
+	d.compactSQL = q(TableSQL(d.Table, fmt.Sprintf(d.CompactSQL, candidates)), d.paramCharacter, d.numbered)
+	d.compactReportSQL = q(TableSQL(d.Table, fmt.Sprintf(compactReportSQL, ruleIndex("kv", rules), candidates)), d.paramCharacter, d.numbered)
+	return nil
+}
+
+// CompactDryRun returns true if compaction should only report the rows it would remove.
+func (d *Generic) CompactDryRun() bool {
+	return d.RetentionDryRun
+}
+
+// CompactReport returns the number of rows that would be removed by compacting to the
+// revision, keyed by the prefix of the matching retention rule. Rows not matched by any
+// rule are counted under the empty prefix. All rules are included, even if no rows match.
+func (d *Generic) CompactReport(ctx context.Context, revision int64) (map[string]int64, error) {
+	var args []interface{}
+	for _, rule := range d.retentionRules {
+		args = append(args, rule.Prefix+"%")
+	}
+	args = append(args, d.compactArgs(revision)...)
+
+	rows, err := d.query(ctx, d.compactReportSQL, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report := map[string]int64{"": 0}
+	for _, rule := range d.retentionRules {
+		report[rule.Prefix] = 0
+	}
+	for rows.Next() {
+		var rule, count int64
+		if err := rows.Scan(&rule, &count); err != nil {
+			return nil, err
+		}
+		prefix := ""
+		if rule >= 0 && rule < int64(len(d.retentionRules)) {
+			prefix = d.retentionRules[rule].Prefix
+		}
+		report[prefix] += count
+	}
+	return report, rows.Err()
+}
+
+// compactArgs returns the arguments for the compaction statement. The retention cutoff
+// for each rule is calculated from the current time.
+func (d *Generic) compactArgs(revision int64) []interface{} {
+	now := time.Now()
+	var ruleArgs []interface{}
+	for _, rule := range d.retentionRules {
+		ruleArgs = append(ruleArgs, rule.Prefix+"%", now.Add(-rule.MaxAge).Unix())
+	}
+	args := append([]interface{}{revision}, ruleArgs...)
+	args = append(args, revision)
+	return append(args, ruleArgs...)
+}
+
+// retentionCondition returns a condition that matches rows old enough to be removed under
+// the first rule matching their key. Rows without a creation time are treated as expired.
+func retentionCondition(alias string, rules []RetentionRule) string {
+	if len(rules) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("AND CASE")
+	for range rules {
+		fmt.Fprintf(&b, " WHEN %[1]s.name LIKE ? THEN COALESCE(%[1]s.created_at, 0) <= ?", alias)
+	}
+	b.WriteString(" ELSE TRUE END")
+	return b.String()
+}
+
+// ruleIndex returns an expression selecting the index of the first rule matching the key.
+func ruleIndex(alias string, rules []RetentionRule) string {
+	if len(rules) == 0 {
+		return "-1"
+	}
+	var b strings.Builder
+	b.WriteString("CASE")
+	for i := range rules {
+		fmt.Fprintf(&b, " WHEN %s.name LIKE ? THEN %d", alias, i)
+	}
+	b.WriteString(" ELSE -1 END")
+	return b.String()
+}
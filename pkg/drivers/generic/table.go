@@ -0,0 +1,78 @@
+package generic
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultTable is the name of the table used to store the log, unless set by the table
+	// parameter in the data source name.
+	DefaultTable = "kine"
+
+	tableParam = "table"
+)
+
+var (
+	// table names are restricted to lower-case identifiers that do not need to be quoted, and
+	// are short enough that index names derived from them fit in the identifier length limits.
+	tableNameRegexp = regexp.MustCompile(`^[a-z_][a-z0-9_]{0,31}$`)
+
+	// defaultTableRegexp matches references to the default table, along with the names of indexes,
+	// sequences and other objects that are named after it.
+	defaultTableRegexp = regexp.MustCompile(`\b` + DefaultTable + `(_[a-z_]+)?\b`)
+)
+
+// ParseTable removes the table parameter from the query string of the data source name, and returns
+// the remaining data source name along with the table name. The default table name is returned if
+// the parameter is not set.
+func ParseTable(dataSourceName string) (string, string, error) {
+	base, query, ok := strings.Cut(dataSourceName, "?")
+	if !ok {
+		return dataSourceName, DefaultTable, nil
+	}
+
+	table := DefaultTable
+	var params []string
+	for _, param := range strings.Split(query, "&") {
+		name, value, _ := strings.Cut(param, "=")
+		if name != tableParam {
+			params = append(params, param)
+			continue
+		}
+		value, err := url.QueryUnescape(value)
+		if err != nil {
+			return "", "", errors.Wrap(err, "parsing table name")
+		}
+		table = value
+	}
+
+	if err := ValidateIdentifier(table); err != nil {
+		return "", "", err
+	}
+
+	if len(params) == 0 {
+		return base, table, nil
+	}
+	return base + "?" + strings.Join(params, "&"), table, nil
+}
+
+// ValidateIdentifier returns an error if the name cannot be used as a table or schema name.
+func ValidateIdentifier(name string) error {
+	if !tableNameRegexp.MatchString(name) {
+		return errors.Errorf("invalid name %q: must be at most 32 lower-case letters, digits and underscores, and not start with a digit", name)
+	}
+	return nil
+}
+
+// TableSQL returns the statement with references to the default table, and to objects named after it
+// such as indexes and sequences, replaced by the given table name.
+func TableSQL(table, sql string) string {
+	if table == DefaultTable || table == "" {
+		return sql
+	}
+	return defaultTableRegexp.ReplaceAllString(sql, table+"$1")
+}
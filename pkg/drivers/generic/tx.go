@@ -57,7 +57,7 @@ func (t *Tx) MustRollback() {
 
 func (t *Tx) GetCompactRevision(ctx context.Context) (int64, error) {
 	var id int64
-	row := t.queryRow(ctx, compactRevSQL)
+	row := t.queryRow(ctx, t.d.CompactRevisionSQL)
 	err := row.Scan(&id)
 	if err == sql.ErrNoRows {
 		return 0, nil
@@ -73,7 +73,7 @@ func (t *Tx) SetCompactRevision(ctx context.Context, revision int64) error {
 
 func (t *Tx) Compact(ctx context.Context, revision int64) (int64, error) {
 	logrus.Tracef("TX COMPACT %v", revision)
-	res, err := t.execute(ctx, t.d.CompactSQL, revision, revision)
+	res, err := t.execute(ctx, t.d.compactSQL, t.d.compactArgs(revision)...)
 	if err != nil {
 		return 0, err
 	}
@@ -92,7 +92,7 @@ func (t *Tx) DeleteRevision(ctx context.Context, revision int64) error {
 
 func (t *Tx) CurrentRevision(ctx context.Context) (int64, error) {
 	var id int64
-	row := t.queryRow(ctx, revSQL)
+	row := t.queryRow(ctx, t.d.RevisionSQL)
 	err := row.Scan(&id)
 	if err == sql.ErrNoRows {
 		return 0, nil
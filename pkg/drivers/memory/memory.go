@@ -0,0 +1,584 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/k3s-io/kine/pkg/drivers"
+	"github.com/k3s-io/kine/pkg/logstructured"
+	"github.com/k3s-io/kine/pkg/server"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/tidwall/btree"
+)
+
+// explicit interface check
+var _ logstructured.Log = (*MemoryLog)(nil)
+
+// MemoryLog is an in-memory implementation of logstructured.Log. It retains the full
+// revision history of every key until it is compacted. If a snapshot file is configured,
+// the log is loaded from the file at startup and saved back to it when closed.
+type MemoryLog struct {
+	sync.RWMutex
+
+	wg               sync.WaitGroup
+	cancel           context.CancelFunc
+	snapshotFile     string
+	compactInterval  time.Duration
+	compactMinRetain int64
+	currentRev       int64
+	compactRev       int64
+	events           []*server.Event
+	created          map[int64]time.Time
+	keys             *btree.Map[string, []*server.Event]
+	watchers         map[*watcher]struct{}
+}
+
+type watcher struct {
+	prefix      string
+	checkPrefix bool
+	ch          chan []*server.Event
+}
+
+// snapshot is the on-disk format of the log.
+type snapshot struct {
+	CurrentRevision int64               `json:"currentRevision"`
+	CompactRevision int64               `json:"compactRevision"`
+	Events          []*server.Event     `json:"events"`
+	Created         map[int64]time.Time `json:"created,omitempty"`
+}
+
+// New returns a log structured backend that keeps all data in memory. If the
+// data source name is not empty, it is used as the path to a snapshot file.
+func New(ctx context.Context, cfg *drivers.Config) (bool, server.Backend, error) {
+	log, err := NewMemoryLog(cfg.DataSourceName, cfg.CompactInterval, cfg.CompactMinRetain)
+	if err != nil {
+		return false, nil, err
+	}
+	return false, logstructured.New(log), nil
+}
+
+// NewMemoryLog returns a new in-memory log, loading any existing content from the snapshot file.
+func NewMemoryLog(snapshotFile string, compactInterval time.Duration, compactMinRetain int64) (*MemoryLog, error) {
+	m := &MemoryLog{
+		snapshotFile:     snapshotFile,
+		compactInterval:  compactInterval,
+		compactMinRetain: compactMinRetain,
+		created:          map[int64]time.Time{},
+		keys:             btree.NewMap[string, []*server.Event](0),
+		watchers:         map[*watcher]struct{}{},
+	}
+	if err := m.load(); err != nil {
+		return nil, errors.Wrap(err, "loading memory snapshot")
+	}
+	return m, nil
+}
+
+func (m *MemoryLog) Start(ctx context.Context) error {
+	ctx, m.cancel = context.WithCancel(ctx)
+	if m.compactInterval > 0 {
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			m.compactor(ctx)
+		}()
+	}
+	return nil
+}
+
+// Close stops the compactor, closes all watches, and saves a snapshot if a snapshot file is configured.
+func (m *MemoryLog) Close() error {
+	if m.cancel != nil {
+		m.cancel()
+	}
+	m.wg.Wait()
+
+	m.Lock()
+	for w := range m.watchers {
+		m.unwatch(w)
+	}
+	m.Unlock()
+
+	return m.save()
+}
+
+// compactor periodically compacts the log, retaining at least compactMinRetain revisions.
+func (m *MemoryLog) compactor(ctx context.Context) {
+	t := time.NewTicker(m.compactInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		m.RLock()
+		target := m.currentRev - m.compactMinRetain
+		compactRev := m.compactRev
+		m.RUnlock()
+
+		if target <= compactRev {
+			continue
+		}
+		if _, err := m.Compact(ctx, target); err != nil {
+			logrus.Errorf("Compact failed: %v", err)
+		}
+	}
+}
+
+func (m *MemoryLog) CompactRevision(ctx context.Context) (int64, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.compactRev, nil
+}
+
+func (m *MemoryLog) CurrentRevision(ctx context.Context) (int64, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.currentRev, nil
+}
+
+func (m *MemoryLog) List(ctx context.Context, prefix, startKey string, limit, revision int64, includeDeletes bool) (int64, []*server.Event, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	if revision > m.currentRev {
+		return m.currentRev, nil, server.ErrFutureRev
+	}
+	if revision > 0 && revision < m.compactRev {
+		return m.currentRev, nil, server.ErrCompacted
+	}
+
+	var result []*server.Event
+	m.iterate(prefix, startKey, revision, includeDeletes, func(event *server.Event) bool {
+		result = append(result, event)
+		return limit <= 0 || int64(len(result)) < limit
+	})
+	return m.currentRev, result, nil
+}
+
+func (m *MemoryLog) Count(ctx context.Context, prefix, startKey string, revision int64) (int64, int64, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	if revision > m.currentRev {
+		return m.currentRev, 0, server.ErrFutureRev
+	}
+	if revision > 0 && revision < m.compactRev {
+		return m.currentRev, 0, server.ErrCompacted
+	}
+
+	var count int64
+	m.iterate(prefix, startKey, revision, false, func(*server.Event) bool {
+		count++
+		return true
+	})
+	return m.currentRev, count, nil
+}
+
+// iterate calls f with the latest event at the requested revision for each key
+// matching the prefix, in key order, until f returns false. An empty prefix matches all
+// keys. If the prefix does not end with a "/" only the exact key is matched, and the start
+// key is ignored. The caller must hold the lock.
+func (m *MemoryLog) iterate(prefix, startKey string, revision int64, includeDeletes bool, f func(*server.Event) bool) {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		if event := latest(m.keys, prefix, revision); event != nil && (includeDeletes || !event.Delete) {
+			f(event)
+		}
+		return
+	}
+
+	if startKey == prefix {
+		startKey = ""
+	}
+
+	m.keys.Ascend(prefix, func(key string, history []*server.Event) bool {
+		if !strings.HasPrefix(key, prefix) {
+			return false
+		}
+		if key <= startKey {
+			return true
+		}
+		event := latestInHistory(history, revision)
+		if event == nil || (event.Delete && !includeDeletes) {
+			return true
+		}
+		return f(event)
+	})
+}
+
+func (m *MemoryLog) After(ctx context.Context, prefix string, revision, limit int64) (int64, []*server.Event, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	if revision > 0 && revision < m.compactRev {
+		return m.currentRev, nil, server.ErrCompacted
+	}
+
+	checkPrefix := strings.HasSuffix(prefix, "/")
+	i := sort.Search(len(m.events), func(i int) bool {
+		return m.events[i].KV.ModRevision > revision
+	})
+
+	var result []*server.Event
+	for _, event := range m.events[i:] {
+		if limit > 0 && int64(len(result)) >= limit {
+			break
+		}
+		if matches(event, checkPrefix, prefix) {
+			result = append(result, event)
+		}
+	}
+	return m.currentRev, result, nil
+}
+
+func (m *MemoryLog) Watch(ctx context.Context, prefix string) <-chan []*server.Event {
+	w := &watcher{
+		prefix:      prefix,
+		checkPrefix: strings.HasSuffix(prefix, "/"),
+		ch:          make(chan []*server.Event, 100),
+	}
+
+	m.Lock()
+	m.watchers[w] = struct{}{}
+	m.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		m.Lock()
+		m.unwatch(w)
+		m.Unlock()
+	}()
+
+	return w.ch
+}
+
+// unwatch closes the watcher's channel, if it has not already been closed.
+// The caller must hold the lock.
+func (m *MemoryLog) unwatch(w *watcher) {
+	if _, ok := m.watchers[w]; ok {
+		close(w.ch)
+		delete(m.watchers, w)
+	}
+}
+
+// Append adds an event to the log. The previous revision of the event must match the
+// latest revision of the key, with the same semantics as the unique (name, prev_revision)
+// index used by the SQL drivers.
+func (m *MemoryLog) Append(ctx context.Context, event *server.Event) (int64, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	e := &server.Event{
+		Create: event.Create,
+		Delete: event.Delete,
+	}
+	if event.KV != nil {
+		kv := *event.KV
+		e.KV = &kv
+	} else {
+		e.KV = &server.KeyValue{}
+	}
+
+	prev := latest(m.keys, e.KV.Key, 0)
+	switch {
+	case prev == nil:
+		if !e.Create {
+			return 0, server.ErrKeyExists
+		}
+	case prev.Delete:
+		if !e.Create || event.PrevKV == nil || event.PrevKV.ModRevision != prev.KV.ModRevision {
+			return 0, server.ErrKeyExists
+		}
+	default:
+		if e.Create || event.PrevKV == nil || event.PrevKV.ModRevision != prev.KV.ModRevision {
+			return 0, server.ErrKeyExists
+		}
+		e.PrevKV = prev.KV
+	}
+
+	m.currentRev++
+	e.KV.ModRevision = m.currentRev
+	if e.Create {
+		e.KV.CreateRevision = m.currentRev
+	}
+
+	m.events = append(m.events, e)
+	m.created[m.currentRev] = time.Now()
+	history, _ := m.keys.Get(e.KV.Key)
+	m.keys.Set(e.KV.Key, append(history, e))
+
+	for w := range m.watchers {
+		if !matches(e, w.checkPrefix, w.prefix) {
+			continue
+		}
+		select {
+		case w.ch <- []*server.Event{e}:
+		default:
+			// Slow consumer, drop
+			m.unwatch(w)
+		}
+	}
+
+	return m.currentRev, nil
+}
+
+// RevisionAt returns the latest revision created at or before the given time. Events loaded
+// from a snapshot without creation times are treated as created before any other.
+func (m *MemoryLog) RevisionAt(ctx context.Context, t time.Time) (int64, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	i := sort.Search(len(m.events), func(i int) bool {
+		return m.created[m.events[i].KV.ModRevision].After(t)
+	})
+	var rev int64
+	if i > 0 {
+		rev = m.events[i-1].KV.ModRevision
+	}
+	// events created at or before the time may have been compacted
+	if rev < m.compactRev {
+		return 0, server.ErrCompacted
+	}
+	return rev, nil
+}
+
+// History returns the retained revisions of the key, or of all keys with the key as a prefix,
+// between the start and end revisions inclusive.
+func (m *MemoryLog) History(ctx context.Context, key string, prefix bool, startRevision, endRevision, limit int64) (int64, []*server.Event, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	if endRevision <= 0 {
+		endRevision = m.currentRev
+	}
+
+	var result []*server.Event
+	add := func(history []*server.Event) {
+		i := sort.Search(len(history), func(i int) bool {
+			return history[i].KV.ModRevision >= startRevision
+		})
+		for _, event := range history[i:] {
+			if event.KV.ModRevision > endRevision {
+				break
+			}
+			result = append(result, event)
+		}
+	}
+
+	if prefix {
+		m.keys.Ascend(key, func(k string, history []*server.Event) bool {
+			if !strings.HasPrefix(k, key) {
+				return false
+			}
+			add(history)
+			return true
+		})
+		sort.Slice(result, func(i, j int) bool {
+			return result[i].KV.ModRevision < result[j].KV.ModRevision
+		})
+	} else if history, ok := m.keys.Get(key); ok {
+		add(history)
+	}
+
+	if limit > 0 && int64(len(result)) > limit {
+		result = result[:limit]
+	}
+	return m.currentRev, result, nil
+}
+
+// DbSize returns the approximate size of all retained keys and values.
+func (m *MemoryLog) DbSize(ctx context.Context) (int64, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	var size int64
+	for _, event := range m.events {
+		size += int64(len(event.KV.Key) + len(event.KV.Value))
+	}
+	return size, nil
+}
+
+// Compact removes all events at or below the requested revision that have been
+// superseded by a later event for the same key, along with any deletions.
+func (m *MemoryLog) Compact(ctx context.Context, revision int64) (int64, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	if revision > m.currentRev {
+		return m.currentRev, server.ErrFutureRev
+	}
+	if revision <= m.compactRev {
+		return m.currentRev, server.ErrCompacted
+	}
+
+	start := time.Now()
+	var deleted int
+	compacted := map[string][]*server.Event{}
+	m.keys.Scan(func(key string, history []*server.Event) bool {
+		// find the latest event at or below the compact revision; everything before it has been superseded
+		i := sort.Search(len(history), func(i int) bool {
+			return history[i].KV.ModRevision > revision
+		}) - 1
+		if i >= 0 && !history[i].Delete {
+			i--
+		}
+		if i >= 0 {
+			deleted += i + 1
+			compacted[key] = history[i+1:]
+		}
+		return true
+	})
+	for key, history := range compacted {
+		if len(history) == 0 {
+			m.keys.Delete(key)
+		} else {
+			m.keys.Set(key, history)
+		}
+	}
+
+	events := make([]*server.Event, 0, len(m.events)-deleted)
+	m.keys.Scan(func(_ string, history []*server.Event) bool {
+		events = append(events, history...)
+		return true
+	})
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].KV.ModRevision < events[j].KV.ModRevision
+	})
+	created := make(map[int64]time.Time, len(events))
+	for _, event := range events {
+		created[event.KV.ModRevision] = m.created[event.KV.ModRevision]
+	}
+	m.events = events
+	m.created = created
+	m.compactRev = revision
+
+	logrus.Infof("COMPACT deleted %d events in %s - compacted to %d/%d", deleted, time.Since(start), revision, m.currentRev)
+	return m.currentRev, nil
+}
+
+func (m *MemoryLog) load() error {
+	if m.snapshotFile == "" {
+		return nil
+	}
+
+	f, err := os.Open(m.snapshotFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	var s snapshot
+	if err := json.NewDecoder(f).Decode(&s); err != nil {
+		return err
+	}
+
+	m.currentRev = s.CurrentRevision
+	m.compactRev = s.CompactRevision
+	m.events = s.Events
+	if s.Created != nil {
+		m.created = s.Created
+	}
+	for _, event := range m.events {
+		history, _ := m.keys.Get(event.KV.Key)
+		if len(history) > 0 && !event.Create {
+			event.PrevKV = history[len(history)-1].KV
+		}
+		m.keys.Set(event.KV.Key, append(history, event))
+	}
+
+	logrus.Infof("Loaded %d events from %s at revision %d", len(m.events), m.snapshotFile, m.currentRev)
+	return nil
+}
+
+// save writes the log to the snapshot file. The file is written to a temporary
+// file in the same directory and renamed into place, so that an interrupted save
+// does not corrupt an existing snapshot.
+func (m *MemoryLog) save() error {
+	if m.snapshotFile == "" {
+		return nil
+	}
+
+	m.RLock()
+	defer m.RUnlock()
+
+	s := snapshot{
+		CurrentRevision: m.currentRev,
+		CompactRevision: m.compactRev,
+		Events:          make([]*server.Event, 0, len(m.events)),
+		Created:         m.created,
+	}
+	// previous values are restored from the key history on load
+	for _, event := range m.events {
+		s.Events = append(s.Events, &server.Event{
+			Create: event.Create,
+			Delete: event.Delete,
+			KV:     event.KV,
+		})
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(m.snapshotFile), filepath.Base(m.snapshotFile)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := json.NewEncoder(f).Encode(&s); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), m.snapshotFile); err != nil {
+		return err
+	}
+
+	logrus.Infof("Saved %d events to %s at revision %d", len(s.Events), m.snapshotFile, s.CurrentRevision)
+	return nil
+}
+
+// latest returns the latest event for the key at the requested revision, or nil if there is none.
+// A zero revision returns the most recent event.
+func latest(keys *btree.Map[string, []*server.Event], key string, revision int64) *server.Event {
+	history, ok := keys.Get(key)
+	if !ok {
+		return nil
+	}
+	return latestInHistory(history, revision)
+}
+
+func latestInHistory(history []*server.Event, revision int64) *server.Event {
+	if revision <= 0 {
+		if len(history) == 0 {
+			return nil
+		}
+		return history[len(history)-1]
+	}
+	i := sort.Search(len(history), func(i int) bool {
+		return history[i].KV.ModRevision > revision
+	})
+	if i == 0 {
+		return nil
+	}
+	return history[i-1]
+}
+
+func matches(event *server.Event, checkPrefix bool, prefix string) bool {
+	return (checkPrefix && strings.HasPrefix(event.KV.Key, prefix)) || event.KV.Key == prefix
+}
+
+func init() {
+	drivers.Register("memory", New)
+}
@@ -0,0 +1,172 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/k3s-io/kine/pkg/logstructured"
+	"github.com/k3s-io/kine/pkg/server"
+	"github.com/k3s-io/kine/pkg/server/backendtest"
+)
+
+func noErr(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expEqualErr(t *testing.T, want, got error) {
+	t.Helper()
+	if !errors.Is(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func expEqual[T comparable](t *testing.T, want, got T) {
+	t.Helper()
+	if got != want {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func setupBackend(t *testing.T, snapshotFile string) (*MemoryLog, *logstructured.LogStructured) {
+	log, err := NewMemoryLog(snapshotFile, 0, 0)
+	noErr(t, err)
+
+	b := logstructured.New(log)
+	noErr(t, b.Start(context.Background()))
+	return log, b
+}
+
+func TestBackend(t *testing.T) {
+	backendtest.Run(t, func(t *testing.T) server.Backend {
+		_, b := setupBackend(t, "")
+		t.Cleanup(func() { b.Close() })
+		return b
+	})
+}
+
+func TestBackend_Compact(t *testing.T) {
+	log, b := setupBackend(t, "")
+	defer b.Close()
+
+	ctx := context.Background()
+
+	_, err := b.Create(ctx, "/a", []byte("1"), 0)
+	noErr(t, err)
+	_, _, _, err = b.Update(ctx, "/a", []byte("2"), 2, 0)
+	noErr(t, err)
+	_, err = b.Create(ctx, "/b", []byte("1"), 0)
+	noErr(t, err)
+	_, _, _, err = b.Delete(ctx, "/b", 4)
+	noErr(t, err)
+
+	_, err = b.Compact(ctx, 5)
+	noErr(t, err)
+	expEqual(t, 2, len(log.events))
+
+	_, _, err = b.List(ctx, "/", "", 0, 3)
+	expEqualErr(t, server.ErrCompacted, err)
+
+	_, kv, err := b.Get(ctx, "/a", "", 1, 0)
+	noErr(t, err)
+	expEqual(t, "2", string(kv.Value))
+}
+
+func TestBackend_RevisionAt(t *testing.T) {
+	_, b := setupBackend(t, "")
+	defer b.Close()
+
+	ctx := context.Background()
+
+	// revision 1 is the health check key created at startup
+	before := time.Now()
+	_, err := b.Create(ctx, "/a", []byte("1"), 0)
+	noErr(t, err)
+	between := time.Now()
+	_, _, _, err = b.Update(ctx, "/a", []byte("2"), 2, 0)
+	noErr(t, err)
+
+	rev, err := b.RevisionAt(ctx, before)
+	noErr(t, err)
+	expEqual(t, 1, rev)
+	rev, err = b.RevisionAt(ctx, between)
+	noErr(t, err)
+	expEqual(t, 2, rev)
+	rev, err = b.RevisionAt(ctx, time.Now())
+	noErr(t, err)
+	expEqual(t, 3, rev)
+	rev, err = b.RevisionAt(ctx, before.Add(-time.Hour))
+	noErr(t, err)
+	expEqual(t, 0, rev)
+
+	_, err = b.Compact(ctx, 3)
+	noErr(t, err)
+	_, err = b.RevisionAt(ctx, between)
+	expEqualErr(t, server.ErrCompacted, err)
+}
+
+func TestBackend_History(t *testing.T) {
+	_, b := setupBackend(t, "")
+	defer b.Close()
+
+	ctx := context.Background()
+
+	// revision 1 is the health check key created at startup
+	_, err := b.Create(ctx, "/a/a", []byte("1"), 0)
+	noErr(t, err)
+	_, err = b.Create(ctx, "/a/b", []byte("1"), 0)
+	noErr(t, err)
+	_, _, _, err = b.Update(ctx, "/a/a", []byte("2"), 2, 0)
+	noErr(t, err)
+	_, _, _, err = b.Delete(ctx, "/a/a", 4)
+	noErr(t, err)
+
+	rev, events, err := b.History(ctx, "/a/a", false, 0, 0, 0)
+	noErr(t, err)
+	expEqual(t, 5, rev)
+	expEqual(t, 3, len(events))
+	expEqual(t, true, events[0].Create)
+	expEqual(t, "2", string(events[1].KV.Value))
+	expEqual(t, true, events[2].Delete)
+
+	_, events, err = b.History(ctx, "/a/", true, 3, 4, 0)
+	noErr(t, err)
+	expEqual(t, 2, len(events))
+	expEqual(t, "/a/b", events[0].KV.Key)
+	expEqual(t, "/a/a", events[1].KV.Key)
+
+	_, events, err = b.History(ctx, "/a/", true, 0, 0, 1)
+	noErr(t, err)
+	expEqual(t, 1, len(events))
+	expEqual(t, int64(2), events[0].KV.ModRevision)
+}
+
+func TestBackend_Snapshot(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "snapshot.json")
+	_, b := setupBackend(t, file)
+
+	ctx := context.Background()
+
+	_, err := b.Create(ctx, "/a", []byte("1"), 0)
+	noErr(t, err)
+	_, _, _, err = b.Update(ctx, "/a", []byte("2"), 2, 0)
+	noErr(t, err)
+	noErr(t, b.Close())
+
+	_, b = setupBackend(t, file)
+	defer b.Close()
+
+	rev, kv, err := b.Get(ctx, "/a", "", 1, 0)
+	noErr(t, err)
+	expEqual(t, 3, rev)
+	expEqual(t, "2", string(kv.Value))
+
+	_, kv, err = b.Get(ctx, "/a", "", 1, 2)
+	noErr(t, err)
+	expEqual(t, "1", string(kv.Value))
+}
@@ -0,0 +1,207 @@
+package mysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"net"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/k3s-io/kine/pkg/drivers/generic"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// addrsRegexp matches the comma-separated list of addresses in a tcp data source name.
+var addrsRegexp = regexp.MustCompile(`(?:^|@)tcp\(([^)]*)\)`)
+
+// parseDSN parses a data source name that may list several tcp hosts, and returns the
+// configuration for the first host along with the addresses of all hosts.
+func parseDSN(dataSourceName string) (*mysql.Config, []string, error) {
+	var addrs []string
+	if m := addrsRegexp.FindStringSubmatchIndex(dataSourceName); m != nil {
+		addrs = strings.Split(dataSourceName[m[2]:m[3]], ",")
+		dataSourceName = dataSourceName[:m[2]] + addrs[0] + dataSourceName[m[3]:]
+	}
+
+	config, err := mysql.ParseDSN(dataSourceName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(addrs) < 2 {
+		return config, []string{config.Addr}, nil
+	}
+
+	addrs[0] = config.Addr
+	for i, addr := range addrs[1:] {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addrs[i+1] = net.JoinHostPort(addr, "3306")
+		}
+	}
+	return config, addrs, nil
+}
+
+// formatDSN formats the configuration as a data source name listing all the addresses.
+func formatDSN(config *mysql.Config, addrs []string) string {
+	config = config.Clone()
+	config.Addr = addrs[0]
+	dataSourceName := config.FormatDSN()
+	if len(addrs) < 2 {
+		return dataSourceName
+	}
+	return strings.Replace(dataSourceName, "tcp("+addrs[0]+")", "tcp("+strings.Join(addrs, ",")+")", 1)
+}
+
+// newConnector returns a connector for the data source name. Data source names that list several
+// hosts, such as user:pass@tcp(host1:3306,host2:3306)/dbname, connect to the host that accepts
+// writes. The username and password are read from the credential files, if any, for each
+// connection.
+func newConnector(dataSourceName string, credentials generic.Credentials) (driver.Connector, error) {
+	_, addrs, err := parseDSN(dataSourceName)
+	if err != nil {
+		return nil, err
+	}
+	return generic.NewConnector(sqlDriver(addrs), dataSourceName, credentials, setCredentials)
+}
+
+// sqlDriver returns the driver to use for the addresses.
+func sqlDriver(addrs []string) driver.Driver {
+	if len(addrs) > 1 {
+		return failoverDriver{}
+	}
+	return &mysql.MySQLDriver{}
+}
+
+// setCredentials returns the data source name with the username and password set, if not empty.
+func setCredentials(dataSourceName, username, password string) (string, error) {
+	config, addrs, err := parseDSN(dataSourceName)
+	if err != nil {
+		return "", err
+	}
+	if username != "" {
+		config.User = username
+	}
+	if password != "" {
+		config.Passwd = password
+	}
+	return formatDSN(config, addrs), nil
+}
+
+// failoverDriver opens connections to the first of several hosts that accepts writes.
+type failoverDriver struct{}
+
+func (d failoverDriver) Open(dataSourceName string) (driver.Conn, error) {
+	connector, err := d.OpenConnector(dataSourceName)
+	if err != nil {
+		return nil, err
+	}
+	return connector.Connect(context.Background())
+}
+
+func (failoverDriver) OpenConnector(dataSourceName string) (driver.Connector, error) {
+	config, addrs, err := parseDSN(dataSourceName)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &failoverConnector{addrs: addrs}
+	for _, addr := range addrs {
+		hostConfig := config.Clone()
+		hostConfig.Addr = addr
+		connector, err := mysql.NewConnector(hostConfig)
+		if err != nil {
+			return nil, err
+		}
+		c.connectors = append(c.connectors, connector)
+	}
+	return c, nil
+}
+
+// failoverConnector connects to the first host that accepts writes, starting with the host that
+// last did. Hosts are expected to be read-only while they are replicas.
+type failoverConnector struct {
+	addrs      []string
+	connectors []driver.Connector
+	current    atomic.Int64
+}
+
+func (c *failoverConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	current := int(c.current.Load())
+	var lastErr error
+	for i := range c.connectors {
+		n := (current + i) % len(c.connectors)
+		conn, err := c.connect(ctx, n)
+		if err != nil {
+			logrus.Debugf("Failed to connect to MySQL host %s: %v", c.addrs[n], err)
+			lastErr = errors.Wrapf(err, "connecting to %s", c.addrs[n])
+			continue
+		}
+		if n != current {
+			c.current.Store(int64(n))
+			logrus.Infof("Connected to MySQL writer at %s", c.addrs[n])
+		}
+		return conn, nil
+	}
+	return nil, lastErr
+}
+
+// connect opens a connection to the host, and returns an error if it is read-only.
+func (c *failoverConnector) connect(ctx context.Context, n int) (driver.Conn, error) {
+	conn, err := c.connectors[n].Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	readOnly, err := isReadOnly(ctx, conn)
+	if err == nil && readOnly {
+		err = errors.New("host is read-only")
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (c *failoverConnector) Driver() driver.Driver {
+	return failoverDriver{}
+}
+
+// isReadOnly returns true if the server connected to does not accept writes.
+func isReadOnly(ctx context.Context, conn driver.Conn) (bool, error) {
+	queryer, ok := conn.(driver.QueryerContext)
+	if !ok {
+		return false, nil
+	}
+	rows, err := queryer.QueryContext(ctx, "SELECT @@global.read_only", nil)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	values := make([]driver.Value, 1)
+	if err := rows.Next(values); err != nil {
+		return false, err
+	}
+	switch v := values[0].(type) {
+	case int64:
+		return v != 0, nil
+	case []byte:
+		return string(v) != "0", nil
+	}
+	return false, nil
+}
+
+// isReadOnlyErr returns true if the error is returned for writes to a read-only server.
+func isReadOnlyErr(err error) bool {
+	if err, ok := err.(*mysql.MySQLError); ok {
+		switch err.Number {
+		case 1290, 1792, 1836:
+			// ER_OPTION_PREVENTS_STATEMENT, returned with --read-only or --super-read-only;
+			// ER_CANT_EXECUTE_IN_READ_ONLY_TRANSACTION; ER_READ_ONLY_MODE
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package mysql
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/k3s-io/kine/pkg/drivers/generic"
+)
+
+func TestParseDSN(t *testing.T) {
+	for _, test := range []struct {
+		dataSourceName string
+		addrs          []string
+		formatted      string
+	}{
+		{
+			dataSourceName: "root:pass@tcp(db1)/kine",
+			addrs:          []string{"db1:3306"},
+			formatted:      "root:pass@tcp(db1:3306)/kine",
+		},
+		{
+			dataSourceName: "root:pass@tcp(db1,db2:3307)/kine?timeout=5s",
+			addrs:          []string{"db1:3306", "db2:3307"},
+			formatted:      "root:pass@tcp(db1:3306,db2:3307)/kine?timeout=5s",
+		},
+		{
+			dataSourceName: "root@unix(/var/run/mysqld/mysqld.sock)/",
+			addrs:          []string{"/var/run/mysqld/mysqld.sock"},
+			formatted:      "root@unix(/var/run/mysqld/mysqld.sock)/",
+		},
+	} {
+		config, addrs, err := parseDSN(test.dataSourceName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(addrs, test.addrs) {
+			t.Errorf("expected addresses %v for %s, got %v", test.addrs, test.dataSourceName, addrs)
+		}
+		if formatted := formatDSN(config, addrs); formatted != test.formatted {
+			t.Errorf("expected %s for %s, got %s", test.formatted, test.dataSourceName, formatted)
+		}
+		if d, expected := sqlDriver(addrs), len(test.addrs) > 1; (d == failoverDriver{}) != expected {
+			t.Errorf("unexpected driver %T for %s", d, test.dataSourceName)
+		}
+	}
+}
+
+func TestSetCredentials(t *testing.T) {
+	dir := t.TempDir()
+	passwordFile := filepath.Join(dir, "password")
+	if err := os.WriteFile(passwordFile, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	dataSourceName, credentials, err := generic.ParseCredentials("kine@tcp(db1,db2)/kine?timeout=5s&passwordFile=" + url.QueryEscape(passwordFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credentials.PasswordFile != passwordFile || credentials.UsernameFile != "" {
+		t.Fatalf("unexpected credentials %+v", credentials)
+	}
+
+	dataSourceName, err = prepareDSN(dataSourceName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, password := range []string{"secret", "rotated"} {
+		if err := os.WriteFile(passwordFile, []byte(password+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		withCredentials, err := credentials.Apply(dataSourceName, setCredentials)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := "kine:" + password + "@tcp(db1:3306,db2:3306)/kine?timeout=5s"; withCredentials != expected {
+			t.Errorf("expected %s, got %s", expected, withCredentials)
+		}
+	}
+}
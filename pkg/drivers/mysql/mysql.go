@@ -5,8 +5,6 @@ import (
 	cryptotls "crypto/tls"
 	"database/sql"
 	"fmt"
-	"os"
-	"strconv"
 
 	"github.com/go-sql-driver/mysql"
 	"github.com/sirupsen/logrus"
@@ -22,6 +20,12 @@ import (
 const (
 	defaultUnixDSN = "root@unix(/var/run/mysqld/mysqld.sock)/"
 	defaultHostDSN = "root@tcp(127.0.0.1)/"
+
+	// omitOldValueVersion is the schema version from which previous values are not stored.
+	omitOldValueVersion = 5
+
+	// pollRevisionVersion is the schema version that adds the poll revision table.
+	pollRevisionVersion = 8
 )
 
 var (
@@ -37,6 +41,8 @@ var (
 				lease INTEGER,
 				value MEDIUMBLOB,
 				old_value MEDIUMBLOB,
+				compression INTEGER,
+				created_at BIGINT,
 				PRIMARY KEY (id)
 			);`,
 		`CREATE INDEX kine_name_index ON kine (name)`,
@@ -44,62 +50,184 @@ var (
 		`CREATE INDEX kine_id_deleted_index ON kine (id,deleted)`,
 		`CREATE INDEX kine_prev_revision_index ON kine (prev_revision)`,
 		`CREATE UNIQUE INDEX kine_name_prev_revision_uindex ON kine (name, prev_revision)`,
+		`CREATE TABLE IF NOT EXISTS kine_poll_revision
+			(
+				holder VARCHAR(255) CHARACTER SET ascii,
+				revision BIGINT UNSIGNED,
+				expires BIGINT,
+				PRIMARY KEY (holder)
+			)`,
 	}
-	schemaMigrations = []string{
-		`ALTER TABLE kine MODIFY COLUMN id BIGINT UNSIGNED AUTO_INCREMENT NOT NULL UNIQUE, MODIFY COLUMN create_revision BIGINT UNSIGNED, MODIFY COLUMN prev_revision BIGINT UNSIGNED`,
+	// migrations are applied in order after the initial schema, and should handle
+	// deltas between prior schema versions.
+	migrations = []generic.Migration{
+		{
+			Version:     2,
+			Description: "use 64-bit revisions",
+			Manual:      true,
+			Statements: []string{
+				`ALTER TABLE kine MODIFY COLUMN id BIGINT UNSIGNED AUTO_INCREMENT NOT NULL UNIQUE, MODIFY COLUMN create_revision BIGINT UNSIGNED, MODIFY COLUMN prev_revision BIGINT UNSIGNED`,
+			},
+		},
 		// Creating an empty migration to ensure that postgresql and mysql migrations match up
 		// with each other for a give value of KINE_SCHEMA_MIGRATION env var
-		``,
+		{
+			Version:     3,
+			Description: "no-op, matches postgres name collation",
+			Manual:      true,
+		},
+		{
+			Version:     4,
+			Description: "add value compression column",
+			Statements: []string{
+				`ALTER TABLE kine ADD COLUMN compression INTEGER`,
+			},
+		},
+		{
+			Version:     omitOldValueVersion,
+			Description: "stop storing previous values",
+			Manual:      true,
+			Statements: []string{
+				`UPDATE kine SET old_value = NULL WHERE old_value IS NOT NULL`,
+			},
+		},
+		{
+			Version:     6,
+			Description: "add row creation time column",
+			Statements: []string{
+				`ALTER TABLE kine ADD COLUMN created_at BIGINT`,
+			},
+		},
+		{
+			Version:     7,
+			Description: "no-op, matches postgres compaction lease table",
+			Manual:      true,
+		},
+		{
+			Version:     pollRevisionVersion,
+			Description: "add poll revision table",
+			Statements: []string{
+				`CREATE TABLE IF NOT EXISTS kine_poll_revision
+					(
+						holder VARCHAR(255) CHARACTER SET ascii,
+						revision BIGINT UNSIGNED,
+						expires BIGINT,
+						PRIMARY KEY (holder)
+					)`,
+			},
+		},
 	}
 	createDB = "CREATE DATABASE IF NOT EXISTS `%s`;"
 )
 
-func New(ctx context.Context, cfg *drivers.Config) (bool, server.Backend, error) {
-	tlsConfig, err := cfg.BackendTLSConfig.ClientConfig()
+func New(ctx context.Context, cfg *drivers.Config) (_ bool, _ server.Backend, err error) {
+	dialect, err := open(ctx, cfg, true)
 	if err != nil {
 		return false, nil, err
 	}
+	defer func() {
+		if err != nil {
+			dialect.Close()
+		}
+	}()
+
+	m := migrator(dialect)
+	if err := m.Up(ctx, generic.StartupVersion()); err != nil {
+		return false, nil, err
+	}
+	if dialect.OmitOldValue, err = m.Applied(ctx, omitOldValueVersion); err != nil {
+		return false, nil, err
+	}
+	if dialect.PollRevisions, err = m.Applied(ctx, pollRevisionVersion); err != nil {
+		return false, nil, err
+	}
+
+	if cfg.ReplicaDataSourceName != "" {
+		if err := openReplica(ctx, dialect, cfg); err != nil {
+			return false, nil, err
+		}
+	}
+
+	// named locks are server-wide, so the lock name includes the database name; it is hashed
+	// to stay within the 64 character limit on lock names
+	dialect.CompactElector = generic.NewLockElector(dialect.DB, dialect.Table,
+		"SELECT GET_LOCK(MD5(CONCAT(DATABASE(), '.kine_compact')), 0)",
+		"SELECT RELEASE_LOCK(MD5(CONCAT(DATABASE(), '.kine_compact')))")
+
+	dialect.Migrate(context.Background())
+	return true, logstructured.New(sqllog.New(dialect, cfg.CompactInterval, cfg.CompactIntervalJitter, cfg.CompactTimeout, cfg.CompactMinRetain, cfg.CompactBatchSize, cfg.PollBatchSize, cfg.Route)), nil
+}
+
+// NewMigrator returns a schema migrator for the database. The database is not created, so
+// that the migration status can be read without changing anything.
+func NewMigrator(ctx context.Context, cfg *drivers.Config) (*generic.Migrator, error) {
+	dialect, err := open(ctx, cfg, false)
+	if err != nil {
+		return nil, err
+	}
+	return migrator(dialect), nil
+}
+
+// open returns the dialect for the database. If create is set, the database is created if it
+// does not exist; otherwise an error is returned if it does not exist.
+func open(ctx context.Context, cfg *drivers.Config, create bool) (*generic.Generic, error) {
+	tlsConfig, err := cfg.BackendTLSConfig.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
 
 	if tlsConfig != nil {
 		tlsConfig.MinVersion = cryptotls.VersionTLS11
 	}
 
-	parsedDSN, err := prepareDSN(cfg.DataSourceName, tlsConfig)
+	dataSourceName, table, err := generic.ParseTable(cfg.DataSourceName)
 	if err != nil {
-		return false, nil, err
+		return nil, err
 	}
 
-	if err := createDBIfNotExist(parsedDSN); err != nil {
-		return false, nil, err
+	dataSourceName, credentials, err := generic.ParseCredentials(dataSourceName)
+	if err != nil {
+		return nil, err
 	}
 
-	dialect, err := generic.Open(ctx, "mysql", parsedDSN, cfg.ConnectionPoolConfig, "?", false, cfg.MetricsRegisterer)
+	parsedDSN, err := prepareDSN(dataSourceName, tlsConfig)
 	if err != nil {
-		return false, nil, err
+		return nil, err
+	}
+
+	if create {
+		err = createDBIfNotExist(parsedDSN, credentials)
+	} else {
+		err = checkDBExists(parsedDSN, credentials)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	connector, err := newConnector(parsedDSN, credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	dialect, err := generic.OpenConnector(ctx, "mysql", connector, table, cfg.ConnectionPoolConfig, "?", false, cfg.MetricsRegisterer)
+	if err != nil {
+		return nil, err
 	}
 
 	dialect.LastInsertID = true
-	dialect.GetSizeSQL = `
+	dialect.GetSizeSQL = generic.TableSQL(table, `
 		SELECT SUM(data_length + index_length)
 		FROM information_schema.TABLES
-		WHERE table_schema = DATABASE() AND table_name = 'kine'`
+		WHERE table_schema = DATABASE() AND table_name = 'kine'`)
 	dialect.CompactSQL = `
 		DELETE kv FROM kine AS kv
-		INNER JOIN (
-			SELECT kp.prev_revision AS id
-			FROM kine AS kp
-			WHERE
-				kp.name != 'compact_rev_key' AND
-				kp.prev_revision != 0 AND
-				kp.id <= ?
-			UNION
-			SELECT kd.id AS id
-			FROM kine AS kd
-			WHERE
-				kd.deleted != 0 AND
-				kd.id <= ?
-		) AS ks
+		INNER JOIN (%s) AS ks
 		ON kv.id = ks.id`
+	dialect.SetPollRevisionSQL = generic.TableSQL(table, `
+		INSERT INTO kine_poll_revision(holder, revision, expires)
+		VALUES(?, ?, ?)
+		ON DUPLICATE KEY UPDATE revision = VALUES(revision), expires = VALUES(expires)`)
+	dialect.ReadOnly = isReadOnlyErr
 	dialect.TranslateErr = func(err error) error {
 		if err, ok := err.(*mysql.MySQLError); ok && err.Number == 1062 {
 			return server.ErrKeyExists
@@ -115,67 +243,84 @@ func New(ctx context.Context, cfg *drivers.Config) (bool, server.Backend, error)
 		}
 		return err.Error()
 	}
-	if err := setup(dialect.DB); err != nil {
-		return false, nil, err
+	if err := dialect.ConfigureCompression(cfg.CompressionConfig); err != nil {
+		dialect.Close()
+		return nil, err
 	}
-
-	dialect.Migrate(context.Background())
-	return true, logstructured.New(sqllog.New(dialect, cfg.CompactInterval, cfg.CompactIntervalJitter, cfg.CompactTimeout, cfg.CompactMinRetain, cfg.CompactBatchSize, cfg.PollBatchSize)), nil
+	if err := dialect.ConfigureRetention(cfg.RetentionConfig); err != nil {
+		dialect.Close()
+		return nil, err
+	}
+	return dialect, nil
 }
 
-func setup(db *sql.DB) error {
-	logrus.Infof("Configuring database table schema and indexes, this may take a moment...")
-	var exists bool
-	err := db.QueryRow("SELECT 1 FROM information_schema.TABLES WHERE table_schema = DATABASE() AND table_name = ?", "kine").Scan(&exists)
-	if err != nil && err != sql.ErrNoRows {
-		logrus.Warnf("Failed to check existence of database table %s, going to attempt create: %v", "kine", err)
+// openReplica opens the read replica for the dialect, using the same TLS configuration as
+// the primary database.
+func openReplica(ctx context.Context, dialect *generic.Generic, cfg *drivers.Config) error {
+	tlsConfig, err := cfg.BackendTLSConfig.ClientConfig()
+	if err != nil {
+		return err
 	}
 
-	if !exists {
-		for _, stmt := range schema {
-			logrus.Tracef("SETUP EXEC : %v", util.Stripped(stmt))
-			if _, err := db.Exec(stmt); err != nil {
-				if mysqlError, ok := err.(*mysql.MySQLError); !ok || mysqlError.Number != 1061 {
-					return err
-				}
-			}
-		}
+	if tlsConfig != nil {
+		tlsConfig.MinVersion = cryptotls.VersionTLS11
 	}
 
-	// Run enabled schama migrations.
-	// Note that the schema created by the `schema` var is always the latest revision;
-	// migrations should handle deltas between prior schema versions.
-	schemaVersion, _ := strconv.ParseUint(os.Getenv("KINE_SCHEMA_MIGRATION"), 10, 64)
-	for i, stmt := range schemaMigrations {
-		if i >= int(schemaVersion) {
-			break
-		}
-		if stmt == "" {
-			continue
-		}
-		logrus.Tracef("SETUP EXEC MIGRATION %d: %v", i, util.Stripped(stmt))
-		if _, err := db.Exec(stmt); err != nil {
-			if mysqlError, ok := err.(*mysql.MySQLError); !ok || mysqlError.Number != 1061 {
-				return err
-			}
-		}
+	dataSourceName, _, err := generic.ParseTable(cfg.ReplicaDataSourceName)
+	if err != nil {
+		return err
+	}
+
+	dataSourceName, credentials, err := generic.ParseCredentials(dataSourceName)
+	if err != nil {
+		return err
+	}
+
+	parsedDSN, err := prepareDSN(dataSourceName, tlsConfig)
+	if err != nil {
+		return err
 	}
 
-	logrus.Infof("Database tables and indexes are up to date")
+	connector, err := newConnector(parsedDSN, credentials)
+	if err != nil {
+		return err
+	}
+
+	dialect.OpenReplicaConnector(ctx, "mysql", connector, cfg.ConnectionPoolConfig, cfg.MetricsRegisterer)
 	return nil
 }
 
-func createDBIfNotExist(dataSourceName string) error {
-	config, err := mysql.ParseDSN(dataSourceName)
+// migrator returns the schema migrator for the database. Migrations are run while holding
+// a named lock, so that only one kine server at a time migrates the schema.
+func migrator(dialect *generic.Generic) *generic.Migrator {
+	m := generic.NewMigrator(dialect.DB, dialect.Table, "?", false, schema, migrations)
+	m.LockSQL = "SELECT GET_LOCK('kine_schema', -1)"
+	m.UnlockSQL = "SELECT RELEASE_LOCK('kine_schema')"
+	m.IgnoreErr = func(err error) bool {
+		// indexes are created without IF NOT EXISTS, so ignore duplicate key name errors
+		// when applying the initial schema to an existing table
+		mysqlError, ok := err.(*mysql.MySQLError)
+		return ok && mysqlError.Number == 1061
+	}
+	m.TableNotExist = func(err error) bool {
+		mysqlError, ok := err.(*mysql.MySQLError)
+		return ok && mysqlError.Number == 1146
+	}
+	return m
+}
+
+func createDBIfNotExist(dataSourceName string, credentials generic.Credentials) error {
+	config, addrs, err := parseDSN(dataSourceName)
 	if err != nil {
 		return err
 	}
 	dbName := config.DBName
 
-	db, err := sql.Open("mysql", dataSourceName)
+	connector, err := newConnector(dataSourceName, credentials)
 	if err != nil {
 		return err
 	}
+	db := sql.OpenDB(connector)
 	defer db.Close()
 
 	var exists bool
@@ -192,10 +337,11 @@ func createDBIfNotExist(dataSourceName string) error {
 				return err
 			}
 			config.DBName = ""
-			db, err = sql.Open("mysql", config.FormatDSN())
+			connector, err := newConnector(formatDSN(config, addrs), credentials)
 			if err != nil {
 				return err
 			}
+			db := sql.OpenDB(connector)
 			defer db.Close()
 			if _, err = db.Exec(stmt); err != nil {
 				return err
@@ -205,6 +351,34 @@ func createDBIfNotExist(dataSourceName string) error {
 	return nil
 }
 
+// checkDBExists returns an error if the database does not exist. It is used instead of
+// createDBIfNotExist when the database must not be changed.
+func checkDBExists(dataSourceName string, credentials generic.Credentials) error {
+	config, addrs, err := parseDSN(dataSourceName)
+	if err != nil {
+		return err
+	}
+	dbName := config.DBName
+
+	// connect without selecting the database, as the connection fails if it does not exist
+	config.DBName = ""
+	connector, err := newConnector(formatDSN(config, addrs), credentials)
+	if err != nil {
+		return err
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	err = db.QueryRow("SELECT 1 FROM information_schema.SCHEMATA WHERE schema_name = ?", dbName).Scan(new(int))
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("database %s does not exist", dbName)
+	}
+	if err != nil {
+		logrus.Warnf("failed to check existence of database %s: %v", dbName, err)
+	}
+	return nil
+}
+
 func prepareDSN(dataSourceName string, tlsConfig *cryptotls.Config) (string, error) {
 	if len(dataSourceName) == 0 {
 		dataSourceName = defaultUnixDSN
@@ -212,7 +386,7 @@ func prepareDSN(dataSourceName string, tlsConfig *cryptotls.Config) (string, err
 			dataSourceName = defaultHostDSN
 		}
 	}
-	config, err := mysql.ParseDSN(dataSourceName)
+	config, addrs, err := parseDSN(dataSourceName)
 	if err != nil {
 		return "", err
 	}
@@ -228,11 +402,13 @@ func prepareDSN(dataSourceName string, tlsConfig *cryptotls.Config) (string, err
 		dbName = config.DBName
 	}
 	config.DBName = dbName
-	parsedDSN := config.FormatDSN()
+	parsedDSN := formatDSN(config, addrs)
 
 	return parsedDSN, nil
 }
 
 func init() {
 	drivers.Register("mysql", New)
+	drivers.RegisterMigrator("mysql", NewMigrator)
+	drivers.RegisterReplicas("mysql")
 }
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"time"
 
+	natsserver "github.com/k3s-io/kine/pkg/drivers/nats/server"
 	"github.com/k3s-io/kine/pkg/server"
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
@@ -51,15 +52,34 @@ var (
 
 type Backend struct {
 	nc     *nats.Conn
+	ns     natsserver.Server
 	js     jetstream.JetStream
 	kv     *KeyValue
 	l      *logrus.Logger
 	cancel context.CancelFunc
 }
 
+// Close stops the bucket watcher and drains the connection, flushing any pending writes.
+// If an embedded server is in use, it is shut down once the connection has been drained.
 func (b *Backend) Close() error {
-	b.cancel()
-	return b.nc.Drain()
+	if b.cancel != nil {
+		b.cancel()
+	}
+	var err error
+	if b.nc != nil {
+		closed := make(chan struct{})
+		b.nc.SetClosedHandler(func(_ *nats.Conn) {
+			close(closed)
+		})
+		if err = b.nc.Drain(); err == nil {
+			<-closed
+		}
+	}
+	if b.ns != nil {
+		b.ns.Shutdown()
+		b.l.Infof("embedded NATS server shutdown")
+	}
+	return err
 }
 
 // isExpiredKey checks if the key is expired based on the create time and lease.
@@ -134,6 +154,41 @@ func (b *Backend) CurrentRevision(ctx context.Context) (int64, error) {
 	return b.kv.BucketRevision(), nil
 }
 
+// RevisionAt returns the latest revision created at or before the given time.
+func (b *Backend) RevisionAt(ctx context.Context, t time.Time) (int64, error) {
+	return b.kv.RevisionAt(ctx, t)
+}
+
+// History returns the retained revisions of the key, or of all keys with the key as a prefix,
+// between the start and end revisions inclusive.
+func (b *Backend) History(ctx context.Context, key string, prefix bool, startRevision, endRevision, limit int64) (int64, []*server.Event, error) {
+	storeRev := b.kv.BucketRevision()
+	entries, err := b.kv.History(ctx, key, prefix, startRevision, endRevision, limit)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	events := make([]*server.Event, 0, len(entries))
+	for _, e := range entries {
+		var nd natsData
+		if err := nd.Decode(e); err != nil {
+			return 0, nil, err
+		}
+		events = append(events, &server.Event{
+			Create: nd.Create,
+			Delete: nd.Delete,
+			KV:     nd.KV,
+		})
+	}
+	return storeRev, events, nil
+}
+
+// Capabilities returns the capabilities of the backend. Revision history is managed by the
+// jetstream bucket, so the backend cannot be compacted on request.
+func (b *Backend) Capabilities() server.Capabilities {
+	return server.Capabilities{History: true}
+}
+
 // Count returns an exact count of the number of matching keys and the current revision of the database.
 func (b *Backend) Count(ctx context.Context, prefix, startKey string, revision int64) (int64, int64, error) {
 	count, err := b.kv.Count(ctx, prefix, startKey, revision)
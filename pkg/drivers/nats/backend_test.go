@@ -376,3 +376,60 @@ func TestBackend_Watch(t *testing.T) {
 
 	expEqual(t, 5, len(events))
 }
+
+func TestBackend_RevisionAt(t *testing.T) {
+	ns, nc, b := setupBackend(t)
+	defer ns.Shutdown()
+	defer nc.Drain()
+
+	ctx := context.Background()
+
+	before := time.Now()
+	rev1, err := b.Create(ctx, "/a", nil, 0)
+	noErr(t, err)
+	time.Sleep(10 * time.Millisecond)
+	between := time.Now()
+	rev2, _, _, err := b.Update(ctx, "/a", nil, rev1, 0)
+	noErr(t, err)
+
+	rev, err := b.RevisionAt(ctx, before)
+	noErr(t, err)
+	expEqual(t, 0, rev)
+	rev, err = b.RevisionAt(ctx, between)
+	noErr(t, err)
+	expEqual(t, rev1, rev)
+	rev, err = b.RevisionAt(ctx, time.Now())
+	noErr(t, err)
+	expEqual(t, rev2, rev)
+}
+
+func TestBackend_History(t *testing.T) {
+	ns, nc, b := setupBackend(t)
+	defer ns.Shutdown()
+	defer nc.Drain()
+
+	ctx := context.Background()
+
+	rev1, err := b.Create(ctx, "/a/a", []byte("1"), 0)
+	noErr(t, err)
+	_, err = b.Create(ctx, "/a/b", []byte("1"), 0)
+	noErr(t, err)
+	rev3, _, _, err := b.Update(ctx, "/a/a", []byte("2"), rev1, 0)
+	noErr(t, err)
+
+	// Wait for the btree to be updated.
+	time.Sleep(10 * time.Millisecond)
+
+	_, events, err := b.History(ctx, "/a/a", false, 0, 0, 0)
+	noErr(t, err)
+	expEqual(t, 2, len(events))
+	expEqual(t, true, events[0].Create)
+	expEqual(t, "1", string(events[0].KV.Value))
+	expEqual(t, rev3, events[1].KV.ModRevision)
+	expEqual(t, "2", string(events[1].KV.Value))
+
+	_, events, err = b.History(ctx, "/a/", true, rev1+1, 0, 1)
+	noErr(t, err)
+	expEqual(t, 1, len(events))
+	expEqual(t, "/a/b", events[0].KV.Key)
+}
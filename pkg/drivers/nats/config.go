@@ -143,6 +143,7 @@ func parseConnection(dsn string, tlsInfo tls.Config) (*Config, error) {
 		config.clientOptions = natsClientOpts
 	}
 
+	var username, password, token string
 	connBuilder := strings.Builder{}
 	for idx, c := range connections {
 		if idx > 0 {
@@ -163,14 +164,22 @@ func parseConnection(dsn string, tlsInfo tls.Config) (*Config, error) {
 		if u.User != nil && idx == 0 {
 			userInfo := strings.Split(u.User.String(), ":")
 			if len(userInfo) > 1 {
-				config.clientOptions = append(config.clientOptions, nats.UserInfo(userInfo[0], userInfo[1]))
+				username, password = userInfo[0], userInfo[1]
 			} else {
-				config.clientOptions = append(config.clientOptions, nats.Token(userInfo[0]))
+				token = userInfo[0]
 			}
 		}
 		connBuilder.WriteString(u.Host)
 	}
 
+	// Credentials can be read from files instead of being embedded in the URL, and are re-read
+	// when reconnecting so that rotated credentials are used.
+	credentialOpts, err := credentialOptions(queryMap.Get("usernameFile"), queryMap.Get("passwordFile"), queryMap.Get("tokenFile"), username, password, token)
+	if err != nil {
+		return nil, err
+	}
+	config.clientOptions = append(config.clientOptions, credentialOpts...)
+
 	config.clientURL = connBuilder.String()
 
 	// Config options only relevant if built with embedded NATS.
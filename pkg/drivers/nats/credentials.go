@@ -0,0 +1,80 @@
+package nats
+
+import (
+	"sync"
+
+	"github.com/k3s-io/kine/pkg/util"
+	"github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+)
+
+// secretFile is a secret that is read from a file each time the client connects, so that rotated
+// secrets are used when reconnecting. If the file cannot be read, the secret last read is used.
+// Secrets that are not read from a file have a fixed value.
+type secretFile struct {
+	path string
+
+	mu    sync.Mutex
+	value string
+}
+
+func newSecretFile(path, value string) (*secretFile, error) {
+	f := &secretFile{path: path, value: value}
+	if path != "" {
+		value, err := util.ReadSecretFile(path)
+		if err != nil {
+			return nil, err
+		}
+		f.value = value
+	}
+	return f, nil
+}
+
+func (f *secretFile) read() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.path != "" {
+		value, err := util.ReadSecretFile(f.path)
+		if err != nil {
+			logrus.Errorf("Failed to read %s, using the previous value: %v", f.path, err)
+			return f.value
+		}
+		f.value = value
+	}
+	return f.value
+}
+
+// credentialOptions returns the client options for the credentials, which are read from the files
+// if set, and otherwise use the values from the connection URL.
+func credentialOptions(usernameFile, passwordFile, tokenFile, username, password, token string) ([]nats.Option, error) {
+	if usernameFile != "" || passwordFile != "" {
+		u, err := newSecretFile(usernameFile, username)
+		if err != nil {
+			return nil, err
+		}
+		p, err := newSecretFile(passwordFile, password)
+		if err != nil {
+			return nil, err
+		}
+		return []nats.Option{nats.UserInfoHandler(func() (string, string) {
+			return u.read(), p.read()
+		})}, nil
+	}
+
+	if tokenFile != "" {
+		t, err := newSecretFile(tokenFile, token)
+		if err != nil {
+			return nil, err
+		}
+		return []nats.Option{nats.TokenHandler(t.read)}, nil
+	}
+
+	if password != "" {
+		return []nats.Option{nats.UserInfo(username, password)}, nil
+	}
+	if token != "" {
+		return []nats.Option{nats.Token(token)}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,48 @@
+package nats
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSecretFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if _, err := newSecretFile(path, ""); err == nil {
+		t.Fatal("expected error for missing file")
+	}
+
+	if err := os.WriteFile(path, []byte("first\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := newSecretFile(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := f.read(); v != "first" {
+		t.Fatalf("expected first, got %q", v)
+	}
+
+	if err := os.WriteFile(path, []byte("rotated"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if v := f.read(); v != "rotated" {
+		t.Fatalf("expected rotated, got %q", v)
+	}
+
+	// the last value read is used if the file is removed
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if v := f.read(); v != "rotated" {
+		t.Fatalf("expected rotated, got %q", v)
+	}
+
+	f, err = newSecretFile("", "inline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := f.read(); v != "inline" {
+		t.Fatalf("expected inline, got %q", v)
+	}
+}
@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/k3s-io/kine/pkg/server"
 	"github.com/nats-io/nats.go/jetstream"
 	"github.com/sirupsen/logrus"
 	"github.com/tidwall/btree"
@@ -298,11 +300,21 @@ func (e *KeyValue) Watch(ctx context.Context, keys string, startRev int64) (jets
 
 // BucketSize returns the size of the bucket in bytes.
 func (e *KeyValue) BucketSize(ctx context.Context) (int64, error) {
-	status, err := e.nkv.Status(ctx)
+	info, err := e.streamInfo(ctx)
 	if err != nil {
 		return 0, err
 	}
-	return int64(status.Bytes()), nil
+	return int64(info.State.Bytes), nil
+}
+
+// streamInfo returns the info of the stream that backs the bucket. The bucket's Status is
+// not used, as it updates stream info that is read by concurrent Gets without locking.
+func (e *KeyValue) streamInfo(ctx context.Context) (*jetstream.StreamInfo, error) {
+	stream, err := e.js.Stream(ctx, fmt.Sprintf("KV_%s", e.nkv.Bucket()))
+	if err != nil {
+		return nil, err
+	}
+	return stream.CachedInfo(), nil
 }
 
 // BucketRevision returns the latest revision of the bucket.
@@ -313,6 +325,52 @@ func (e *KeyValue) BucketRevision() int64 {
 	return int64(s)
 }
 
+// RevisionAt returns the latest revision of the bucket created at or before the given time,
+// using the creation time of the stream messages. ErrCompacted is returned if messages created
+// at or before the time have been removed from the start of the stream.
+func (e *KeyValue) RevisionAt(ctx context.Context, t time.Time) (int64, error) {
+	streamName := fmt.Sprintf("KV_%s", e.nkv.Bucket())
+	stream, err := e.js.Stream(ctx, streamName)
+	if err != nil {
+		return 0, err
+	}
+	info, err := stream.Info(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if !info.State.LastTime.After(t) {
+		return int64(info.State.LastSeq), nil
+	}
+
+	// find the first message created after the time; the revision before it was current at that time
+	con, err := stream.OrderedConsumer(ctx, jetstream.OrderedConsumerConfig{
+		DeliverPolicy: jetstream.DeliverByStartTimePolicy,
+		OptStartTime:  &t,
+	})
+	if err != nil {
+		return 0, err
+	}
+	var seq uint64
+	for seq == 0 {
+		msg, err := con.Next()
+		if err != nil {
+			return 0, err
+		}
+		md, err := msg.Metadata()
+		if err != nil {
+			return 0, err
+		}
+		if md.Timestamp.After(t) {
+			seq = md.Sequence.Stream
+		}
+	}
+
+	if seq <= info.State.FirstSeq && info.State.FirstSeq > 1 {
+		return 0, server.ErrCompacted
+	}
+	return int64(seq - 1), nil
+}
+
 func (e *KeyValue) btreeWatcher(ctx context.Context) error {
 	w, err := e.Watch(ctx, "/", int64(e.lastSeq))
 	if err != nil {
@@ -320,8 +378,11 @@ func (e *KeyValue) btreeWatcher(ctx context.Context) error {
 	}
 	defer w.Stop()
 
-	status, _ := e.nkv.Status(ctx)
-	hsize := status.History()
+	info, err := e.streamInfo(ctx)
+	if err != nil {
+		return err
+	}
+	hsize := info.Config.MaxMsgsPerSubject
 
 	for {
 		select {
@@ -378,22 +439,25 @@ type keySeq struct {
 
 func (e *KeyValue) Count(ctx context.Context, prefix, startKey string, revision int64) (int64, error) {
 	seekKey := prefix
-	if startKey != "" {
+	if startKey != "" && prefix == "" {
+		seekKey = startKey
+	} else if startKey != "" {
 		seekKey = strings.TrimSuffix(seekKey, "/")
 		seekKey = fmt.Sprintf("%s/%s", seekKey, startKey)
 	}
 
+	e.btm.RLock()
+	defer e.btm.RUnlock()
+
+	// an empty seek key positions the iterator at the first key
 	it := e.bt.Iter()
-	if seekKey != "" {
-		if ok := it.Seek(seekKey); !ok {
-			return 0, nil
-		}
+	if ok := it.Seek(seekKey); !ok {
+		return 0, nil
 	}
 
 	var count int64
 	now := time.Now()
 
-	e.btm.RLock()
 	for {
 		k := it.Key()
 		if !strings.HasPrefix(k, prefix) {
@@ -428,30 +492,31 @@ func (e *KeyValue) Count(ctx context.Context, prefix, startKey string, revision
 			break
 		}
 	}
-	e.btm.RUnlock()
 
 	return count, nil
 }
 
 func (e *KeyValue) List(ctx context.Context, prefix, startKey string, limit, revision int64) ([]jetstream.KeyValueEntry, error) {
 	seekKey := prefix
-	if startKey != "" {
+	if startKey != "" && prefix == "" {
+		seekKey = startKey
+	} else if startKey != "" {
 		seekKey = strings.TrimSuffix(seekKey, "/")
 		seekKey = fmt.Sprintf("%s/%s", seekKey, startKey)
 	}
 
+	e.btm.RLock()
+
+	// an empty seek key positions the iterator at the first key
 	it := e.bt.Iter()
-	if seekKey != "" {
-		if ok := it.Seek(seekKey); !ok {
-			return nil, nil
-		}
+	if ok := it.Seek(seekKey); !ok {
+		e.btm.RUnlock()
+		return nil, nil
 	}
 
 	var matches []*keySeq
 	now := time.Now()
 
-	e.btm.RLock()
-
 	for {
 		if limit > 0 && len(matches) == int(limit) {
 			break
@@ -508,6 +573,62 @@ func (e *KeyValue) List(ctx context.Context, prefix, startKey string, limit, rev
 	return entries, nil
 }
 
+// History returns the retained entries for the key, or for all keys with the key as a prefix,
+// between the start and end revisions inclusive, in revision order. Only put operations are
+// returned, as deletions are recorded by kine as puts of a tombstone value.
+func (e *KeyValue) History(ctx context.Context, key string, prefix bool, startRevision, endRevision, limit int64) ([]jetstream.KeyValueEntry, error) {
+	var matches []*keySeq
+	add := func(k string, v []*seqOp) {
+		for _, so := range v {
+			if so.op != jetstream.KeyValuePut || so.seq < uint64(startRevision) {
+				continue
+			}
+			if endRevision > 0 && so.seq > uint64(endRevision) {
+				break
+			}
+			matches = append(matches, &keySeq{key: k, seq: so.seq})
+		}
+	}
+
+	e.btm.RLock()
+	if prefix {
+		e.bt.Ascend(key, func(k string, v []*seqOp) bool {
+			if !strings.HasPrefix(k, key) {
+				return false
+			}
+			add(k, v)
+			return true
+		})
+	} else if v, ok := e.bt.Get(key); ok {
+		add(key, v)
+	}
+	e.btm.RUnlock()
+
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].seq < matches[j].seq
+	})
+	if limit > 0 && int64(len(matches)) > limit {
+		matches = matches[:limit]
+	}
+
+	logrus.Debugf("kv: history: got %d matches from btree", len(matches))
+
+	var entries []jetstream.KeyValueEntry
+	for _, m := range matches {
+		e, err := e.GetRevision(ctx, m.key, m.seq)
+		if err != nil {
+			// the entry may have been removed from the stream since the btree was updated
+			if err == jetstream.ErrKeyNotFound {
+				continue
+			}
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
 func NewKeyValue(ctx context.Context, bucket jetstream.KeyValue, js jetstream.JetStream) *KeyValue {
 	kv := &KeyValue{
 		nkv: bucket,
@@ -522,6 +643,9 @@ func NewKeyValue(ctx context.Context, bucket jetstream.KeyValue, js jetstream.Je
 		defer cancel()
 		for {
 			err := kv.btreeWatcher(ctx)
+			if ctx.Err() != nil {
+				return
+			}
 			if err != nil {
 				logrus.Errorf("btree watcher error: %v", err)
 			}
@@ -125,3 +125,44 @@ func (b *BackendLogger) CurrentRevision(ctx context.Context) (int64, error) {
 func (b *BackendLogger) Compact(ctx context.Context, revision int64) (int64, error) {
 	return revision, nil
 }
+
+func (b *BackendLogger) RevisionAt(ctx context.Context, t time.Time) (revRet int64, errRet error) {
+	start := time.Now()
+	defer func() {
+		dur := time.Since(start)
+		fStr := "REVISIONAT %s => rev=%d, err=%v, duration=%s"
+		b.logMethod(dur, fStr, t.Format(time.RFC3339Nano), revRet, errRet, dur)
+	}()
+
+	history, ok := b.backend.(server.HistoryBackend)
+	if !ok {
+		return 0, server.ErrHistoryNotSupported
+	}
+	return history.RevisionAt(ctx, t)
+}
+
+func (b *BackendLogger) History(ctx context.Context, key string, prefix bool, startRevision, endRevision, limit int64) (revRet int64, eventsRet []*server.Event, errRet error) {
+	start := time.Now()
+	defer func() {
+		dur := time.Since(start)
+		fStr := "HISTORY %s, prefix=%v, start=%d, end=%d, limit=%d => rev=%d, events=%d, err=%v, duration=%s"
+		b.logMethod(dur, fStr, key, prefix, startRevision, endRevision, limit, revRet, len(eventsRet), errRet, dur)
+	}()
+
+	history, ok := b.backend.(server.HistoryBackend)
+	if !ok {
+		return 0, nil, server.ErrHistoryNotSupported
+	}
+	return history.History(ctx, key, prefix, startRevision, endRevision, limit)
+}
+
+func (b *BackendLogger) Capabilities() server.Capabilities {
+	if c, ok := b.backend.(server.CapabilitiesBackend); ok {
+		return c.Capabilities()
+	}
+	return server.Capabilities{}
+}
+
+func (b *BackendLogger) Close() error {
+	return b.backend.Close()
+}
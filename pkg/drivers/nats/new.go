@@ -4,8 +4,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"os"
-	"os/signal"
 	"time"
 
 	"github.com/k3s-io/kine/pkg/drivers"
@@ -71,7 +69,8 @@ func newBackend(ctx context.Context, connection string, tlsInfo tls.Config, lega
 
 	// Run an embedded server if available and not disabled.
 	var ns natsserver.Server
-	cancel := func() {}
+	ctx, ctxCancel := context.WithCancel(ctx)
+	cancel := ctxCancel
 
 	if !legacy && natsserver.Embedded && !config.noEmbed {
 		logrus.Infof("using an embedded NATS server")
@@ -85,6 +84,7 @@ func newBackend(ctx context.Context, connection string, tlsInfo tls.Config, lega
 			DataDir:       config.dataDir,
 		})
 		if err != nil {
+			cancel()
 			return nil, fmt.Errorf("failed to create embedded NATS server: %w", err)
 		}
 
@@ -112,7 +112,12 @@ func newBackend(ctx context.Context, connection string, tlsInfo tls.Config, lega
 		// Use the local server's client URL.
 		config.clientURL = ns.ClientURL()
 
-		ctx, cancel = context.WithCancel(ctx)
+		// Shut down the embedded server if the client cannot be set up.
+		// Once setup succeeds, Backend.Close shuts it down after draining.
+		cancel = func() {
+			ctxCancel()
+			ns.Shutdown()
+		}
 	}
 
 	if !config.dontListen {
@@ -172,22 +177,11 @@ func newBackend(ctx context.Context, connection string, tlsInfo tls.Config, lega
 
 	backend := Backend{
 		nc:     nc,
+		ns:     ns,
 		l:      l,
 		kv:     ekv,
 		js:     js,
-		cancel: cancel,
-	}
-
-	if ns != nil {
-		// TODO: No method on backend.Driver exists to indicate a shutdown.
-		sigch := make(chan os.Signal, 1)
-		signal.Notify(sigch, os.Interrupt)
-		go func() {
-			<-sigch
-			backend.Close()
-			ns.Shutdown()
-			logrus.Infof("embedded NATS server shutdown")
-		}()
+		cancel: ctxCancel,
 	}
 
 	return &BackendLogger{
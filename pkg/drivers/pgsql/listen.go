@@ -0,0 +1,105 @@
+package pgsql
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/k3s-io/kine/pkg/drivers/generic"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	listenRetryInterval = time.Second
+)
+
+var (
+	// notifyFunction sends the id of each inserted row to the notification channel, which is named
+	// after the schema and table, so that servers using different tables or schemas in the same
+	// database are not woken by each other's writes. Channel names are limited to 63 bytes.
+	notifyFunction = `CREATE OR REPLACE FUNCTION kine_notify() RETURNS trigger AS $$
+			BEGIN
+				PERFORM pg_notify(left(TG_TABLE_SCHEMA || '.' || TG_TABLE_NAME, 63), NEW.id::text);
+				RETURN NULL;
+			END;
+		$$ LANGUAGE plpgsql`
+
+	// notifySchema installs a trigger that calls the notify function for each inserted row.
+	// Notifications are only delivered once the inserting transaction commits.
+	notifySchema = []string{
+		notifyFunction,
+		`DO $$
+			BEGIN
+				IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'kine_notify' AND tgrelid = 'kine'::regclass) THEN
+					CREATE TRIGGER kine_notify AFTER INSERT ON kine FOR EACH ROW EXECUTE PROCEDURE kine_notify();
+				END IF;
+			END
+		$$`,
+	}
+)
+
+// listener returns a generic.Listener that holds a dedicated connection to the database,
+// and sends the revision from each notification sent by the insert trigger on the table.
+// If the connection is lost, it is re-established after a short delay, with the
+// credentials currently in the credential files, if any.
+func listener(dataSourceName string, credentials generic.Credentials, table string) generic.Listener {
+	return func(ctx context.Context) <-chan int64 {
+		result := make(chan int64, 100)
+		go func() {
+			defer close(result)
+			for {
+				if err := listen(ctx, dataSourceName, credentials, table, result); err != nil && ctx.Err() == nil {
+					logrus.Warnf("Failed to listen for notifications, retrying: %v", err)
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(listenRetryInterval):
+				}
+			}
+		}()
+		return result
+	}
+}
+
+func listen(ctx context.Context, dataSourceName string, credentials generic.Credentials, table string, result chan<- int64) error {
+	dataSourceName, err := credentials.Apply(dataSourceName, setCredentials)
+	if err != nil {
+		return err
+	}
+
+	conn, err := pgx.Connect(ctx, dataSourceName)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	// the connection uses the same search path as the connection pool, so the current schema
+	// is the schema of the table that the trigger is installed on
+	var channel string
+	if err := conn.QueryRow(ctx, "SELECT left(current_schema() || '.' || $1, 63)", table).Scan(&channel); err != nil {
+		return err
+	}
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+		return err
+	}
+	logrus.Debugf("Listening for notifications on channel %s", channel)
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		rev, err := strconv.ParseInt(n.Payload, 10, 64)
+		if err != nil {
+			logrus.Warnf("Ignoring notification with invalid payload %q", n.Payload)
+			continue
+		}
+		select {
+		case result <- rev:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
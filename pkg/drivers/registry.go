@@ -3,13 +3,20 @@ package drivers
 import (
 	"context"
 
+	"github.com/k3s-io/kine/pkg/drivers/generic"
 	"github.com/k3s-io/kine/pkg/server"
 )
 
 // Constructor is a function that takes a context and a config and returns a leaderElect bool, a server.Backend and an error
 type Constructor func(ctx context.Context, cfg *Config) (leaderElect bool, backend server.Backend, err error)
 
+// MigratorConstructor is a function that takes a context and a config and returns a schema migrator
+// for the database. The caller is responsible for closing the migrator.
+type MigratorConstructor func(ctx context.Context, cfg *Config) (*generic.Migrator, error)
+
 var driverRegistry = map[string]Constructor{}
+var migratorRegistry = map[string]MigratorConstructor{}
+var replicaRegistry = map[string]bool{}
 var defaultScheme string
 
 // Register registers a constructor for the given scheme
@@ -34,3 +41,25 @@ func Get(scheme string) (Constructor, bool) {
 	constructor, ok := driverRegistry[scheme]
 	return constructor, ok
 }
+
+// RegisterMigrator registers a schema migrator constructor for the given scheme
+func RegisterMigrator(scheme string, constructor MigratorConstructor) {
+	migratorRegistry[scheme] = constructor
+}
+
+// GetMigrator returns the schema migrator constructor for the given scheme
+// The second return value is true if the scheme has a migrator registered, false otherwise
+func GetMigrator(scheme string) (MigratorConstructor, bool) {
+	constructor, ok := migratorRegistry[scheme]
+	return constructor, ok
+}
+
+// RegisterReplicas records that the driver for the given scheme supports read replicas
+func RegisterReplicas(scheme string) {
+	replicaRegistry[scheme] = true
+}
+
+// SupportsReplicas returns true if the driver for the given scheme supports read replicas
+func SupportsReplicas(scheme string) bool {
+	return replicaRegistry[scheme]
+}
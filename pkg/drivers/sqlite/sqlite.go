@@ -1,12 +1,7 @@
-//go:build cgo
-// +build cgo
-
 package sqlite
 
 import (
 	"context"
-	"database/sql"
-	"fmt"
 	"os"
 
 	"github.com/k3s-io/kine/pkg/drivers"
@@ -14,10 +9,15 @@ import (
 	"github.com/k3s-io/kine/pkg/logstructured"
 	"github.com/k3s-io/kine/pkg/logstructured/sqllog"
 	"github.com/k3s-io/kine/pkg/server"
-	"github.com/k3s-io/kine/pkg/util"
-	"github.com/mattn/go-sqlite3"
 	"github.com/pkg/errors"
-	"github.com/sirupsen/logrus"
+)
+
+const (
+	// omitOldValueVersion is the schema version from which previous values are not stored.
+	omitOldValueVersion = 3
+
+	// pollRevisionVersion is the schema version that adds the poll revision table.
+	pollRevisionVersion = 6
 )
 
 var (
@@ -32,96 +32,213 @@ var (
 				prev_revision INTEGER,
 				lease INTEGER,
 				value BLOB,
-				old_value BLOB
+				old_value BLOB,
+				compression INTEGER,
+				created_at BIGINT
 			)`,
 		`CREATE INDEX IF NOT EXISTS kine_name_index ON kine (name)`,
 		`CREATE INDEX IF NOT EXISTS kine_name_id_index ON kine (name,id)`,
 		`CREATE INDEX IF NOT EXISTS kine_id_deleted_index ON kine (id,deleted)`,
 		`CREATE INDEX IF NOT EXISTS kine_prev_revision_index ON kine (prev_revision)`,
 		`CREATE UNIQUE INDEX IF NOT EXISTS kine_name_prev_revision_uindex ON kine (name, prev_revision)`,
+		`CREATE TABLE IF NOT EXISTS kine_compact_lease
+			(
+				id INTEGER PRIMARY KEY,
+				holder VARCHAR(255),
+				expires BIGINT
+			)`,
+		`INSERT OR IGNORE INTO kine_compact_lease(id, holder, expires) VALUES (1, '', 0)`,
+		`CREATE TABLE IF NOT EXISTS kine_poll_revision
+			(
+				holder VARCHAR(255) PRIMARY KEY,
+				revision BIGINT,
+				expires BIGINT
+			)`,
 		`PRAGMA wal_checkpoint(TRUNCATE)`,
 	}
+
+	// migrations are applied in order after the initial schema, and should handle
+	// deltas between prior schema versions.
+	migrations = []generic.Migration{
+		{
+			Version:     2,
+			Description: "add value compression column",
+			Statements: []string{
+				`ALTER TABLE kine ADD COLUMN compression INTEGER`,
+			},
+		},
+		{
+			Version:     omitOldValueVersion,
+			Description: "stop storing previous values",
+			Manual:      true,
+			Statements: []string{
+				`UPDATE kine SET old_value = NULL WHERE old_value IS NOT NULL`,
+			},
+		},
+		{
+			Version:     4,
+			Description: "add row creation time column",
+			Statements: []string{
+				`ALTER TABLE kine ADD COLUMN created_at BIGINT`,
+			},
+		},
+		{
+			Version:     5,
+			Description: "add compaction lease table",
+			Statements: []string{
+				`CREATE TABLE IF NOT EXISTS kine_compact_lease
+					(
+						id INTEGER PRIMARY KEY,
+						holder VARCHAR(255),
+						expires BIGINT
+					)`,
+				`INSERT OR IGNORE INTO kine_compact_lease(id, holder, expires) VALUES (1, '', 0)`,
+			},
+		},
+		{
+			Version:     pollRevisionVersion,
+			Description: "add poll revision table",
+			Statements: []string{
+				`CREATE TABLE IF NOT EXISTS kine_poll_revision
+					(
+						holder VARCHAR(255) PRIMARY KEY,
+						revision BIGINT,
+						expires BIGINT
+					)`,
+			},
+		},
+	}
+
+	// variants holds the driver-specific settings for each of the supported database/sql sqlite drivers.
+	variants = map[string]variant{}
 )
 
+// variant contains the parts of the dialect that differ between sqlite drivers.
+type variant struct {
+	dataSourceName string
+	translateErr   generic.TranslateErr
+	errCode        generic.ErrCode
+	tableNotExist  func(error) bool
+}
+
+// New returns a backend using the default sqlite driver for this build;
+// the cgo driver if cgo is enabled, otherwise the pure-Go driver.
 func New(ctx context.Context, cfg *drivers.Config) (bool, server.Backend, error) {
-	backend, _, err := NewVariant(ctx, "sqlite3", cfg)
+	backend, _, err := NewVariant(ctx, defaultDriverName, cfg)
 	return false, backend, err
 }
 
-func NewVariant(ctx context.Context, driverName string, cfg *drivers.Config) (server.Backend, *generic.Generic, error) {
-	dataSourceName := cfg.DataSourceName
-	if dataSourceName == "" {
-		if err := os.MkdirAll("./db", 0700); err != nil {
-			return nil, nil, err
-		}
-		dataSourceName = "./db/state.db?_journal=WAL&cache=shared&_busy_timeout=30000&_txlock=immediate"
-	}
+// NewPureGo returns a backend using the pure-Go sqlite driver, regardless of whether cgo is enabled.
+func NewPureGo(ctx context.Context, cfg *drivers.Config) (bool, server.Backend, error) {
+	backend, _, err := NewVariant(ctx, pureGoDriverName, cfg)
+	return false, backend, err
+}
 
-	dialect, err := generic.Open(ctx, driverName, dataSourceName, cfg.ConnectionPoolConfig, "?", false, cfg.MetricsRegisterer)
+func NewVariant(ctx context.Context, driverName string, cfg *drivers.Config) (_ server.Backend, _ *generic.Generic, err error) {
+	dialect, err := open(ctx, driverName, cfg)
 	if err != nil {
 		return nil, nil, err
 	}
-
-	dialect.LastInsertID = true
-	dialect.GetSizeSQL = `SELECT SUM(pgsize) FROM dbstat`
-	dialect.CompactSQL = `
-		DELETE FROM kine AS kv
-		WHERE
-			kv.id IN (
-				SELECT kp.prev_revision AS id
-				FROM kine AS kp
-				WHERE
-					kp.name != 'compact_rev_key' AND
-					kp.prev_revision != 0 AND
-					kp.id <= ?
-				UNION
-				SELECT kd.id AS id
-				FROM kine AS kd
-				WHERE
-					kd.deleted != 0 AND
-					kd.id <= ?
-			)`
-	dialect.PostCompactSQL = `PRAGMA wal_checkpoint(FULL)`
-	dialect.TranslateErr = func(err error) error {
-		if err, ok := err.(sqlite3.Error); ok && err.ExtendedCode == sqlite3.ErrConstraintUnique {
-			return server.ErrKeyExists
-		}
-		return err
-	}
-	dialect.ErrCode = func(err error) string {
-		if err == nil {
-			return ""
-		}
-		if err, ok := err.(sqlite3.Error); ok {
-			return fmt.Sprint(err.ExtendedCode)
+	defer func() {
+		if err != nil {
+			dialect.Close()
 		}
-		return err.Error()
-	}
+	}()
 
-	if err := setup(dialect.DB); err != nil {
+	m := migrator(dialect, driverName)
+	if err := m.Up(ctx, generic.StartupVersion()); err != nil {
 		return nil, nil, errors.Wrap(err, "setup db")
 	}
+	if dialect.OmitOldValue, err = m.Applied(ctx, omitOldValueVersion); err != nil {
+		return nil, nil, err
+	}
+	if dialect.PollRevisions, err = m.Applied(ctx, pollRevisionVersion); err != nil {
+		return nil, nil, err
+	}
+
+	// sqlite databases are not normally shared between kine servers, but if they are, a lease
+	// ensures that only one of them compacts
+	dialect.CompactElector = generic.NewLeaseElector(dialect.DB, dialect.Table, "?", false)
 
 	dialect.Migrate(context.Background())
-	return logstructured.New(sqllog.New(dialect, cfg.CompactInterval, cfg.CompactIntervalJitter, cfg.CompactTimeout, cfg.CompactMinRetain, cfg.CompactBatchSize, cfg.PollBatchSize)), dialect, nil
+	return logstructured.New(sqllog.New(dialect, cfg.CompactInterval, cfg.CompactIntervalJitter, cfg.CompactTimeout, cfg.CompactMinRetain, cfg.CompactBatchSize, cfg.PollBatchSize, cfg.Route)), dialect, nil
 }
 
-func setup(db *sql.DB) error {
-	logrus.Infof("Configuring database table schema and indexes, this may take a moment...")
+// NewMigrator returns a schema migrator for the database, using the default sqlite driver for this build.
+func NewMigrator(ctx context.Context, cfg *drivers.Config) (*generic.Migrator, error) {
+	return newVariantMigrator(ctx, defaultDriverName, cfg)
+}
 
-	for _, stmt := range schema {
-		logrus.Tracef("SETUP EXEC : %v", util.Stripped(stmt))
-		_, err := db.Exec(stmt)
-		if err != nil {
-			return err
+// NewPureGoMigrator returns a schema migrator for the database, using the pure-Go sqlite driver.
+func NewPureGoMigrator(ctx context.Context, cfg *drivers.Config) (*generic.Migrator, error) {
+	return newVariantMigrator(ctx, pureGoDriverName, cfg)
+}
+
+func newVariantMigrator(ctx context.Context, driverName string, cfg *drivers.Config) (*generic.Migrator, error) {
+	dialect, err := open(ctx, driverName, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return migrator(dialect, driverName), nil
+}
+
+func open(ctx context.Context, driverName string, cfg *drivers.Config) (*generic.Generic, error) {
+	v, ok := variants[driverName]
+	if !ok {
+		return nil, errors.Errorf("unsupported sqlite driver %q", driverName)
+	}
+
+	dataSourceName, table, err := generic.ParseTable(cfg.DataSourceName)
+	if err != nil {
+		return nil, err
+	}
+	if dataSourceName == "" {
+		if err := os.MkdirAll("./db", 0700); err != nil {
+			return nil, err
 		}
+		dataSourceName = v.dataSourceName
+	}
+
+	dialect, err := generic.Open(ctx, driverName, dataSourceName, table, cfg.ConnectionPoolConfig, "?", false, cfg.MetricsRegisterer)
+	if err != nil {
+		return nil, err
+	}
+
+	dialect.LastInsertID = true
+	dialect.GetSizeSQL = generic.TableSQL(table, `
+		SELECT SUM(s.pgsize)
+		FROM dbstat AS s
+		JOIN sqlite_master AS m ON m.name = s.name
+		WHERE m.tbl_name = 'kine'`)
+	dialect.CompactSQL = `
+		DELETE FROM kine AS kv
+		WHERE kv.id IN (%s)`
+	dialect.PostCompactSQL = `PRAGMA wal_checkpoint(FULL)`
+	dialect.TranslateErr = v.translateErr
+	dialect.ErrCode = v.errCode
+	if err := dialect.ConfigureCompression(cfg.CompressionConfig); err != nil {
+		dialect.Close()
+		return nil, err
+	}
+	if err := dialect.ConfigureRetention(cfg.RetentionConfig); err != nil {
+		dialect.Close()
+		return nil, err
 	}
+	return dialect, nil
+}
 
-	logrus.Infof("Database tables and indexes are up to date")
-	return nil
+// migrator returns the schema migrator for the database. No lock is taken while
+// migrating, as sqlite databases are not shared between kine servers.
+func migrator(dialect *generic.Generic, driverName string) *generic.Migrator {
+	m := generic.NewMigrator(dialect.DB, dialect.Table, "?", false, schema, migrations)
+	m.TableNotExist = variants[driverName].tableNotExist
+	return m
 }
 
 func init() {
 	drivers.Register("sqlite", New)
+	drivers.Register("sqlite-purego", NewPureGo)
 	drivers.SetDefault("sqlite")
+	drivers.RegisterMigrator("sqlite", NewMigrator)
+	drivers.RegisterMigrator("sqlite-purego", NewPureGoMigrator)
 }
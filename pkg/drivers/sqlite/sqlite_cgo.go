@@ -0,0 +1,40 @@
+//go:build cgo
+// +build cgo
+
+package sqlite
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/k3s-io/kine/pkg/server"
+	"github.com/mattn/go-sqlite3"
+)
+
+const defaultDriverName = "sqlite3"
+
+func init() {
+	variants["sqlite3"] = variant{
+		dataSourceName: "./db/state.db?_journal=WAL&cache=shared&_busy_timeout=30000&_txlock=immediate",
+		translateErr: func(err error) error {
+			if err, ok := err.(sqlite3.Error); ok && err.ExtendedCode == sqlite3.ErrConstraintUnique {
+				return server.ErrKeyExists
+			}
+			return err
+		},
+		errCode: func(err error) string {
+			if err == nil {
+				return ""
+			}
+			if err, ok := err.(sqlite3.Error); ok {
+				return fmt.Sprint(err.ExtendedCode)
+			}
+			return err.Error()
+		},
+		tableNotExist: func(err error) bool {
+			// sqlite does not have a specific error code for missing tables
+			serr, ok := err.(sqlite3.Error)
+			return ok && serr.Code == sqlite3.ErrError && strings.HasPrefix(serr.Error(), "no such table")
+		},
+	}
+}
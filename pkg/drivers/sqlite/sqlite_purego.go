@@ -0,0 +1,44 @@
+package sqlite
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/k3s-io/kine/pkg/server"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// pureGoDriverName is the database/sql driver name registered by modernc.org/sqlite.
+// Note that this driver uses different DSN parameters than mattn/go-sqlite3; pragmas
+// are set with _pragma, for example "?_pragma=journal_mode(WAL)&_pragma=busy_timeout(30000)".
+const pureGoDriverName = "sqlite"
+
+func init() {
+	variants[pureGoDriverName] = variant{
+		dataSourceName: "./db/state.db?_pragma=journal_mode(WAL)&_pragma=busy_timeout(30000)&_txlock=immediate",
+		translateErr: func(err error) error {
+			var serr *sqlite.Error
+			if errors.As(err, &serr) && serr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE {
+				return server.ErrKeyExists
+			}
+			return err
+		},
+		errCode: func(err error) string {
+			if err == nil {
+				return ""
+			}
+			var serr *sqlite.Error
+			if errors.As(err, &serr) {
+				return fmt.Sprint(serr.Code())
+			}
+			return err.Error()
+		},
+		tableNotExist: func(err error) bool {
+			// sqlite does not have a specific error code for missing tables
+			var serr *sqlite.Error
+			return errors.As(err, &serr) && serr.Code() == sqlite3.SQLITE_ERROR && strings.Contains(serr.Error(), "no such table")
+		},
+	}
+}
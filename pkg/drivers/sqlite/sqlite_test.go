@@ -0,0 +1,816 @@
+package sqlite
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/k3s-io/kine/pkg/drivers"
+	"github.com/k3s-io/kine/pkg/drivers/generic"
+	"github.com/k3s-io/kine/pkg/kinepb"
+	"github.com/k3s-io/kine/pkg/metrics"
+	"github.com/k3s-io/kine/pkg/server"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// testParams are the data source name parameters for test databases, so that concurrent
+// transactions wait for each other rather than failing.
+const testParams = "?_pragma=journal_mode(WAL)&_pragma=busy_timeout(30000)&_txlock=immediate"
+
+func TestNewPureGo(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, b, err := NewPureGo(ctx, &drivers.Config{
+		DataSourceName:   filepath.Join(t.TempDir(), "state.db") + testParams,
+		CompactInterval:  5 * time.Minute,
+		CompactTimeout:   5 * time.Second,
+		CompactMinRetain: 1000,
+		CompactBatchSize: 1000,
+		PollBatchSize:    500,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if err := b.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	rev, err := b.Create(ctx, "/a", []byte("b"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.Create(ctx, "/a", []byte("b"), 0); !errors.Is(err, server.ErrKeyExists) {
+		t.Fatalf("expected %v, got %v", server.ErrKeyExists, err)
+	}
+
+	_, kv, err := b.Get(ctx, "/a", "", 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kv == nil || kv.ModRevision != rev || string(kv.Value) != "b" {
+		t.Fatalf("unexpected key value: %v", kv)
+	}
+
+	if size, err := b.DbSize(ctx); err != nil || size == 0 {
+		t.Fatalf("unexpected db size %d: %v", size, err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	migrations := []generic.Migration{
+		{Version: 2, Description: "create marker", Statements: []string{`CREATE TABLE marker (id INTEGER)`}, Manual: true},
+	}
+	newMigrator := func(t *testing.T) *generic.Migrator {
+		m, err := NewPureGoMigrator(ctx, &drivers.Config{
+			DataSourceName: filepath.Join(t.TempDir(), "state.db"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { m.Close() })
+		m.Migrations = migrations
+		return m
+	}
+	markerExists := func(m *generic.Migrator) bool {
+		_, err := m.DB.Exec(`SELECT 1 FROM marker`)
+		return err == nil
+	}
+	expStatus := func(t *testing.T, m *generic.Migrator, applied ...bool) {
+		t.Helper()
+		status, err := m.Status(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(status) != len(applied) {
+			t.Fatalf("expected %d migrations, got %d", len(applied), len(status))
+		}
+		for i, s := range status {
+			if s.Version != i+1 || s.Applied.IsZero() == applied[i] {
+				t.Fatalf("unexpected status for migration %d: %v", i+1, s)
+			}
+		}
+	}
+
+	t.Run("New", func(t *testing.T) {
+		m := newMigrator(t)
+		pending, err := m.Pending(ctx, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pending) != 2 {
+			t.Fatalf("expected 2 pending migrations, got %d", len(pending))
+		}
+		expStatus(t, m, false, false)
+
+		// the initial schema is the latest revision, so later migrations are not run
+		if err := m.Up(ctx, 1); err != nil {
+			t.Fatal(err)
+		}
+		expStatus(t, m, true, true)
+		if markerExists(m) {
+			t.Fatal("expected migration 2 to be skipped on a new database")
+		}
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		m := newMigrator(t)
+		// reading from the view fails, but not because the table does not exist
+		if _, err := m.DB.Exec(`CREATE VIEW kine AS SELECT 1 AS id WHERE abs(-9223372036854775808) > 0`); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := m.Up(ctx, 1); err == nil || !strings.Contains(err.Error(), "integer overflow") {
+			t.Fatalf("expected error reading existing table, got %v", err)
+		}
+		expStatus(t, m, false, false)
+	})
+
+	t.Run("SchemaError", func(t *testing.T) {
+		m := newMigrator(t)
+		// reading the applied versions fails, but not because the schema table does not exist
+		if _, err := m.DB.Exec(`CREATE VIEW kine_schema AS SELECT 1 AS version, abs(-9223372036854775808) AS applied`); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := m.Status(ctx); err == nil || !strings.Contains(err.Error(), "integer overflow") {
+			t.Fatalf("expected error reading schema versions, got %v", err)
+		}
+		if err := m.Up(ctx, 0); err == nil || !strings.Contains(err.Error(), "integer overflow") {
+			t.Fatalf("expected error reading schema versions, got %v", err)
+		}
+	})
+
+	t.Run("Existing", func(t *testing.T) {
+		m := newMigrator(t)
+		if _, err := m.DB.Exec(schema[0]); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := m.Up(ctx, 1); err != nil {
+			t.Fatal(err)
+		}
+		expStatus(t, m, true, false)
+		if markerExists(m) {
+			t.Fatal("expected migration 2 to be pending")
+		}
+
+		if err := m.Up(ctx, 0); err != nil {
+			t.Fatal(err)
+		}
+		expStatus(t, m, true, true)
+		if !markerExists(m) {
+			t.Fatal("expected migration 2 to be applied")
+		}
+		if applied, err := m.Applied(ctx, 2); err != nil || !applied {
+			t.Fatalf("expected migration 2 to be reported as applied: %v", err)
+		}
+
+		pending, err := m.Pending(ctx, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pending) != 0 {
+			t.Fatalf("expected no pending migrations, got %d", len(pending))
+		}
+	})
+}
+
+func TestTable(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	b, dialect := newTestBackend(t, &drivers.Config{
+		DataSourceName: filepath.Join(t.TempDir(), "state.db") + testParams + "&table=cluster_a",
+	})
+
+	if _, err := b.Create(ctx, "/a", []byte("b"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if size, err := b.DbSize(ctx); err != nil || size == 0 {
+		t.Fatalf("unexpected db size %d: %v", size, err)
+	}
+
+	rows, err := dialect.DB.Query(`SELECT name FROM sqlite_master WHERE type IN ('table', 'index') AND name NOT LIKE 'sqlite_%'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(name, "cluster_a") {
+			t.Errorf("unexpected table or index %q", name)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListAll(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	b, _ := newTestBackend(t, nil)
+
+	for _, key := range []string{"/a", "b"} {
+		if _, err := b.Create(ctx, key, []byte("v"), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// an empty prefix lists all keys, except for the compact revision key
+	var keys []string
+	startKey := ""
+	for {
+		_, kvs, err := b.List(ctx, "", startKey, 1, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(kvs) == 0 {
+			break
+		}
+		keys = append(keys, kvs[0].Key)
+		startKey = kvs[0].Key
+	}
+	if expected := []string{"/a", "/registry/health", "b"}; !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("expected keys %v, got %v", expected, keys)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	for _, algorithm := range []string{"s2", "zstd"} {
+		t.Run(algorithm, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			b, dialect := newTestBackend(t, nil)
+
+			large := []byte(strings.Repeat("compressible ", 1000))
+
+			// rows written before compression is enabled must remain readable
+			rev, err := b.Create(ctx, "/uncompressed", large, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := dialect.ConfigureCompression(generic.CompressionConfig{Algorithm: algorithm, MinSize: 100}); err != nil {
+				t.Fatal(err)
+			}
+			smallRev, err := b.Create(ctx, "/small", []byte("small"), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			createRev, err := b.Create(ctx, "/compressed", large, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			updated := append([]byte("updated "), large...)
+			updateRev, _, ok, err := b.Update(ctx, "/compressed", updated, createRev, 0)
+			if err != nil || !ok {
+				t.Fatalf("update failed: %v", err)
+			}
+
+			expCompression := map[int64]int64{rev: 0, smallRev: 0, createRev: 1, updateRev: 1}
+			for r, exp := range expCompression {
+				var compression sql.NullInt64
+				if err := dialect.DB.QueryRow(`SELECT compression FROM kine WHERE id = ?`, r).Scan(&compression); err != nil {
+					t.Fatal(err)
+				}
+				if (compression.Int64 != 0) != (exp != 0) {
+					t.Errorf("unexpected compression %d for revision %d", compression.Int64, r)
+				}
+			}
+
+			for key, exp := range map[string][]byte{"/uncompressed": large, "/small": []byte("small"), "/compressed": updated} {
+				_, kv, err := b.Get(ctx, key, "", 1, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if kv == nil || !bytes.Equal(kv.Value, exp) {
+					t.Errorf("unexpected value for %s", key)
+				}
+			}
+
+			// the previous value is sent to watchers, and must also be decompressed
+			events := b.Watch(ctx, "/compressed", updateRev)
+			select {
+			case result := <-events.Events:
+				if len(result) != 1 || result[0].PrevKV == nil || !bytes.Equal(result[0].PrevKV.Value, large) || !bytes.Equal(result[0].KV.Value, updated) {
+					t.Errorf("unexpected watch events: %v", result)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for watch events")
+			}
+		})
+	}
+}
+
+func TestOmitOldValue(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	b, dialect := newTestBackend(t, nil)
+
+	// new databases do not store previous values
+	if !dialect.OmitOldValue {
+		t.Fatal("expected previous values to be omitted on a new database")
+	}
+
+	// rows written before the migration store the previous value
+	dialect.OmitOldValue = false
+	createRev, err := b.Create(ctx, "/a", []byte("1"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storedRev, _, ok, err := b.Update(ctx, "/a", []byte("2"), createRev, 0)
+	if err != nil || !ok {
+		t.Fatalf("update failed: %v", err)
+	}
+
+	dialect.OmitOldValue = true
+	omittedRev, _, ok, err := b.Update(ctx, "/a", []byte("3"), storedRev, 0)
+	if err != nil || !ok {
+		t.Fatalf("update failed: %v", err)
+	}
+	deleteRev, _, ok, err := b.Delete(ctx, "/a", omittedRev)
+	if err != nil || !ok {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	for rev, stored := range map[int64]bool{storedRev: true, omittedRev: false, deleteRev: false} {
+		var oldValue []byte
+		if err := dialect.DB.QueryRow(`SELECT old_value FROM kine WHERE id = ?`, rev).Scan(&oldValue); err != nil {
+			t.Fatal(err)
+		}
+		if (oldValue != nil) != stored {
+			t.Errorf("unexpected old value %q for revision %d", oldValue, rev)
+		}
+	}
+
+	// previous values are resolved from the previous revision for watchers
+	events := b.Watch(ctx, "/a", storedRev)
+	var result []*server.Event
+	for len(result) < 3 {
+		select {
+		case e := <-events.Events:
+			result = append(result, e...)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for watch events")
+		}
+	}
+	for i, exp := range []string{"1", "2", "3"} {
+		if result[i].PrevKV == nil || string(result[i].PrevKV.Value) != exp {
+			t.Errorf("unexpected previous value for event %d: %v", i, result[i].PrevKV)
+		}
+	}
+}
+
+func TestRetention(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rules, err := generic.ParseRetentionRules([]string{"/registry/=0s", "/registry/secrets/=24h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &drivers.Config{}
+	cfg.RetentionConfig.Rules = rules
+	b, dialect := newTestBackend(t, cfg)
+
+	revs := map[string]int64{}
+	for _, key := range []string{"/registry/secrets/a", "/registry/events/a", "/other/a"} {
+		rev, err := b.Create(ctx, key, []byte("1"), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if revs[key], _, _, err = b.Update(ctx, key, []byte("2"), rev, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rev := revs["/other/a"]
+
+	report, err := dialect.CompactReport(ctx, rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for prefix, exp := range map[string]int64{"/registry/secrets/": 0, "/registry/": 1, "": 1} {
+		if report[prefix] != exp {
+			t.Errorf("expected %d rows for %q, got %d", exp, prefix, report[prefix])
+		}
+	}
+
+	// the replaced secret is retained until its replacement is older than the rule's age
+	deleted, err := dialect.Compact(ctx, rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 2 {
+		t.Fatalf("expected 2 rows deleted, got %d", deleted)
+	}
+
+	if _, err := dialect.DB.Exec(`UPDATE kine SET created_at = ? WHERE id = ?`, time.Now().Add(-25*time.Hour).Unix(), revs["/registry/secrets/a"]); err != nil {
+		t.Fatal(err)
+	}
+	deleted, err = dialect.Compact(ctx, rev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 1 {
+		t.Fatalf("expected 1 row deleted, got %d", deleted)
+	}
+}
+
+func TestCompactLeader(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, dialect := newTestBackend(t, &drivers.Config{
+		DataSourceName: filepath.Join(t.TempDir(), "state.db") + testParams + "&table=cluster_a",
+	})
+
+	// a second server sharing the database
+	other := generic.NewLeaseElector(dialect.DB, dialect.Table, "?", false)
+	expLeader := func(t *testing.T, e generic.Elector, ttl time.Duration, exp bool) {
+		t.Helper()
+		leader, err := e.Elect(ctx, ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if leader != exp {
+			t.Fatalf("expected leader %v, got %v", exp, leader)
+		}
+	}
+
+	expLeader(t, dialect.CompactElector, time.Minute, true)
+	expLeader(t, other, time.Minute, false)
+	// the leader renews its lease
+	expLeader(t, dialect.CompactElector, time.Minute, true)
+
+	// the lease is released on close, and taken over by the other server
+	if err := dialect.CompactElector.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expLeader(t, other, -time.Second, true)
+
+	// an expired lease is taken over
+	expLeader(t, dialect.CompactElector, time.Minute, true)
+	expLeader(t, other, time.Minute, false)
+}
+
+func TestPollRevision(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := &drivers.Config{}
+	_, dialect := newTestBackend(t, cfg)
+
+	// a second server sharing the database, which is closed by the test
+	ob, other, err := NewVariant(ctx, pureGoDriverName, &drivers.Config{DataSourceName: cfg.DataSourceName})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !dialect.PollRevisions {
+		t.Fatal("expected poll revisions to be recorded on a new database")
+	}
+
+	expMin := func(t *testing.T, d *generic.Generic, exp int64) {
+		t.Helper()
+		rev, err := d.MinPollRevision(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rev != exp {
+			t.Fatalf("expected minimum poll revision %d, got %d", exp, rev)
+		}
+	}
+
+	// each server only sees the poll revisions of other servers
+	if err := dialect.SetPollRevision(ctx, 5, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.SetPollRevision(ctx, 3, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	expMin(t, dialect, 3)
+	expMin(t, other, 5)
+
+	// expired poll revisions are ignored
+	if err := dialect.SetPollRevision(ctx, 5, -time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	expMin(t, other, 0)
+
+	// the poll revision is removed when the server is closed
+	if err := ob.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expMin(t, dialect, 0)
+}
+
+func TestRevisionAt(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	b, _ := newTestBackend(t, nil)
+
+	rev, err := b.Create(ctx, "/a", []byte("b"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bridge := server.New(b, "sqlite", 0, "")
+	for _, test := range []struct {
+		time time.Time
+		rev  int64
+	}{
+		{time: time.Unix(0, 0), rev: 0},
+		{time: time.Now().Add(time.Hour), rev: rev},
+	} {
+		resp, err := bridge.RevisionAt(ctx, &kinepb.RevisionAtRequest{Time: timestamppb.New(test.time)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Revision != test.rev {
+			t.Errorf("unexpected revision at %v: %v", test.time, resp)
+		}
+	}
+}
+
+func TestKeyHistory(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	b, _ := newTestBackend(t, nil)
+
+	rev, err := b.Create(ctx, "/a_b", []byte("1"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := b.Update(ctx, "/a_b", []byte("2"), rev, 0); err != nil {
+		t.Fatal(err)
+	}
+	// matches the underscore in /a_b as a LIKE wildcard, but must not be returned
+	if _, err := b.Create(ctx, "/axb", []byte("1"), 0); err != nil {
+		t.Fatal(err)
+	}
+	cdRev, err := b.Create(ctx, "/c_d", []byte("1"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// written between revisions of /c_d, so that it would take up the limit if the key were
+	// matched with LIKE
+	if _, err := b.Create(ctx, "/cxd", []byte("1"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := b.Update(ctx, "/c_d", []byte("2"), cdRev, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	bridge := server.New(b, "sqlite", 0, "")
+	for _, test := range []struct {
+		req    *kinepb.KeyHistoryRequest
+		values []string
+		more   bool
+	}{
+		{req: &kinepb.KeyHistoryRequest{Key: []byte("/a_b")}, values: []string{"1", "2"}},
+		{req: &kinepb.KeyHistoryRequest{Key: []byte("/a_b"), Limit: 1}, values: []string{"1"}, more: true},
+		{req: &kinepb.KeyHistoryRequest{Key: []byte("/a_b"), StartRevision: rev + 1}, values: []string{"2"}},
+		{req: &kinepb.KeyHistoryRequest{Key: []byte("/a"), Prefix: true, StartRevision: rev + 1}, values: []string{"2", "1"}},
+		{req: &kinepb.KeyHistoryRequest{Key: []byte("/c_d"), Limit: 1}, values: []string{"1"}, more: true},
+		{req: &kinepb.KeyHistoryRequest{Key: []byte("/c_"), Prefix: true, StartRevision: cdRev, Limit: 1}, values: []string{"1"}, more: true},
+		{req: &kinepb.KeyHistoryRequest{Key: []byte("/c_"), Prefix: true, StartRevision: cdRev, Limit: 2}, values: []string{"1", "2"}},
+	} {
+		resp, err := bridge.KeyHistory(ctx, test.req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var values []string
+		for _, kv := range resp.Revisions {
+			values = append(values, string(kv.Value))
+		}
+		if !reflect.DeepEqual(values, test.values) || resp.More != test.more {
+			t.Errorf("unexpected history for %v: %v", test.req, resp)
+		}
+	}
+}
+
+func TestReplica(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	b, dialect := newTestBackend(t, nil)
+
+	for _, key := range []string{"/k/a", "/k/b"} {
+		if _, err := b.Create(ctx, key, []byte("v"), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the replica is a copy of the database that has not replicated the last key
+	replicaFile := filepath.Join(t.TempDir(), "replica.db")
+	if _, err := dialect.DB.ExecContext(ctx, "VACUUM INTO ?", replicaFile); err != nil {
+		t.Fatal(err)
+	}
+	replicaRev, err := b.CurrentRevision(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rev, err := b.Create(ctx, "/k/c", []byte("v"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dialect.OpenReplica(ctx, pureGoDriverName, replicaFile, generic.ConnectionPoolConfig{}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if lag := testutil.ToFloat64(metrics.ReplicaLag); lag != float64(rev-replicaRev) {
+		t.Errorf("expected replica lag %d, got %v", rev-replicaRev, lag)
+	}
+
+	for _, test := range []struct {
+		name         string
+		serializable bool
+		revision     int64
+		keys         int
+	}{
+		{name: "current", keys: 3},
+		{name: "serializable", serializable: true, keys: 2},
+		{name: "replicated revision", revision: replicaRev, keys: 2},
+		{name: "unreplicated revision", revision: rev, keys: 3},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := ctx
+			if test.serializable {
+				ctx = server.WithSerializable(ctx)
+			}
+			_, kvs, err := b.List(ctx, "/k/", "", 0, test.revision)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(kvs) != test.keys {
+				t.Errorf("expected %d keys, got %d", test.keys, len(kvs))
+			}
+		})
+	}
+}
+
+func TestFailover(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		// demoted is set if the previous writer is still running as a replica, so that the
+		// next write finds the database read-only; otherwise the previous writer has failed,
+		// and connections are made to the new writer without any write failing
+		demoted bool
+	}{
+		{name: "demoted writer", demoted: true},
+		{name: "failed writer"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			b, dialect := newTestBackend(t, nil)
+
+			// the next write error is treated as if the database were read-only
+			var readOnly atomic.Bool
+			dialect.ReadOnly = func(error) bool {
+				return readOnly.CompareAndSwap(true, false)
+			}
+
+			// polling starts with the first watch, so wait for the watch to receive the last write
+			wr := b.Watch(ctx, "/k/", 0)
+			var (
+				rev int64
+				err error
+			)
+			for _, key := range []string{"/k/a", "/k/b", "/k/c"} {
+				if rev, err = b.Create(ctx, key, []byte("v"), 0); err != nil {
+					t.Fatal(err)
+				}
+			}
+			timeout := time.After(5 * time.Second)
+			for polled := int64(0); polled < rev; {
+				select {
+				case events := <-wr.Events:
+					polled = events[len(events)-1].KV.ModRevision
+				case <-timeout:
+					t.Fatal("timed out waiting for poll to reach the last revision")
+				}
+			}
+
+			// simulate failing over to a writer that has lost the last two writes
+			if _, err := dialect.DB.ExecContext(ctx, "DELETE FROM kine WHERE id > ?", rev-2); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := dialect.DB.ExecContext(ctx, "UPDATE sqlite_sequence SET seq = ? WHERE name = 'kine'", rev-2); err != nil {
+				t.Fatal(err)
+			}
+			if test.demoted {
+				readOnly.Store(true)
+				if err := dialect.Fill(ctx, 1); err == nil {
+					t.Fatal("expected error filling existing revision")
+				}
+				if failovers := dialect.Failovers(); failovers != 1 {
+					t.Fatalf("expected 1 failover, got %d", failovers)
+				}
+			}
+
+			// revisions already sent to watches must not be reused
+			waitFor(t, "revision to be advanced", func() bool {
+				current, err := dialect.CurrentRevision(ctx)
+				return err == nil && current == rev
+			})
+			next, err := b.Create(ctx, "/k/d", []byte("v"), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if next <= rev {
+				t.Fatalf("expected revision after %d, got %d", rev, next)
+			}
+
+			// and writes to the new writer are sent to watches
+			timeout = time.After(5 * time.Second)
+			for polled := int64(0); polled < next; {
+				select {
+				case events := <-wr.Events:
+					polled = events[len(events)-1].KV.ModRevision
+				case <-timeout:
+					t.Fatal("timed out waiting for poll to reach the write to the new writer")
+				}
+			}
+		})
+	}
+}
+
+// newTestBackend returns a started backend using the pure-Go driver, along with its dialect. The
+// database is created in a temporary directory unless the data source name is set, and unset
+// compaction and poll settings are defaulted; the configuration is updated in place. The backend
+// is closed when the test completes.
+func newTestBackend(t *testing.T, cfg *drivers.Config) (server.Backend, *generic.Generic) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	if cfg == nil {
+		cfg = &drivers.Config{}
+	}
+	if cfg.DataSourceName == "" {
+		cfg.DataSourceName = filepath.Join(t.TempDir(), "state.db") + testParams
+	}
+	if cfg.CompactInterval == 0 {
+		cfg.CompactInterval = 5 * time.Minute
+	}
+	if cfg.CompactTimeout == 0 {
+		cfg.CompactTimeout = 5 * time.Second
+	}
+	if cfg.CompactMinRetain == 0 {
+		cfg.CompactMinRetain = 1000
+	}
+	if cfg.CompactBatchSize == 0 {
+		cfg.CompactBatchSize = 1000
+	}
+	if cfg.PollBatchSize == 0 {
+		cfg.PollBatchSize = 500
+	}
+
+	b, dialect, err := NewVariant(ctx, pureGoDriverName, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+	if err := b.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return b, dialect
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for !condition() {
+		select {
+		case <-timeout:
+			t.Fatalf("timed out waiting for %s", what)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package encryption
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/k3s-io/kine/pkg/server"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	watchBufferSize   = 100
+	reencryptPageSize = 500
+)
+
+// explicit interface checks
+var (
+	_ server.Backend               = (*Backend)(nil)
+	_ server.HistoryBackend        = (*Backend)(nil)
+	_ server.CapabilitiesBackend   = (*Backend)(nil)
+	_ server.ManagedCompactBackend = (*Backend)(nil)
+)
+
+// Backend is a server.Backend that encrypts values before they are passed to another
+// backend, and decrypts them when they are read. Keys, revisions and leases are not
+// encrypted. Since encrypted values do not compress, encryption should not be combined
+// with driver-level value compression.
+//
+// When the backend is started, and periodically afterwards, values that are stored in
+// plaintext or encrypted with a key other than the primary key are re-encrypted with the
+// primary key. Once all values have been re-encrypted, older keys can be removed from the
+// configuration.
+type Backend struct {
+	backend           server.Backend
+	transformer       *Transformer
+	reencryptInterval time.Duration
+}
+
+// NewBackend returns a backend that encrypts values stored in the provided backend.
+// Values are re-encrypted at the given interval; if the interval is zero, values are
+// only re-encrypted at startup.
+func NewBackend(backend server.Backend, transformer *Transformer, reencryptInterval time.Duration) *Backend {
+	return &Backend{
+		backend:           backend,
+		transformer:       transformer,
+		reencryptInterval: reencryptInterval,
+	}
+}
+
+// Start starts the wrapped backend, and then the background re-encryption job.
+func (b *Backend) Start(ctx context.Context) error {
+	if err := b.backend.Start(ctx); err != nil {
+		return err
+	}
+	go b.reencryptLoop(ctx)
+	return nil
+}
+
+// Close closes the wrapped backend, and then any connections to KMS plugins.
+func (b *Backend) Close() error {
+	err := b.backend.Close()
+	if terr := b.transformer.Close(); err == nil {
+		err = terr
+	}
+	return err
+}
+
+func (b *Backend) Get(ctx context.Context, key, rangeEnd string, limit, revision int64) (int64, *server.KeyValue, error) {
+	rev, kv, err := b.backend.Get(ctx, key, rangeEnd, limit, revision)
+	if err != nil {
+		return rev, kv, err
+	}
+	kv, err = b.decrypt(ctx, kv)
+	return rev, kv, err
+}
+
+func (b *Backend) Create(ctx context.Context, key string, value []byte, lease int64) (int64, error) {
+	value, err := b.transformer.Encrypt(ctx, value)
+	if err != nil {
+		return 0, err
+	}
+	return b.backend.Create(ctx, key, value, lease)
+}
+
+func (b *Backend) Delete(ctx context.Context, key string, revision int64) (int64, *server.KeyValue, bool, error) {
+	rev, kv, deleted, err := b.backend.Delete(ctx, key, revision)
+	if err != nil {
+		return rev, kv, deleted, err
+	}
+	kv, err = b.decrypt(ctx, kv)
+	return rev, kv, deleted, err
+}
+
+func (b *Backend) List(ctx context.Context, prefix, startKey string, limit, revision int64) (int64, []*server.KeyValue, error) {
+	rev, kvs, err := b.backend.List(ctx, prefix, startKey, limit, revision)
+	if err != nil {
+		return rev, kvs, err
+	}
+	result := make([]*server.KeyValue, 0, len(kvs))
+	for _, kv := range kvs {
+		kv, err := b.decrypt(ctx, kv)
+		if err != nil {
+			return rev, nil, err
+		}
+		result = append(result, kv)
+	}
+	return rev, result, nil
+}
+
+func (b *Backend) Count(ctx context.Context, prefix, startKey string, revision int64) (int64, int64, error) {
+	return b.backend.Count(ctx, prefix, startKey, revision)
+}
+
+func (b *Backend) Update(ctx context.Context, key string, value []byte, revision, lease int64) (int64, *server.KeyValue, bool, error) {
+	value, err := b.transformer.Encrypt(ctx, value)
+	if err != nil {
+		return 0, nil, false, err
+	}
+	rev, kv, updated, err := b.backend.Update(ctx, key, value, revision, lease)
+	if err != nil {
+		return rev, kv, updated, err
+	}
+	kv, err = b.decrypt(ctx, kv)
+	return rev, kv, updated, err
+}
+
+// Watch decrypts the values of events from the wrapped backend. If a value cannot be
+// decrypted, the error is returned to the caller and the watch is closed.
+func (b *Backend) Watch(ctx context.Context, key string, revision int64) server.WatchResult {
+	ctx, cancel := context.WithCancel(ctx)
+	wr := b.backend.Watch(ctx, key, revision)
+
+	result := make(chan []*server.Event, watchBufferSize)
+	errc := make(chan error, 1)
+	inner := wr.Events
+	innerErrc := wr.Errorc
+	wr.Events = result
+	wr.Errorc = errc
+
+	go func() {
+		defer close(result)
+		defer cancel()
+		for events := range inner {
+			decrypted, err := b.decryptEvents(ctx, events)
+			if err != nil {
+				errc <- err
+			}
+			if err == nil {
+				select {
+				case result <- decrypted:
+					continue
+				case <-ctx.Done():
+				}
+			}
+			// drain the wrapped watch so that it can exit
+			cancel()
+			for range inner {
+			}
+			return
+		}
+		if innerErrc != nil {
+			select {
+			case err := <-innerErrc:
+				errc <- err
+			default:
+			}
+		}
+	}()
+
+	return wr
+}
+
+func (b *Backend) DbSize(ctx context.Context) (int64, error) {
+	return b.backend.DbSize(ctx)
+}
+
+func (b *Backend) CurrentRevision(ctx context.Context) (int64, error) {
+	return b.backend.CurrentRevision(ctx)
+}
+
+func (b *Backend) Compact(ctx context.Context, revision int64) (int64, error) {
+	return b.backend.Compact(ctx, revision)
+}
+
+func (b *Backend) ManagedCompact(ctx context.Context, revision int64) (int64, error) {
+	m, ok := b.backend.(server.ManagedCompactBackend)
+	if !ok {
+		return 0, server.ErrManagedCompactNotSupported
+	}
+	return m.ManagedCompact(ctx, revision)
+}
+
+func (b *Backend) RevisionAt(ctx context.Context, t time.Time) (int64, error) {
+	history, ok := b.backend.(server.HistoryBackend)
+	if !ok {
+		return 0, server.ErrHistoryNotSupported
+	}
+	return history.RevisionAt(ctx, t)
+}
+
+func (b *Backend) History(ctx context.Context, key string, prefix bool, startRevision, endRevision, limit int64) (int64, []*server.Event, error) {
+	history, ok := b.backend.(server.HistoryBackend)
+	if !ok {
+		return 0, nil, server.ErrHistoryNotSupported
+	}
+	rev, events, err := history.History(ctx, key, prefix, startRevision, endRevision, limit)
+	if err != nil {
+		return rev, nil, err
+	}
+	events, err = b.decryptEvents(ctx, events)
+	return rev, events, err
+}
+
+func (b *Backend) Capabilities() server.Capabilities {
+	var capabilities server.Capabilities
+	if c, ok := b.backend.(server.CapabilitiesBackend); ok {
+		capabilities = c.Capabilities()
+	}
+	capabilities.Encryption = true
+	return capabilities
+}
+
+// decrypt returns a copy of the key-value with the value decrypted. The original is not
+// modified, as it may be shared with other callers.
+func (b *Backend) decrypt(ctx context.Context, kv *server.KeyValue) (*server.KeyValue, error) {
+	if kv == nil {
+		return nil, nil
+	}
+	value, _, err := b.transformer.Decrypt(ctx, kv.Value)
+	if err != nil {
+		return nil, err
+	}
+	result := *kv
+	result.Value = value
+	return &result, nil
+}
+
+func (b *Backend) decryptEvents(ctx context.Context, events []*server.Event) ([]*server.Event, error) {
+	result := make([]*server.Event, 0, len(events))
+	for _, event := range events {
+		kv, err := b.decrypt(ctx, event.KV)
+		if err != nil {
+			return nil, err
+		}
+		prevKV, err := b.decrypt(ctx, event.PrevKV)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, &server.Event{
+			Delete: event.Delete,
+			Create: event.Create,
+			KV:     kv,
+			PrevKV: prevKV,
+		})
+	}
+	return result, nil
+}
+
+func (b *Backend) reencryptLoop(ctx context.Context) {
+	b.reencryptAll(ctx)
+	if b.reencryptInterval <= 0 {
+		return
+	}
+
+	t := time.NewTicker(b.reencryptInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			b.reencryptAll(ctx)
+		}
+	}
+}
+
+// reencryptAll re-encrypts stale values with the primary key, logging the outcome.
+func (b *Backend) reencryptAll(ctx context.Context) {
+	count, leased, err := b.reencrypt(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			logrus.Errorf("Failed to re-encrypt values: %v", err)
+		}
+		return
+	}
+	if count > 0 {
+		logrus.Infof("Re-encrypted %d values with the primary encryption key", count)
+	}
+	if leased > 0 {
+		logrus.Infof("Skipped re-encrypting %d values with leases; they are re-encrypted when next written, or removed when their lease expires", leased)
+	}
+}
+
+// reencrypt lists all keys in pages, and updates any that are stored in plaintext or
+// encrypted with a key other than the primary key. Updates are conditional on the key's
+// revision, so values modified since they were listed are skipped, as they have already
+// been written with the primary key. Keys with leases are skipped, as updating them would
+// restart their TTL; the number of stale values skipped for this reason is returned along
+// with the number of values re-encrypted.
+func (b *Backend) reencrypt(ctx context.Context) (int, int, error) {
+	var (
+		count    int
+		leased   int
+		startKey string
+		revision int64
+	)
+	for {
+		rev, kvs, err := b.backend.List(ctx, "", startKey, reencryptPageSize, revision)
+		if errors.Is(err, server.ErrCompacted) {
+			// the revision the list started at has been compacted; continue from
+			// the current revision.
+			revision = 0
+			continue
+		} else if err != nil {
+			return count, leased, err
+		}
+		revision = rev
+
+		for _, kv := range kvs {
+			value, stale, err := b.transformer.Decrypt(ctx, kv.Value)
+			if err != nil {
+				return count, leased, err
+			}
+			if !stale {
+				continue
+			}
+			if kv.Lease != 0 {
+				leased++
+				continue
+			}
+			value, err = b.transformer.Encrypt(ctx, value)
+			if err != nil {
+				return count, leased, err
+			}
+			_, _, updated, err := b.backend.Update(ctx, kv.Key, value, kv.ModRevision, 0)
+			if err != nil {
+				return count, leased, err
+			}
+			if updated {
+				count++
+			}
+		}
+
+		// backends may return short pages, or include the start key in the page, so listing
+		// continues until a page has no keys after the start key
+		if len(kvs) == 0 || kvs[len(kvs)-1].Key <= startKey {
+			return count, leased, nil
+		}
+		startKey = kvs[len(kvs)-1].Key
+	}
+}
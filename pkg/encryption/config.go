@@ -0,0 +1,113 @@
+package encryption
+
+import (
+	"encoding/base64"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+)
+
+const defaultKMSTimeout = 3 * time.Second
+
+// BackendConfig configures encryption of values stored by the backend.
+type BackendConfig struct {
+	// ConfigFile is the path to the encryption config file. Values are stored
+	// unencrypted if it is not set.
+	ConfigFile string
+	// ReencryptInterval is the interval at which values not encrypted with the
+	// primary key are re-encrypted.
+	ReencryptInterval time.Duration
+}
+
+// Config lists the keys used to encrypt values. The first key is the primary key, and is
+// used to encrypt new values; all keys can be used to decrypt existing values. Keys are
+// identified by name in stored values, so a key must not be renamed while values encrypted
+// with it remain in the datastore.
+type Config struct {
+	Keys []KeyConfig `json:"keys"`
+}
+
+// KeyConfig configures a single key encryption key. Exactly one of Secret or KMS must be set.
+type KeyConfig struct {
+	// Name identifies the key in stored values.
+	Name string `json:"name"`
+	// Secret is a base64-encoded 16, 24 or 32 byte AES key.
+	Secret string `json:"secret,omitempty"`
+	// KMS configures a Kubernetes KMS v2 plugin that holds the key.
+	KMS *KMSConfig `json:"kms,omitempty"`
+}
+
+// KMSConfig configures a connection to a Kubernetes KMS v2 plugin.
+type KMSConfig struct {
+	// Endpoint is the address of the plugin socket, for example unix:///var/run/kms.sock
+	Endpoint string `json:"endpoint"`
+	// Timeout is the timeout for requests to the plugin. Defaults to 3s.
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// LoadConfig reads the encryption config from a YAML or JSON file.
+func LoadConfig(path string) (Config, error) {
+	config := Config{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, errors.Wrap(err, "reading encryption config")
+	}
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return config, errors.Wrap(err, "parsing encryption config")
+	}
+	return config, nil
+}
+
+// keyEncrypters returns the key encrypters for the configured keys, in order.
+func (c Config) keyEncrypters() ([]keyEncrypter, error) {
+	if len(c.Keys) == 0 {
+		return nil, errors.New("at least one encryption key must be configured")
+	}
+
+	var encrypters []keyEncrypter
+	names := map[string]bool{}
+	for _, key := range c.Keys {
+		if key.Name == "" || strings.Contains(key.Name, ":") {
+			return nil, errors.Errorf("invalid encryption key name %q", key.Name)
+		}
+		if names[key.Name] {
+			return nil, errors.Errorf("duplicate encryption key name %q", key.Name)
+		}
+		names[key.Name] = true
+
+		var (
+			encrypter keyEncrypter
+			err       error
+		)
+		switch {
+		case key.Secret != "" && key.KMS != nil:
+			err = errors.New("only one of secret or kms may be set")
+		case key.Secret != "":
+			var secret []byte
+			if secret, err = base64.StdEncoding.DecodeString(key.Secret); err == nil {
+				encrypter, err = newAESGCMKey(key.Name, secret)
+			}
+		case key.KMS != nil:
+			timeout := defaultKMSTimeout
+			if key.KMS.Timeout != "" {
+				timeout, err = time.ParseDuration(key.KMS.Timeout)
+			}
+			if err == nil {
+				encrypter, err = newKMSKey(key.Name, key.KMS.Endpoint, timeout)
+			}
+		default:
+			err = errors.New("one of secret or kms must be set")
+		}
+		if err != nil {
+			for _, e := range encrypters {
+				e.close()
+			}
+			return nil, errors.Wrapf(err, "configuring encryption key %q", key.Name)
+		}
+		encrypters = append(encrypters, encrypter)
+	}
+	return encrypters, nil
+}
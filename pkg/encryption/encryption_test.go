@@ -0,0 +1,307 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/k3s-io/kine/pkg/drivers/memory"
+	"github.com/k3s-io/kine/pkg/logstructured"
+	"github.com/k3s-io/kine/pkg/server"
+	"google.golang.org/grpc"
+	kmsapi "k8s.io/kms/apis/v2"
+)
+
+func noErr(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expEqual[T comparable](t *testing.T, want, got T) {
+	t.Helper()
+	if got != want {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+// compactBackend records the revision that it is asked to compact to through its compactor.
+type compactBackend struct {
+	server.Backend
+	revision int64
+}
+
+func (c *compactBackend) ManagedCompact(ctx context.Context, revision int64) (int64, error) {
+	c.revision = revision
+	return revision, nil
+}
+
+func secret(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+func newTestTransformer(t *testing.T, keys ...KeyConfig) *Transformer {
+	t.Helper()
+	tr, err := NewTransformer(Config{Keys: keys})
+	noErr(t, err)
+	t.Cleanup(func() { tr.Close() })
+	return tr
+}
+
+func TestTransformer(t *testing.T) {
+	ctx := context.Background()
+	oldKey := KeyConfig{Name: "old", Secret: secret(1)}
+	newKey := KeyConfig{Name: "new", Secret: secret(2)}
+
+	old := newTestTransformer(t, oldKey)
+	enc, err := old.Encrypt(ctx, []byte("value"))
+	noErr(t, err)
+	if bytes.Contains(enc, []byte("value")) {
+		t.Fatalf("encrypted value contains plaintext: %q", enc)
+	}
+
+	value, stale, err := old.Decrypt(ctx, enc)
+	noErr(t, err)
+	expEqual(t, "value", string(value))
+	expEqual(t, false, stale)
+
+	// data keys are reused
+	enc2, err := old.Encrypt(ctx, []byte("value"))
+	noErr(t, err)
+	if bytes.Equal(enc, enc2) {
+		t.Fatal("expected values encrypted with a unique nonce")
+	}
+	_, wrapped, _, err := decodeHeader(enc)
+	noErr(t, err)
+	_, wrapped2, _, err := decodeHeader(enc2)
+	noErr(t, err)
+	if !bytes.Equal(wrapped.ciphertext, wrapped2.ciphertext) {
+		t.Fatal("expected data key to be reused")
+	}
+
+	// values encrypted with a secondary key are decrypted, and reported as stale
+	rotated := newTestTransformer(t, newKey, oldKey)
+	value, stale, err = rotated.Decrypt(ctx, enc)
+	noErr(t, err)
+	expEqual(t, "value", string(value))
+	expEqual(t, true, stale)
+
+	// plaintext values are returned unchanged, and reported as stale
+	value, stale, err = rotated.Decrypt(ctx, []byte("plain"))
+	noErr(t, err)
+	expEqual(t, "plain", string(value))
+	expEqual(t, true, stale)
+
+	// values encrypted with an unknown key cannot be decrypted
+	enc, err = rotated.Encrypt(ctx, []byte("value"))
+	noErr(t, err)
+	if _, _, err := old.Decrypt(ctx, enc); err == nil {
+		t.Fatal("expected error decrypting value with unknown key")
+	}
+
+	// tampered values cannot be decrypted
+	enc[len(enc)-1] ^= 0xff
+	if _, _, err := rotated.Decrypt(ctx, enc); err == nil {
+		t.Fatal("expected error decrypting modified value")
+	}
+}
+
+func TestConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "encryption.yaml")
+	noErr(t, os.WriteFile(path, []byte("keys:\n- name: a\n  secret: "+secret(1)+"\n"), 0600))
+	config, err := LoadConfig(path)
+	noErr(t, err)
+	expEqual(t, 1, len(config.Keys))
+	expEqual(t, "a", config.Keys[0].Name)
+
+	for name, config := range map[string]Config{
+		"no keys":        {},
+		"duplicate name": {Keys: []KeyConfig{{Name: "a", Secret: secret(1)}, {Name: "a", Secret: secret(2)}}},
+		"invalid name":   {Keys: []KeyConfig{{Name: "a:b", Secret: secret(1)}}},
+		"invalid secret": {Keys: []KeyConfig{{Name: "a", Secret: "c2hvcnQ="}}},
+		"no secret":      {Keys: []KeyConfig{{Name: "a"}}},
+		"tcp kms":        {Keys: []KeyConfig{{Name: "a", KMS: &KMSConfig{Endpoint: "tcp://localhost:1234"}}}},
+	} {
+		if _, err := NewTransformer(config); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+// fakeKMS is a KMS v2 plugin that encrypts data keys by xor with a fixed byte.
+type fakeKMS struct {
+	kmsapi.UnimplementedKeyManagementServiceServer
+}
+
+func (*fakeKMS) Encrypt(_ context.Context, req *kmsapi.EncryptRequest) (*kmsapi.EncryptResponse, error) {
+	return &kmsapi.EncryptResponse{
+		Ciphertext:  xor(req.Plaintext),
+		KeyId:       "fake-key",
+		Annotations: map[string][]byte{"fake.kine.io": []byte("annotation")},
+	}, nil
+}
+
+func (*fakeKMS) Decrypt(_ context.Context, req *kmsapi.DecryptRequest) (*kmsapi.DecryptResponse, error) {
+	if req.KeyId != "fake-key" || string(req.Annotations["fake.kine.io"]) != "annotation" {
+		return nil, os.ErrInvalid
+	}
+	return &kmsapi.DecryptResponse{Plaintext: xor(req.Ciphertext)}, nil
+}
+
+func xor(b []byte) []byte {
+	out := make([]byte, len(b))
+	for i := range b {
+		out[i] = b[i] ^ 0x5a
+	}
+	return out
+}
+
+func TestKMS(t *testing.T) {
+	dir, err := os.MkdirTemp("", "kms")
+	noErr(t, err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "kms.sock")
+
+	listener, err := net.Listen("unix", socket)
+	noErr(t, err)
+	s := grpc.NewServer()
+	kmsapi.RegisterKeyManagementServiceServer(s, &fakeKMS{})
+	go s.Serve(listener)
+	defer s.Stop()
+
+	ctx := context.Background()
+	tr := newTestTransformer(t, KeyConfig{Name: "kms", KMS: &KMSConfig{Endpoint: "unix://" + socket, Timeout: "5s"}})
+	enc, err := tr.Encrypt(ctx, []byte("value"))
+	noErr(t, err)
+
+	// decrypt with a new transformer, so that the data key is not cached
+	tr = newTestTransformer(t, KeyConfig{Name: "kms", KMS: &KMSConfig{Endpoint: "unix://" + socket}})
+	value, stale, err := tr.Decrypt(ctx, enc)
+	noErr(t, err)
+	expEqual(t, "value", string(value))
+	expEqual(t, false, stale)
+}
+
+func TestBackend(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log, err := memory.NewMemoryLog("", 0, 0)
+	noErr(t, err)
+	inner := logstructured.New(log)
+	noErr(t, inner.Start(ctx))
+
+	// write plaintext values before encryption is enabled, including one outside of "/"
+	_, err = inner.Create(ctx, "/plain", []byte("plain"), 0)
+	noErr(t, err)
+	_, err = inner.Create(ctx, "plain", []byte("plain"), 0)
+	noErr(t, err)
+
+	oldKey := KeyConfig{Name: "old", Secret: secret(1)}
+	newKey := KeyConfig{Name: "new", Secret: secret(2)}
+	b := NewBackend(inner, newTestTransformer(t, oldKey), 0)
+	if c := b.Capabilities(); !c.Encryption || !c.History || c.Compact {
+		t.Fatalf("unexpected capabilities: %+v", c)
+	}
+
+	// the memory log has no compactor to compact through
+	if _, err := b.ManagedCompact(ctx, 1); err != server.ErrManagedCompactNotSupported {
+		t.Fatalf("expected %v, got %v", server.ErrManagedCompactNotSupported, err)
+	}
+	compact := &compactBackend{Backend: inner}
+	_, err = NewBackend(compact, newTestTransformer(t, oldKey), 0).ManagedCompact(ctx, 5)
+	noErr(t, err)
+	expEqual(t, 5, compact.revision)
+
+	wr := b.Watch(ctx, "/a", 0)
+	rev, err := b.Create(ctx, "/a", []byte("1"), 0)
+	noErr(t, err)
+
+	// values are encrypted in the wrapped backend
+	_, kv, err := inner.Get(ctx, "/a", "", 1, 0)
+	noErr(t, err)
+	if !bytes.HasPrefix(kv.Value, []byte("kine:enc:v1:old:")) {
+		t.Fatalf("expected value encrypted with old key, got %q", kv.Value)
+	}
+
+	_, kv, err = b.Get(ctx, "/a", "", 1, 0)
+	noErr(t, err)
+	expEqual(t, "1", string(kv.Value))
+
+	_, kv, ok, err := b.Update(ctx, "/a", []byte("2"), rev, 0)
+	noErr(t, err)
+	expEqual(t, true, ok)
+	expEqual(t, "2", string(kv.Value))
+
+	// watch events and previous values are decrypted
+	var events []*server.Event
+	for len(events) < 2 {
+		select {
+		case e := <-wr.Events:
+			events = append(events, e...)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for watch events")
+		}
+	}
+	expEqual(t, "1", string(events[0].KV.Value))
+	expEqual(t, "2", string(events[1].KV.Value))
+	expEqual(t, "1", string(events[1].PrevKV.Value))
+
+	// a leased value is encrypted with the old key
+	leaseRev, err := b.Create(ctx, "/leased", []byte("leased"), 3600)
+	noErr(t, err)
+
+	// rotate to a new key, and re-encrypt existing values
+	b = NewBackend(inner, newTestTransformer(t, newKey, oldKey), 0)
+	count, leased, err := b.reencrypt(ctx)
+	noErr(t, err)
+	// the health check key, plaintext values and value encrypted with the old key
+	expEqual(t, 4, count)
+	expEqual(t, 1, leased)
+
+	_, kvs, err := inner.List(ctx, "", "", 0, 0)
+	noErr(t, err)
+	expEqual(t, 5, len(kvs))
+	for _, kv := range kvs {
+		if kv.Key == "/leased" {
+			// updating the leased value would restart its TTL, so it is left as it is
+			expEqual(t, leaseRev, kv.ModRevision)
+			if !bytes.HasPrefix(kv.Value, []byte("kine:enc:v1:old:")) {
+				t.Fatalf("expected %s encrypted with old key, got %q", kv.Key, kv.Value)
+			}
+			continue
+		}
+		if !bytes.HasPrefix(kv.Value, []byte("kine:enc:v1:new:")) {
+			t.Fatalf("expected %s encrypted with new key, got %q", kv.Key, kv.Value)
+		}
+	}
+
+	_, kvs, err = b.List(ctx, "/", "", 0, 0)
+	noErr(t, err)
+	values := map[string]string{}
+	for _, kv := range kvs {
+		values[kv.Key] = string(kv.Value)
+	}
+	expEqual(t, "2", values["/a"])
+	expEqual(t, "plain", values["/plain"])
+
+	// values are not re-encrypted again
+	count, leased, err = b.reencrypt(ctx)
+	noErr(t, err)
+	expEqual(t, 0, count)
+	expEqual(t, 1, leased)
+
+	// the old key is no longer required once the leased value has been removed
+	_, _, _, err = inner.Delete(ctx, "/leased", leaseRev)
+	noErr(t, err)
+	b = NewBackend(inner, newTestTransformer(t, newKey), 0)
+	_, kv, err = b.Get(ctx, "/a", "", 1, 0)
+	noErr(t, err)
+	expEqual(t, "2", string(kv.Value))
+}
@@ -0,0 +1,155 @@
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	kmsapi "k8s.io/kms/apis/v2"
+)
+
+// keyEncrypter wraps data encryption keys with a key encryption key.
+type keyEncrypter interface {
+	name() string
+	encrypt(ctx context.Context, dek []byte) (*wrappedKey, error)
+	decrypt(ctx context.Context, wrapped *wrappedKey) ([]byte, error)
+	close() error
+}
+
+// wrappedKey is a data encryption key encrypted by a key encrypter, along with any
+// information required to decrypt it.
+type wrappedKey struct {
+	ciphertext  []byte
+	keyID       string
+	annotations map[string][]byte
+}
+
+// aesGCMKey wraps data encryption keys with a local AES key.
+type aesGCMKey struct {
+	keyName string
+	aead    cipher.AEAD
+}
+
+func newAESGCMKey(name string, secret []byte) (*aesGCMKey, error) {
+	aead, err := newAEAD(secret)
+	if err != nil {
+		return nil, err
+	}
+	return &aesGCMKey{keyName: name, aead: aead}, nil
+}
+
+func (k *aesGCMKey) name() string {
+	return k.keyName
+}
+
+func (k *aesGCMKey) encrypt(_ context.Context, dek []byte) (*wrappedKey, error) {
+	return &wrappedKey{ciphertext: seal(k.aead, dek)}, nil
+}
+
+func (k *aesGCMKey) decrypt(_ context.Context, wrapped *wrappedKey) ([]byte, error) {
+	return open(k.aead, wrapped.ciphertext)
+}
+
+func (k *aesGCMKey) close() error {
+	return nil
+}
+
+// kmsKey wraps data encryption keys using a Kubernetes KMS v2 plugin.
+type kmsKey struct {
+	keyName string
+	timeout time.Duration
+	conn    *grpc.ClientConn
+	client  kmsapi.KeyManagementServiceClient
+}
+
+func newKMSKey(name, endpoint string, timeout time.Duration) (*kmsKey, error) {
+	if !strings.HasPrefix(endpoint, "unix://") {
+		return nil, errors.Errorf("invalid kms endpoint %q: only unix sockets are supported", endpoint)
+	}
+	conn, err := grpc.NewClient(endpoint, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	return &kmsKey{
+		keyName: name,
+		timeout: timeout,
+		conn:    conn,
+		client:  kmsapi.NewKeyManagementServiceClient(conn),
+	}, nil
+}
+
+func (k *kmsKey) name() string {
+	return k.keyName
+}
+
+func (k *kmsKey) encrypt(ctx context.Context, dek []byte) (*wrappedKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, k.timeout)
+	defer cancel()
+
+	resp, err := k.client.Encrypt(ctx, &kmsapi.EncryptRequest{Plaintext: dek, Uid: uid()})
+	if err != nil {
+		return nil, errors.Wrapf(err, "encrypting with kms key %q", k.keyName)
+	}
+	return &wrappedKey{ciphertext: resp.Ciphertext, keyID: resp.KeyId, annotations: resp.Annotations}, nil
+}
+
+func (k *kmsKey) decrypt(ctx context.Context, wrapped *wrappedKey) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, k.timeout)
+	defer cancel()
+
+	resp, err := k.client.Decrypt(ctx, &kmsapi.DecryptRequest{
+		Ciphertext:  wrapped.ciphertext,
+		Uid:         uid(),
+		KeyId:       wrapped.keyID,
+		Annotations: wrapped.annotations,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "decrypting with kms key %q", k.keyName)
+	}
+	return resp.Plaintext, nil
+}
+
+func (k *kmsKey) close() error {
+	return k.conn.Close()
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts the plaintext with a random nonce, which is prepended to the ciphertext.
+func seal(aead cipher.AEAD, plaintext []byte) []byte {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil)
+}
+
+func open(aead cipher.AEAD, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+// uid returns a random request identifier, used by KMS plugins to correlate log entries.
+func uid() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,266 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxDataKeyUses is the number of values encrypted with a data encryption key before it is
+	// replaced, keeping the probability of a random nonce collision negligible.
+	maxDataKeyUses = 1 << 24
+
+	// maxCachedDataKeys is the number of decrypted data encryption keys to cache.
+	maxCachedDataKeys = 1024
+
+	dataKeySize = 32
+)
+
+// prefix identifies encrypted values. Values without the prefix are stored in plaintext.
+var prefix = []byte("kine:enc:v1:")
+
+// Transformer encrypts values using envelope encryption. Each value is encrypted with AES-GCM
+// using a data encryption key, which is itself encrypted by the primary key encryption key and
+// stored alongside the value. Data encryption keys are reused for many values, so that the
+// key encryption key is only used when a new data encryption key is generated, or an unknown
+// data encryption key is decrypted.
+//
+// Encrypted values are stored as:
+//
+//	prefix | key name | ':' | wrapped data key | nonce | ciphertext
+//
+// where the wrapped data key is a sequence of length-prefixed fields.
+type Transformer struct {
+	keys    []keyEncrypter
+	byName  map[string]keyEncrypter
+	mu      sync.Mutex
+	current *dataKey
+	cache   map[string]cipher.AEAD
+}
+
+// dataKey is a data encryption key, along with the header that identifies it in stored values.
+type dataKey struct {
+	aead   cipher.AEAD
+	header []byte
+	uses   uint64
+}
+
+// NewTransformer returns a transformer using the configured keys.
+func NewTransformer(config Config) (*Transformer, error) {
+	keys, err := config.keyEncrypters()
+	if err != nil {
+		return nil, err
+	}
+	return newTransformer(keys), nil
+}
+
+func newTransformer(keys []keyEncrypter) *Transformer {
+	t := &Transformer{
+		keys:   keys,
+		byName: map[string]keyEncrypter{},
+		cache:  map[string]cipher.AEAD{},
+	}
+	for _, key := range keys {
+		t.byName[key.name()] = key
+	}
+	return t
+}
+
+// Encrypt encrypts the value with the current data encryption key.
+func (t *Transformer) Encrypt(ctx context.Context, value []byte) ([]byte, error) {
+	dek, err := t.dataKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(dek.header)+dek.aead.NonceSize()+len(value)+dek.aead.Overhead())
+	out = append(out, dek.header...)
+	return append(out, seal(dek.aead, value)...), nil
+}
+
+// Decrypt returns the plaintext of a value, and whether the value should be re-encrypted
+// because it is not encrypted with the primary key. Values that are not encrypted are
+// returned unchanged.
+func (t *Transformer) Decrypt(ctx context.Context, value []byte) ([]byte, bool, error) {
+	if !bytes.HasPrefix(value, prefix) {
+		return value, true, nil
+	}
+
+	name, wrapped, headerLen, err := decodeHeader(value)
+	if err != nil {
+		return nil, false, err
+	}
+	aead, err := t.decryptDataKey(ctx, name, wrapped, value[:headerLen])
+	if err != nil {
+		return nil, false, err
+	}
+	plaintext, err := open(aead, value[headerLen:])
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "decrypting value with key %q", name)
+	}
+	return plaintext, name != t.keys[0].name(), nil
+}
+
+// Close closes any connections to KMS plugins.
+func (t *Transformer) Close() error {
+	var errs []error
+	for _, key := range t.keys {
+		if err := key.close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// dataKey returns the current data encryption key, generating a new key if there is no
+// current key or it has been used too many times.
+func (t *Transformer) dataKey(ctx context.Context) (*dataKey, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.current != nil && t.current.uses < maxDataKeyUses {
+		t.current.uses++
+		return t.current, nil
+	}
+
+	key := make([]byte, dataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	primary := t.keys[0]
+	wrapped, err := primary.encrypt(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	t.current = &dataKey{
+		aead:   aead,
+		header: encodeHeader(primary.name(), wrapped),
+		uses:   1,
+	}
+	return t.current, nil
+}
+
+// decryptDataKey returns the data encryption key identified by the header, decrypting it
+// with the named key encryption key if it is not cached.
+func (t *Transformer) decryptDataKey(ctx context.Context, name string, wrapped *wrappedKey, header []byte) (cipher.AEAD, error) {
+	t.mu.Lock()
+	aead, ok := t.cache[string(header)]
+	t.mu.Unlock()
+	if ok {
+		return aead, nil
+	}
+
+	key, ok := t.byName[name]
+	if !ok {
+		return nil, errors.Errorf("value is encrypted with unknown key %q", name)
+	}
+	dek, err := key.decrypt(ctx, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	aead, err = newAEAD(dek)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	if len(t.cache) >= maxCachedDataKeys {
+		t.cache = map[string]cipher.AEAD{}
+	}
+	t.cache[string(header)] = aead
+	t.mu.Unlock()
+	return aead, nil
+}
+
+func encodeHeader(name string, wrapped *wrappedKey) []byte {
+	header := append([]byte{}, prefix...)
+	header = append(header, name...)
+	header = append(header, ':')
+	header = appendField(header, wrapped.ciphertext)
+	header = appendField(header, []byte(wrapped.keyID))
+
+	keys := make([]string, 0, len(wrapped.annotations))
+	for k := range wrapped.annotations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	header = binary.AppendUvarint(header, uint64(len(keys)))
+	for _, k := range keys {
+		header = appendField(header, []byte(k))
+		header = appendField(header, wrapped.annotations[k])
+	}
+	return header
+}
+
+// decodeHeader returns the key name and wrapped data key from an encrypted value, along with
+// the length of the header.
+func decodeHeader(value []byte) (string, *wrappedKey, int, error) {
+	rest := value[len(prefix):]
+	i := bytes.IndexByte(rest, ':')
+	if i < 0 {
+		return "", nil, 0, errors.New("invalid encrypted value: missing key name")
+	}
+	name := string(rest[:i])
+	rest = rest[i+1:]
+
+	wrapped := &wrappedKey{}
+	var (
+		field []byte
+		err   error
+	)
+	if wrapped.ciphertext, rest, err = readField(rest); err != nil {
+		return "", nil, 0, err
+	}
+	if field, rest, err = readField(rest); err != nil {
+		return "", nil, 0, err
+	}
+	wrapped.keyID = string(field)
+
+	count, n := binary.Uvarint(rest)
+	if n <= 0 || count > uint64(len(rest)) {
+		return "", nil, 0, errors.New("invalid encrypted value: bad annotation count")
+	}
+	rest = rest[n:]
+	for ; count > 0; count-- {
+		var k, v []byte
+		if k, rest, err = readField(rest); err != nil {
+			return "", nil, 0, err
+		}
+		if v, rest, err = readField(rest); err != nil {
+			return "", nil, 0, err
+		}
+		if wrapped.annotations == nil {
+			wrapped.annotations = map[string][]byte{}
+		}
+		wrapped.annotations[string(k)] = v
+	}
+
+	return name, wrapped, len(value) - len(rest), nil
+}
+
+func appendField(b, field []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(len(field)))
+	return append(b, field...)
+}
+
+func readField(b []byte) ([]byte, []byte, error) {
+	l, n := binary.Uvarint(b)
+	if n <= 0 || l > uint64(len(b)-n) {
+		return nil, nil, errors.New("invalid encrypted value: bad field length")
+	}
+	return b[n : n+int(l)], b[n+int(l):], nil
+}
@@ -5,10 +5,14 @@ import (
 	"net"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/k3s-io/kine/pkg/breaker"
+	"github.com/k3s-io/kine/pkg/composite"
 	"github.com/k3s-io/kine/pkg/drivers"
 	"github.com/k3s-io/kine/pkg/drivers/generic"
+	"github.com/k3s-io/kine/pkg/encryption"
 	"github.com/k3s-io/kine/pkg/metrics"
 	"github.com/k3s-io/kine/pkg/server"
 	"github.com/k3s-io/kine/pkg/tls"
@@ -20,22 +24,40 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/test/bufconn"
 )
 
 const (
 	KineSocket = "unix://kine.sock"
+
+	// BufconnListener can be used as the Listener address to serve GRPC over an in-memory
+	// connection instead of a socket. Clients must connect using the Dialer from the returned ETCDConfig.
+	BufconnListener = "bufconn://kine"
+
+	bufconnSize = 1024 * 1024
+
+	// defaultShutdownTimeout is the maximum time to wait for GRPC requests to complete
+	// when the server is shut down due to context cancellation.
+	defaultShutdownTimeout = 5 * time.Second
 )
 
 type Config struct {
 	GRPCServer            *grpc.Server
 	Listener              string
 	Endpoint              string
+	ReplicaEndpoint       string
 	ConnectionPoolConfig  generic.ConnectionPoolConfig
+	CompressionConfig     generic.CompressionConfig
+	RetentionConfig       generic.RetentionConfig
+	EncryptionConfig      encryption.BackendConfig
+	CircuitBreakerConfig  breaker.Config
+	Routes                []composite.Route
 	ServerTLSConfig       tls.Config
 	BackendTLSConfig      tls.Config
 	MetricsRegisterer     prometheus.Registerer
 	NotifyInterval        time.Duration
 	EmulatedETCDVersion   string
+	AdminTokenFile        string
 	CompactInterval       time.Duration
 	CompactIntervalJitter int
 	CompactTimeout        time.Duration
@@ -49,69 +71,169 @@ type ETCDConfig struct {
 	Endpoints   []string
 	TLSConfig   tls.Config
 	LeaderElect bool
+	// Dialer is set when the server is listening on an in-memory connection,
+	// and must be used by clients to connect to the endpoint.
+	Dialer func(context.Context, string) (net.Conn, error)
 }
 
-func Listen(ctx context.Context, config Config) (ETCDConfig, error) {
-	leaderElect, backend, err := drivers.New(ctx, &drivers.Config{
-		MetricsRegisterer:     config.MetricsRegisterer,
-		Endpoint:              config.Endpoint,
-		BackendTLSConfig:      config.BackendTLSConfig,
-		ConnectionPoolConfig:  config.ConnectionPoolConfig,
-		CompactInterval:       config.CompactInterval,
-		CompactIntervalJitter: config.CompactIntervalJitter,
-		CompactTimeout:        config.CompactTimeout,
-		CompactMinRetain:      config.CompactMinRetain,
-		CompactBatchSize:      config.CompactBatchSize,
-		PollBatchSize:         config.PollBatchSize,
-	})
+// Server is a handle to a running kine server, that can be used to stop it.
+type Server struct {
+	config     ETCDConfig
+	backend    server.Backend
+	grpcServer *grpc.Server
+	ownsGRPC   bool
+	listener   net.Listener
+	cancel     context.CancelFunc
+	served     chan struct{}
+	closeOnce  sync.Once
+	closeErr   error
+}
 
+// Listen starts a kine server, and returns the configuration used to connect to it.
+// The server is shut down when the context is cancelled.
+func Listen(ctx context.Context, config Config) (ETCDConfig, error) {
+	s, err := New(ctx, config)
 	if err != nil {
-		// Don't print the endpoint string in the error message as it may contain
-		// credentials - but we do want to indicate whether the failure was in the
-		// default or provided value.
-		epType := "default endpoint"
-		if config.Endpoint != "" {
-			epType = "configured endpoint"
+		return ETCDConfig{}, err
+	}
+
+	go func() {
+		<-ctx.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), defaultShutdownTimeout)
+		defer cancel()
+		if err := s.Shutdown(ctx); err != nil {
+			logrus.Errorf("Kine shutdown failed: %v", err)
+		}
+	}()
+
+	return s.ETCDConfig(), nil
+}
+
+// New starts a kine server, and returns a handle that can be used to connect to and stop it.
+// The server runs until the context is cancelled, or Close or Shutdown is called.
+func New(ctx context.Context, config Config) (*Server, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	s := &Server{
+		cancel: cancel,
+		served: make(chan struct{}),
+	}
+
+	if err := s.start(ctx, config); err != nil {
+		cancel()
+		if s.listener != nil {
+			s.listener.Close()
+		}
+		if s.backend != nil {
+			s.backend.Close()
 		}
-		return ETCDConfig{}, errors.Wrap(err, "failed to create driver for "+epType)
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *Server) start(ctx context.Context, config Config) error {
+	cfg := driverConfig(config)
+	if len(config.Routes) > 0 {
+		cfg.MetricsRegisterer = routeRegisterer(cfg.MetricsRegisterer, "")
+	}
+	leaderElect, backend, err := drivers.New(ctx, cfg)
+	if err != nil {
+		return errors.Wrap(err, "failed to create driver for "+endpointType(config))
 	}
 
 	if backend == nil {
-		return ETCDConfig{
+		if len(config.Routes) > 0 {
+			return errors.New("routes are not supported by the " + endpointType(config))
+		}
+		close(s.served)
+		s.config = ETCDConfig{
 			Endpoints:   strings.Split(config.Endpoint, ","),
 			TLSConfig:   config.BackendTLSConfig,
 			LeaderElect: leaderElect,
-		}, nil
+		}
+		return nil
+	}
+
+	if len(config.Routes) > 0 {
+		if backend, err = newCompositeBackend(ctx, config, backend); err != nil {
+			return err
+		}
 	}
 
+	if config.EncryptionConfig.ConfigFile != "" {
+		encryptionConfig, err := encryption.LoadConfig(config.EncryptionConfig.ConfigFile)
+		if err != nil {
+			backend.Close()
+			return err
+		}
+		transformer, err := encryption.NewTransformer(encryptionConfig)
+		if err != nil {
+			backend.Close()
+			return errors.Wrap(err, "configuring encryption")
+		}
+		backend = encryption.NewBackend(backend, transformer, config.EncryptionConfig.ReencryptInterval)
+	}
+
+	if config.CircuitBreakerConfig.FailureThreshold > 0 {
+		backend = breaker.NewBackend(backend, config.CircuitBreakerConfig)
+	}
+	s.backend = backend
+
 	if config.MetricsRegisterer != nil {
 		config.MetricsRegisterer.MustRegister(
 			metrics.SQLTotal,
 			metrics.SQLTime,
+			metrics.SQLFailoverTotal,
+			metrics.ReplicaLag,
+			metrics.CircuitBreakerState,
+			metrics.CircuitBreakerRejectedTotal,
 			metrics.CompactTotal,
+			metrics.CompactLeader,
+			metrics.CompactRevision,
+			metrics.CompactCurrentRevision,
+			metrics.CompactLag,
+			metrics.CompactBatchDuration,
+			metrics.CompactBatchDeletedRows,
+			metrics.PostCompactErrorsTotal,
+			metrics.CompactLastSuccess,
+			metrics.GapFillTotal,
 			metrics.InsertErrorsTotal,
+			metrics.CompressionRatio,
+			metrics.OldValueSavedBytes,
 		)
 	}
 
 	if err := backend.Start(ctx); err != nil {
-		return ETCDConfig{}, errors.Wrap(err, "starting kine backend")
+		return errors.Wrap(err, "starting kine backend")
 	}
 
 	// set up GRPC server and register services
 	b := server.New(backend, endpointScheme(config), config.NotifyInterval, config.EmulatedETCDVersion)
+	if config.AdminTokenFile != "" {
+		token, err := os.ReadFile(config.AdminTokenFile)
+		if err != nil {
+			return errors.Wrap(err, "reading admin token file")
+		}
+		b.SetAdminToken(strings.TrimSpace(string(token)))
+	}
 	grpcServer, err := grpcServer(config)
 	if err != nil {
-		return ETCDConfig{}, errors.Wrap(err, "creating GRPC server")
+		return errors.Wrap(err, "creating GRPC server")
 	}
 	b.Register(grpcServer)
+	s.grpcServer = grpcServer
+	s.ownsGRPC = config.GRPCServer == nil
 
 	// Create raw listener and wrap in cmux for protocol switching
 	listener, err := createListener(config)
 	if err != nil {
-		return ETCDConfig{}, errors.Wrap(err, "creating listener")
+		return errors.Wrap(err, "creating listener")
 	}
+	s.listener = listener
 
 	go func() {
+		defer close(s.served)
 		if err := grpcServer.Serve(listener); err != nil {
 			logrus.Errorf("Kine GPRC server exited: %v", err)
 		}
@@ -120,13 +242,167 @@ func Listen(ctx context.Context, config Config) (ETCDConfig, error) {
 	endpoint := endpointURL(config, listener)
 	logrus.Infof("Kine available at %s", endpoint)
 
-	return ETCDConfig{
+	s.config = ETCDConfig{
 		LeaderElect: leaderElect,
 		Endpoints:   []string{endpoint},
 		TLSConfig: tls.Config{
 			CAFile: config.ServerTLSConfig.CAFile,
 		},
-	}, nil
+	}
+	if l, ok := listener.(*bufconn.Listener); ok {
+		s.config.Dialer = func(ctx context.Context, _ string) (net.Conn, error) {
+			return l.DialContext(ctx)
+		}
+	}
+	return nil
+}
+
+// newCompositeBackend creates a backend for each route, and returns a backend that routes keys
+// to them, or to the default backend. The default backend is closed if an error is returned.
+func newCompositeBackend(ctx context.Context, config Config, defaultBackend server.Backend) (server.Backend, error) {
+	backends := map[string]server.Backend{}
+	closeAll := func() {
+		defaultBackend.Close()
+		for _, backend := range backends {
+			backend.Close()
+		}
+	}
+
+	for _, route := range config.Routes {
+		cfg := driverConfig(config)
+		cfg.Endpoint = route.Endpoint
+		cfg.ReplicaEndpoint = ""
+		cfg.MetricsRegisterer = routeRegisterer(cfg.MetricsRegisterer, route.Prefix)
+		cfg.Route = route.Prefix
+		_, backend, err := drivers.New(ctx, cfg)
+		if err != nil {
+			closeAll()
+			return nil, errors.Wrapf(err, "failed to create driver for route %q", route.Prefix)
+		}
+		if backend == nil {
+			closeAll()
+			return nil, errors.Errorf("route %q endpoint is not supported", route.Prefix)
+		}
+		if _, ok := backends[route.Prefix]; ok {
+			backend.Close()
+			closeAll()
+			return nil, errors.Errorf("duplicate route %q", route.Prefix)
+		}
+		backends[route.Prefix] = backend
+	}
+
+	backend, err := composite.NewBackend(defaultBackend, backends)
+	if err != nil {
+		closeAll()
+		return nil, errors.Wrap(err, "configuring routes")
+	}
+	return backend, nil
+}
+
+// routeRegisterer labels the collectors registered by a driver with its route prefix, so that
+// the collectors of each driver are distinct. The default driver has an empty prefix.
+func routeRegisterer(reg prometheus.Registerer, prefix string) prometheus.Registerer {
+	if reg == nil {
+		return nil
+	}
+	return prometheus.WrapRegistererWith(prometheus.Labels{"route": prefix}, reg)
+}
+
+// NewMigrator returns a schema migrator for the configured endpoint. The caller is
+// responsible for closing the migrator.
+func NewMigrator(ctx context.Context, config Config) (*generic.Migrator, error) {
+	migrator, err := drivers.NewMigrator(ctx, driverConfig(config))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create schema migrator for "+endpointType(config))
+	}
+	return migrator, nil
+}
+
+func driverConfig(config Config) *drivers.Config {
+	return &drivers.Config{
+		MetricsRegisterer:     config.MetricsRegisterer,
+		Endpoint:              config.Endpoint,
+		ReplicaEndpoint:       config.ReplicaEndpoint,
+		BackendTLSConfig:      config.BackendTLSConfig,
+		ConnectionPoolConfig:  config.ConnectionPoolConfig,
+		CompressionConfig:     config.CompressionConfig,
+		RetentionConfig:       config.RetentionConfig,
+		CompactInterval:       config.CompactInterval,
+		CompactIntervalJitter: config.CompactIntervalJitter,
+		CompactTimeout:        config.CompactTimeout,
+		CompactMinRetain:      config.CompactMinRetain,
+		CompactBatchSize:      config.CompactBatchSize,
+		PollBatchSize:         config.PollBatchSize,
+	}
+}
+
+// endpointType describes the endpoint for use in error messages.
+// Don't print the endpoint string in the error message as it may contain
+// credentials - but we do want to indicate whether the failure was in the
+// default or provided value.
+func endpointType(config Config) string {
+	if config.Endpoint != "" {
+		return "configured endpoint"
+	}
+	return "default endpoint"
+}
+
+// ETCDConfig returns the configuration that clients should use to connect to the server.
+func (s *Server) ETCDConfig() ETCDConfig {
+	return s.config
+}
+
+// Close immediately stops the server, cancelling any in-progress requests.
+func (s *Server) Close() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return s.Shutdown(ctx)
+}
+
+// Shutdown gracefully stops the server. New requests are rejected, and watches are closed
+// once the backend is stopped. Shutdown waits for in-progress requests to complete until
+// the context is cancelled, at which point any remaining requests are cancelled.
+// Shutdown returns once the GRPC server has exited and the backend has been stopped.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.closeOnce.Do(func() {
+		s.closeErr = s.shutdown(ctx)
+	})
+	return s.closeErr
+}
+
+func (s *Server) shutdown(ctx context.Context) error {
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		switch {
+		case s.grpcServer == nil:
+		case s.ownsGRPC:
+			s.grpcServer.GracefulStop()
+		default:
+			// the GRPC server was provided by the caller, so only stop serving on our listener
+			s.listener.Close()
+		}
+	}()
+
+	// Cancel the backend context to stop compaction and polling; this also closes
+	// any open watch channels so that watch streams are ended.
+	s.cancel()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		logrus.Warnf("Kine GRPC server did not stop gracefully: %v", ctx.Err())
+		if s.ownsGRPC {
+			s.grpcServer.Stop()
+		}
+		<-stopped
+	}
+	<-s.served
+
+	if s.backend != nil {
+		return s.backend.Close()
+	}
+	return nil
 }
 
 // endpointURL returns a URI string suitable for use as a local etcd endpoint.
@@ -134,6 +410,9 @@ func Listen(ctx context.Context, config Config) (ETCDConfig, error) {
 func endpointURL(config Config, listener net.Listener) string {
 	scheme := endpointScheme(config)
 	address := listener.Addr().String()
+	if _, ok := listener.(*bufconn.Listener); ok {
+		return scheme + "://" + address
+	}
 	if !strings.HasPrefix(scheme, "unix") {
 		_, port, err := net.SplitHostPort(address)
 		if err != nil {
@@ -172,6 +451,10 @@ func createListener(config Config) (ret net.Listener, rerr error) {
 	}
 	scheme, address := util.SchemeAndAddress(config.Listener)
 
+	if scheme == "bufconn" {
+		return bufconn.Listen(bufconnSize), nil
+	}
+
 	if scheme == "unix" {
 		if err := os.Remove(address); err != nil && !os.IsNotExist(err) {
 			logrus.Warnf("failed to remove socket %s: %v", address, err)
@@ -0,0 +1,458 @@
+//go:build cgo
+// +build cgo
+
+package endpoint
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/k3s-io/kine/pkg/breaker"
+	"github.com/k3s-io/kine/pkg/composite"
+	"github.com/k3s-io/kine/pkg/kinepb"
+	"github.com/k3s-io/kine/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestServer_Bufconn(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	s, err := New(ctx, Config{
+		Listener:         BufconnListener,
+		Endpoint:         "sqlite://" + filepath.Join(t.TempDir(), "state.db") + "?_journal=WAL&cache=shared&_busy_timeout=30000",
+		NotifyInterval:   time.Second,
+		CompactInterval:  time.Minute,
+		CompactTimeout:   time.Second,
+		CompactBatchSize: 1000,
+		PollBatchSize:    500,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := s.ETCDConfig()
+	if config.Dialer == nil {
+		t.Fatal("expected dialer for bufconn listener")
+	}
+
+	c, err := clientv3.New(clientv3.Config{
+		Endpoints:   config.Endpoints,
+		DialTimeout: 5 * time.Second,
+		DialOptions: []grpc.DialOption{grpc.WithContextDialer(config.Dialer)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	resp, err := c.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision("/test/a"), "=", 0)).
+		Then(clientv3.OpPut("/test/a", "b")).
+		Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Succeeded {
+		t.Fatal("expected create to succeed")
+	}
+
+	get, err := c.Get(ctx, "/test/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(get.Kvs) != 1 || string(get.Kvs[0].Value) != "b" {
+		t.Fatalf("unexpected get response: %v", get.Kvs)
+	}
+
+	wctx, wcancel := context.WithCancel(ctx)
+	defer wcancel()
+	wch := c.Watch(wctx, "/test/", clientv3.WithPrefix())
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(ctx, time.Second)
+	defer shutdownCancel()
+	if err := s.Shutdown(shutdownCtx); err != nil {
+		t.Fatal(err)
+	}
+
+	// the watch should be cancelled once the backend is stopped
+	select {
+	case <-wch:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch was not closed on shutdown")
+	}
+
+	gctx, gcancel := context.WithTimeout(ctx, time.Second)
+	defer gcancel()
+	if _, err := c.Get(gctx, "/test/a"); err == nil {
+		t.Fatal("expected request to fail after shutdown")
+	}
+}
+
+func TestServer_Admin(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tokenFile := filepath.Join(t.TempDir(), "admin-token")
+	if err := os.WriteFile(tokenFile, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := New(ctx, Config{
+		Listener:         BufconnListener,
+		Endpoint:         "sqlite://" + filepath.Join(t.TempDir(), "state.db") + "?_journal=WAL&cache=shared&_busy_timeout=30000",
+		AdminTokenFile:   tokenFile,
+		NotifyInterval:   time.Second,
+		CompactInterval:  time.Minute,
+		CompactTimeout:   time.Second,
+		CompactBatchSize: 1000,
+		PollBatchSize:    500,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	config := s.ETCDConfig()
+	c, err := clientv3.New(clientv3.Config{
+		Endpoints:   config.Endpoints,
+		DialTimeout: 5 * time.Second,
+		DialOptions: []grpc.DialOption{grpc.WithContextDialer(config.Dialer)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	admin := kinepb.NewAdminClient(c.ActiveConnection())
+	if _, err := admin.ListWatches(ctx, &kinepb.ListWatchesRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected unauthenticated error, got %v", err)
+	}
+
+	actx := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer secret")
+	wctx, wcancel := context.WithCancel(ctx)
+	defer wcancel()
+	c.Watch(wctx, "/test/", clientv3.WithPrefix(), clientv3.WithCreatedNotify())
+
+	var watches []*kinepb.Watch
+	for len(watches) == 0 {
+		resp, err := admin.ListWatches(actx, &kinepb.ListWatchesRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		watches = resp.Watches
+		time.Sleep(10 * time.Millisecond)
+	}
+	if string(watches[0].Key) != "/test/" {
+		t.Fatalf("unexpected watches: %v", watches)
+	}
+
+	level, err := admin.SetLogLevel(actx, &kinepb.SetLogLevelRequest{Level: "warn"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logrus.SetLevel(logrus.InfoLevel)
+	if level.Level != "warning" || logrus.GetLevel() != logrus.WarnLevel {
+		t.Fatalf("unexpected log level response: %v", level)
+	}
+
+	capabilities, err := admin.Capabilities(actx, &kinepb.CapabilitiesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !capabilities.History || !capabilities.Compact || !capabilities.GapFill {
+		t.Fatalf("unexpected capabilities: %v", capabilities)
+	}
+}
+
+func TestServer_AdminCompact(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tokenFile := filepath.Join(t.TempDir(), "admin-token")
+	if err := os.WriteFile(tokenFile, []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := New(ctx, Config{
+		Listener:         BufconnListener,
+		Endpoint:         "sqlite://" + filepath.Join(t.TempDir(), "state.db") + "?_journal=WAL&cache=shared&_busy_timeout=30000",
+		AdminTokenFile:   tokenFile,
+		NotifyInterval:   time.Second,
+		CompactInterval:  time.Minute,
+		CompactTimeout:   time.Second,
+		CompactBatchSize: 1000,
+		PollBatchSize:    500,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	config := s.ETCDConfig()
+	c, err := clientv3.New(clientv3.Config{
+		Endpoints:   config.Endpoints,
+		DialTimeout: 5 * time.Second,
+		DialOptions: []grpc.DialOption{grpc.WithContextDialer(config.Dialer)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	wctx, wcancel := context.WithCancel(ctx)
+	defer wcancel()
+	wc := c.Watch(wctx, "/test/a", clientv3.WithCreatedNotify())
+	wresp, ok := <-wc
+	if !ok || !wresp.Created {
+		t.Fatal("expected watch to be created")
+	}
+
+	var revs []int64
+	for i, value := range []string{"1", "2", "3"} {
+		var modRev int64
+		if i > 0 {
+			modRev = revs[i-1]
+		}
+		resp, err := c.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision("/test/a"), "=", modRev)).
+			Then(clientv3.OpPut("/test/a", value)).
+			Else(clientv3.OpGet("/test/a")).
+			Commit()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !resp.Succeeded {
+			t.Fatalf("expected write of %s to succeed", value)
+		}
+		revs = append(revs, resp.Header.Revision)
+	}
+
+	// compaction does not pass the revision read by the poll loop, so wait for the last write
+	// to be sent to watchers
+	for len(wresp.Events) == 0 || wresp.Events[len(wresp.Events)-1].Kv.ModRevision < revs[2] {
+		if wresp, ok = <-wc; !ok {
+			t.Fatal("watch closed before the last write was sent")
+		}
+	}
+	wcancel()
+
+	admin := kinepb.NewAdminClient(c.ActiveConnection())
+	actx := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer secret")
+	resp, err := admin.Compact(actx, &kinepb.CompactRequest{Revision: revs[1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.CurrentRevision != revs[2] {
+		t.Fatalf("expected current revision %d, got %d", revs[2], resp.CurrentRevision)
+	}
+
+	// reads below the compact revision fail, and reads at it succeed
+	if _, err := c.Get(ctx, "/test/a", clientv3.WithRev(revs[0])); err != rpctypes.ErrCompacted {
+		t.Fatalf("expected %v, got %v", rpctypes.ErrCompacted, err)
+	}
+	get, err := c.Get(ctx, "/test/a", clientv3.WithRev(revs[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(get.Kvs) != 1 || string(get.Kvs[0].Value) != "2" {
+		t.Fatalf("unexpected get response: %v", get.Kvs)
+	}
+
+	if _, err := admin.Compact(actx, &kinepb.CompactRequest{Revision: revs[1]}); err == nil || !strings.Contains(err.Error(), rpctypes.ErrCompacted.Error()) {
+		t.Fatalf("expected compacted error, got %v", err)
+	}
+	if _, err := admin.Compact(actx, &kinepb.CompactRequest{Revision: revs[2] + 10}); err == nil || !strings.Contains(err.Error(), rpctypes.ErrFutureRev.Error()) {
+		t.Fatalf("expected future revision error, got %v", err)
+	}
+}
+
+func TestServer_ReplicaEndpoint(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	for _, test := range []struct {
+		name     string
+		endpoint string
+		err      string
+	}{
+		{name: "default driver", err: "replica endpoint requires a datastore endpoint"},
+		{name: "unsupported driver", endpoint: "sqlite://" + filepath.Join(t.TempDir(), "state.db"), err: "driver does not support read replicas"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := New(ctx, Config{
+				Listener:        BufconnListener,
+				Endpoint:        test.endpoint,
+				ReplicaEndpoint: "sqlite://" + filepath.Join(t.TempDir(), "replica.db"),
+			})
+			if err == nil {
+				s.Close()
+				t.Fatal("expected replica endpoint to be rejected")
+			}
+			if !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expected error %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestServer_Routes(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	dir := t.TempDir()
+	s, err := New(ctx, Config{
+		Listener: BufconnListener,
+		Endpoint: "sqlite://" + filepath.Join(dir, "state.db") + "?_journal=WAL&cache=shared&_busy_timeout=30000",
+		Routes: []composite.Route{{
+			Prefix:   "/registry/events/",
+			Endpoint: "sqlite://" + filepath.Join(dir, "events.db") + "?_journal=WAL&cache=shared&_busy_timeout=30000",
+		}},
+		MetricsRegisterer: prometheus.NewRegistry(),
+		NotifyInterval:    time.Second,
+		CompactInterval:   time.Minute,
+		CompactTimeout:    time.Second,
+		CompactBatchSize:  1000,
+		PollBatchSize:     500,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	config := s.ETCDConfig()
+	c, err := clientv3.New(clientv3.Config{
+		Endpoints:   config.Endpoints,
+		DialTimeout: 5 * time.Second,
+		DialOptions: []grpc.DialOption{grpc.WithContextDialer(config.Dialer)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	wch := c.Watch(ctx, "/registry/", clientv3.WithPrefix())
+
+	var revs []int64
+	for _, key := range []string{"/registry/pods/a", "/registry/events/a", "/registry/pods/b"} {
+		resp, err := c.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(key), "=", 0)).
+			Then(clientv3.OpPut(key, "v")).
+			Commit()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !resp.Succeeded {
+			t.Fatalf("expected create of %s to succeed", key)
+		}
+		revs = append(revs, resp.Header.Revision)
+	}
+	if revs[0] >= revs[1] || revs[1] >= revs[2] {
+		t.Fatalf("expected increasing revisions, got %v", revs)
+	}
+
+	get, err := c.Get(ctx, "/registry/", clientv3.WithPrefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, kv := range get.Kvs {
+		keys = append(keys, string(kv.Key))
+	}
+	if got := strings.Join(keys, " "); got != "/registry/events/a /registry/health /registry/pods/a /registry/pods/b" {
+		t.Fatalf("unexpected keys: %s", got)
+	}
+
+	var events []int64
+	for len(events) < 3 {
+		select {
+		case resp := <-wch:
+			for _, e := range resp.Events {
+				events = append(events, e.Kv.ModRevision)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for events, got %v", events)
+		}
+	}
+	if !reflect.DeepEqual(events, revs) {
+		t.Fatalf("expected event revisions %v, got %v", revs, events)
+	}
+
+	// each database runs its own compactor, and reports its compaction status by route
+	for _, route := range []string{"", "/registry/events/"} {
+		for metrics.GetCompactionStatus(route).CurrentRevision == 0 {
+			select {
+			case <-ctx.Done():
+				t.Fatalf("timed out waiting for the compaction status of route %q", route)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+	if status := metrics.GetCompactionStatus("/registry/other/"); status.CurrentRevision != 0 {
+		t.Fatalf("expected no compaction status for an unknown route, got %+v", status)
+	}
+}
+
+func TestServer_CircuitBreaker(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// every call is slower than the latency threshold, so the first request opens the breaker
+	s, err := New(ctx, Config{
+		Listener:         BufconnListener,
+		Endpoint:         "sqlite://" + filepath.Join(t.TempDir(), "state.db") + "?_journal=WAL&cache=shared&_busy_timeout=30000",
+		NotifyInterval:   time.Second,
+		CompactInterval:  time.Minute,
+		CompactTimeout:   time.Second,
+		CompactBatchSize: 1000,
+		PollBatchSize:    500,
+		CircuitBreakerConfig: breaker.Config{
+			FailureThreshold: 1,
+			LatencyThreshold: time.Nanosecond,
+			OpenTimeout:      time.Minute,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Shutdown(context.Background())
+
+	config := s.ETCDConfig()
+	c, err := clientv3.New(clientv3.Config{
+		Endpoints:   config.Endpoints,
+		DialTimeout: 5 * time.Second,
+		DialOptions: []grpc.DialOption{grpc.WithContextDialer(config.Dialer)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	health := healthpb.NewHealthClient(c.ActiveConnection())
+	if resp, err := health.Check(ctx, &healthpb.HealthCheckRequest{}); err != nil || resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("expected serving, got %v: %v", resp, err)
+	}
+
+	if _, err := c.Get(ctx, "/test/a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, "/test/a"); err != rpctypes.ErrUnhealthy {
+		t.Fatalf("expected %v, got %v", rpctypes.ErrUnhealthy, err)
+	}
+	if resp, err := health.Check(ctx, &healthpb.HealthCheckRequest{}); err != nil || resp.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("expected not serving, got %v: %v", resp, err)
+	}
+}
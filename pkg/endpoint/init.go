@@ -2,7 +2,10 @@ package endpoint
 
 import (
 	// Import all the default drivers
+	_ "github.com/k3s-io/kine/pkg/drivers/bolt"
+	_ "github.com/k3s-io/kine/pkg/drivers/etcd"
 	_ "github.com/k3s-io/kine/pkg/drivers/http"
+	_ "github.com/k3s-io/kine/pkg/drivers/memory"
 	_ "github.com/k3s-io/kine/pkg/drivers/mysql"
 	_ "github.com/k3s-io/kine/pkg/drivers/nats"
 	_ "github.com/k3s-io/kine/pkg/drivers/pgsql"
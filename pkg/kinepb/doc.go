@@ -0,0 +1,5 @@
+// Package kinepb contains the protocol buffer messages and services for the kine-specific
+// gRPC API, which is served alongside the etcd API.
+package kinepb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative,require_unimplemented_servers=false history.proto admin.proto
@@ -27,6 +27,9 @@ type Log interface {
 	Append(ctx context.Context, event *server.Event) (int64, error)
 	DbSize(ctx context.Context) (int64, error)
 	Compact(ctx context.Context, revision int64) (int64, error)
+	RevisionAt(ctx context.Context, t time.Time) (int64, error)
+	History(ctx context.Context, key string, prefix bool, startRevision, endRevision, limit int64) (int64, []*server.Event, error)
+	Close() error
 }
 
 type ttlEventKV struct {
@@ -36,7 +39,9 @@ type ttlEventKV struct {
 }
 
 type LogStructured struct {
-	log Log
+	log    Log
+	wg     sync.WaitGroup
+	cancel context.CancelFunc
 }
 
 func New(log Log) *LogStructured {
@@ -46,6 +51,7 @@ func New(log Log) *LogStructured {
 }
 
 func (l *LogStructured) Start(ctx context.Context) error {
+	ctx, l.cancel = context.WithCancel(ctx)
 	if err := l.log.Start(ctx); err != nil {
 		return err
 	}
@@ -55,10 +61,23 @@ func (l *LogStructured) Start(ctx context.Context) error {
 			logrus.Errorf("Failed to create health check key: %v", err)
 		}
 	}
-	go l.ttl(ctx)
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		l.ttl(ctx)
+	}()
 	return nil
 }
 
+// Close stops the TTL goroutine, and then closes the underlying log.
+func (l *LogStructured) Close() error {
+	if l.cancel != nil {
+		l.cancel()
+	}
+	l.wg.Wait()
+	return l.log.Close()
+}
+
 func (l *LogStructured) Get(ctx context.Context, key, rangeEnd string, limit, revision int64) (revRet int64, kvRet *server.KeyValue, errRet error) {
 	defer func() {
 		l.adjustRevision(ctx, &revRet)
@@ -271,7 +290,9 @@ func (l *LogStructured) ttl(ctx context.Context) {
 	queue := workqueue.NewDelayingQueue()
 	rwMutex := &sync.RWMutex{}
 	ttlEventKVMap := make(map[string]*ttlEventKV)
+	l.wg.Add(1)
 	go func() {
+		defer l.wg.Done()
 		for l.handleTTLEvents(ctx, rwMutex, queue, ttlEventKVMap) {
 		}
 	}()
@@ -474,3 +495,29 @@ func (l *LogStructured) CurrentRevision(ctx context.Context) (int64, error) {
 func (l *LogStructured) Compact(ctx context.Context, revision int64) (int64, error) {
 	return l.log.Compact(ctx, revision)
 }
+
+// ManagedCompact compacts through the log's compactor, if it has one.
+func (l *LogStructured) ManagedCompact(ctx context.Context, revision int64) (int64, error) {
+	if m, ok := l.log.(server.ManagedCompactBackend); ok {
+		return m.ManagedCompact(ctx, revision)
+	}
+	return 0, server.ErrManagedCompactNotSupported
+}
+
+func (l *LogStructured) RevisionAt(ctx context.Context, t time.Time) (int64, error) {
+	return l.log.RevisionAt(ctx, t)
+}
+
+// Capabilities returns the capabilities reported by the log, if any. Logs provide history
+// unless they report otherwise, and can be compacted on request if they have a compactor.
+func (l *LogStructured) Capabilities() server.Capabilities {
+	if c, ok := l.log.(server.CapabilitiesBackend); ok {
+		return c.Capabilities()
+	}
+	_, compact := l.log.(server.ManagedCompactBackend)
+	return server.Capabilities{Compact: compact, History: true}
+}
+
+func (l *LogStructured) History(ctx context.Context, key string, prefix bool, startRevision, endRevision, limit int64) (int64, []*server.Event, error) {
+	return l.log.History(ctx, key, prefix, startRevision, endRevision, limit)
+}
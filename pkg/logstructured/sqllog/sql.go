@@ -3,32 +3,51 @@ package sqllog
 import (
 	"context"
 	"database/sql"
+	"math"
 	"math/rand/v2"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/k3s-io/kine/pkg/broadcaster"
 	"github.com/k3s-io/kine/pkg/metrics"
 	"github.com/k3s-io/kine/pkg/server"
+	"github.com/k3s-io/kine/pkg/util"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	// pollInterval is the interval at which the database is polled for rows inserted by other clients.
+	pollInterval = time.Second
+	// notifyPollInterval is the fallback poll interval used when the dialect sends notifications of new rows.
+	notifyPollInterval = 10 * time.Second
+)
+
 type SQLLog struct {
 	d                     server.Dialect
 	broadcaster           broadcaster.Broadcaster
 	ctx                   context.Context
+	cancel                context.CancelFunc
+	mu                    sync.Mutex
+	closed                bool
+	wg                    sync.WaitGroup
 	notify                chan int64
-	currentRev            int64
+	currentRev            atomic.Int64
 	compactInterval       time.Duration
 	compactIntervalJitter int
 	compactTimeout        time.Duration
 	compactMinRetain      int64
 	compactBatchSize      int64
 	pollBatchSize         int64
+	route                 string
 }
 
-func New(d server.Dialect, compactInterval time.Duration, compactIntervalJitter int, compactTimeout time.Duration, compactMinRetain int64, compactBatchSize int64, pollBatchSize int64) *SQLLog {
+// New returns a log stored in the database. The route is the prefix of the keys routed to the
+// database, or empty for the default database, and identifies its compaction status and metrics.
+func New(d server.Dialect, compactInterval time.Duration, compactIntervalJitter int, compactTimeout time.Duration, compactMinRetain int64, compactBatchSize int64, pollBatchSize int64, route string) *SQLLog {
 	l := &SQLLog{
 		d:                     d,
 		notify:                make(chan int64, 1024),
@@ -38,15 +57,29 @@ func New(d server.Dialect, compactInterval time.Duration, compactIntervalJitter
 		compactMinRetain:      compactMinRetain,
 		compactBatchSize:      compactBatchSize,
 		pollBatchSize:         pollBatchSize,
+		route:                 route,
 	}
 	return l
 }
 
 func (s *SQLLog) Start(ctx context.Context) error {
-	s.ctx = ctx
+	s.ctx, s.cancel = context.WithCancel(ctx)
 	return s.compactStart(s.ctx)
 }
 
+// Close stops the compaction and polling goroutines, and then closes the database.
+func (s *SQLLog) Close() error {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	// once closed is set, no more goroutines can be added to the wait group
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.wg.Wait()
+	return s.d.Close()
+}
+
 func (s *SQLLog) compactStart(ctx context.Context) error {
 	logrus.Tracef("COMPACTSTART")
 
@@ -117,6 +150,16 @@ func (s *SQLLog) compactor(interval time.Duration) {
 	compactRev, _ := s.d.GetCompactRevision(s.ctx)
 	targetCompactRev, _ := s.CurrentRevision(s.ctx)
 	logrus.Tracef("COMPACT starting compactRev=%d targetCompactRev=%d", compactRev, targetCompactRev)
+	metrics.ObserveCompactRevisions(s.route, compactRev, targetCompactRev)
+
+	// leadership must outlast the interval between elections, including the time spent compacting
+	leaderTTL := 2*interval + s.compactTimeout
+	leader := false
+	defer metrics.SetCompactLeader(s.route, false)
+
+	// the poll revision is recorded before the first interval, so that the leader does not
+	// compact rows that this server has yet to read
+	s.setPollRevision(leaderTTL)
 
 	for {
 		select {
@@ -125,59 +168,46 @@ func (s *SQLLog) compactor(interval time.Duration) {
 		case <-t.C:
 		}
 
-		// Break up the compaction into smaller batches to avoid locking the database with excessively
-		// long transactions. When things are working normally deletes should proceed quite quickly, but if
-		// run against a database where compaction has stalled (see rancher/k3s#1311) it may take a long time
-		// (several hundred ms) just for the database to execute the subquery to select the revisions to delete.
-
-		var (
-			resultLabel    string
-			iterCompactRev int64
-			iterStart      time.Time
-			iterCount      int64
-			compactedRev   int64
-			currentRev     int64
-			err            error
-		)
-
-		resultLabel = metrics.ResultSuccess
-		iterCompactRev = compactRev
-		compactedRev = compactRev
-		iterStart = time.Now()
-		iterCount = 0
-
-		for iterCompactRev < targetCompactRev {
-			// Set move iteration target compactBatchSize revisions forward, or
-			// just as far as we need to hit the compaction target if that would
-			// overshoot it.
-			iterCompactRev += s.compactBatchSize
-			if iterCompactRev > targetCompactRev {
-				iterCompactRev = targetCompactRev
-			}
+		s.setPollRevision(leaderTTL)
 
-			// only update the compacted and current revisions if they are valid,
-			// but break out of the inner loop on any error.
-			compacted, current, cerr := s.compact(compactedRev, iterCompactRev)
-			if compacted != 0 && current != 0 {
-				compactedRev = compacted
-				currentRev = current
-			}
-			if cerr != nil {
-				err = cerr
-				break
+		// Only the elected leader compacts, so that servers sharing a database do not
+		// contend for the compact revision.
+		elected, err := s.d.CompactLeader(s.ctx, leaderTTL)
+		if err != nil {
+			logrus.Errorf("Compaction leader election failed: %v", err)
+		}
+		if elected != leader {
+			leader = elected
+			if leader {
+				logrus.Infof("COMPACT elected compaction leader")
+				metrics.SetCompactLeader(s.route, true)
+				// another server may have compacted while we were not the leader
+				if rev, err := s.d.GetCompactRevision(s.ctx); err == nil {
+					compactRev = rev
+				}
+			} else {
+				logrus.Infof("COMPACT lost compaction leadership")
+				metrics.SetCompactLeader(s.route, false)
 			}
-			iterCount++
+		}
+		if !leader {
+			// report the progress made by the leader
+			s.observeRevisions()
+			continue
 		}
 
-		if iterCount > 0 {
-			logrus.Infof("COMPACT compacted from %d to %d in %d transactions over %s", compactRev, compactedRev, iterCount, time.Now().Sub(iterStart).Round(time.Millisecond))
-
-			// post-compact operation errors are not critical, but should be reported
-			if perr := s.postCompact(); perr != nil {
-				logrus.Errorf("Post-compact operations failed: %v", perr)
+		if s.d.CompactDryRun() {
+			currentRev, err := s.compactDryRun(compactRev, targetCompactRev)
+			if err != nil {
+				logrus.Errorf("Compact dry run failed: %v", err)
+				continue
 			}
+			targetCompactRev = currentRev
+			continue
 		}
 
+		compactedRev, currentRev, err := s.compactTo(compactRev, targetCompactRev)
+
 		// Only store the final results for this compact interval if currentRev is
 		// updated to the current compact revision.
 		//
@@ -186,16 +216,100 @@ func (s *SQLLog) compactor(interval time.Duration) {
 		if currentRev > 0 {
 			compactRev = compactedRev
 			targetCompactRev = currentRev
+			metrics.ObserveCompactRevisions(s.route, compactRev, targetCompactRev)
 		}
 
 		// ErrCompacted indicates that no further work is necessary - either compactRev changed since the
 		// last iteration because another client has compacted, or the requested revision has already been compacted.
-		if err != nil && err != server.ErrCompacted {
+		if err == server.ErrCompacted {
+			err = nil
+		}
+		if err != nil {
 			logrus.Errorf("Compact failed: %v", err)
-			resultLabel = metrics.ResultError
 		}
-		metrics.CompactTotal.WithLabelValues(resultLabel).Inc()
+		metrics.ObserveCompactResult(s.route, err)
+	}
+}
+
+// setPollRevision records the last revision read by the poll loop, so that compaction by the
+// leader does not remove rows that this server has yet to send to watchers.
+func (s *SQLLog) setPollRevision(ttl time.Duration) {
+	if err := s.d.SetPollRevision(s.ctx, s.currentRev.Load(), ttl); err != nil && !errors.Is(err, context.Canceled) {
+		logrus.Errorf("Failed to record poll revision: %v", err)
+	}
+}
+
+// compactTo compacts from compactRev to targetCompactRev, and runs any post-compact cleanup.
+// It returns the revision compacted to, and the current revision, which is zero if no
+// transaction returned it; see compact. Some batches may have been compacted even if an
+// error is returned.
+func (s *SQLLog) compactTo(compactRev, targetCompactRev int64) (int64, int64, error) {
+	// Break up the compaction into smaller batches to avoid locking the database with excessively
+	// long transactions. When things are working normally deletes should proceed quite quickly, but if
+	// run against a database where compaction has stalled (see rancher/k3s#1311) it may take a long time
+	// (several hundred ms) just for the database to execute the subquery to select the revisions to delete.
+
+	var (
+		iterCompactRev int64
+		iterStart      time.Time
+		iterCount      int64
+		compactedRev   int64
+		currentRev     int64
+		err            error
+	)
+
+	iterCompactRev = compactRev
+	compactedRev = compactRev
+	iterStart = time.Now()
+	iterCount = 0
+
+	for iterCompactRev < targetCompactRev {
+		// Set move iteration target compactBatchSize revisions forward, or
+		// just as far as we need to hit the compaction target if that would
+		// overshoot it.
+		iterCompactRev += s.compactBatchSize
+		if iterCompactRev > targetCompactRev {
+			iterCompactRev = targetCompactRev
+		}
+
+		// only update the compacted and current revisions if they are valid,
+		// but break out of the inner loop on any error.
+		compacted, current, cerr := s.compact(compactedRev, iterCompactRev)
+		if compacted != 0 && current != 0 {
+			compactedRev = compacted
+			currentRev = current
+		}
+		if cerr != nil {
+			err = cerr
+			break
+		}
+		iterCount++
+	}
+
+	if iterCount > 0 {
+		logrus.Infof("COMPACT compacted from %d to %d in %d transactions over %s", compactRev, compactedRev, iterCount, time.Now().Sub(iterStart).Round(time.Millisecond))
+
+		// post-compact operation errors are not critical, but should be reported
+		if perr := s.postCompact(); perr != nil {
+			logrus.Errorf("Post-compact operations failed: %v", perr)
+			metrics.ObservePostCompactError()
+		}
+	}
+
+	return compactedRev, currentRev, err
+}
+
+// observeRevisions records the compact and current revisions of the database.
+func (s *SQLLog) observeRevisions() {
+	compactRev, err := s.d.GetCompactRevision(s.ctx)
+	if err != nil {
+		return
 	}
+	currentRev, err := s.d.CurrentRevision(s.ctx)
+	if err != nil {
+		return
+	}
+	metrics.ObserveCompactRevisions(s.route, compactRev, currentRev)
 }
 
 // compact removes deleted or replaced rows from the database, and updates the compact rev key.
@@ -238,6 +352,21 @@ func (s *SQLLog) compact(compactRev int64, targetCompactRev int64) (int64, int64
 	// Ensure that we never compact the most recent 1000 revisions
 	targetCompactRev = safeCompactRev(targetCompactRev, currentRev, s.compactMinRetain)
 
+	// Don't compact past the last revision read by the poll loop of this or any other server
+	// sharing the database, as the previous values of rows that have not yet been sent to
+	// watchers may be read from the rows being compacted. Other servers record their poll
+	// revision once per compact interval, so compaction may lag behind them by an interval.
+	if pollRev := s.currentRev.Load(); pollRev != 0 && targetCompactRev > pollRev {
+		targetCompactRev = pollRev
+	}
+	pollRev, err := s.d.MinPollRevision(s.ctx)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to get poll revision")
+	}
+	if pollRev != 0 && targetCompactRev > pollRev {
+		targetCompactRev = pollRev
+	}
+
 	// Don't bother compacting to a revision that has already been compacted
 	if targetCompactRev <= compactRev {
 		logrus.Tracef("COMPACT revision %d has already been compacted", targetCompactRev)
@@ -260,19 +389,57 @@ func (s *SQLLog) compact(compactRev int64, targetCompactRev int64) (int64, int64
 	// updating the compact revision without any errors. The deferred rollback
 	// becomes a no-op if the transaction is committed.
 	t.MustCommit()
+	metrics.ObserveCompactBatch(s.route, start, deletedRows, targetCompactRev)
 	logrus.Infof("COMPACT deleted %d rows from %d revisions in %s - compacted to %d/%d", deletedRows, (targetCompactRev - compactRev), time.Since(start), targetCompactRev, currentRev)
 
 	return targetCompactRev, currentRev, nil
 }
 
+// compactDryRun logs the number of rows that would be removed by compacting to the target
+// revision under each retention rule, without removing them. It returns the current revision.
+func (s *SQLLog) compactDryRun(compactRev int64, targetCompactRev int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.compactTimeout)
+	defer cancel()
+
+	currentRev, err := s.d.CurrentRevision(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get current revision")
+	}
+
+	targetCompactRev = safeCompactRev(targetCompactRev, currentRev, s.compactMinRetain)
+	if targetCompactRev <= compactRev {
+		return currentRev, nil
+	}
+
+	report, err := s.d.CompactReport(ctx, targetCompactRev)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to report compaction to revision %d", targetCompactRev)
+	}
+
+	prefixes := make([]string, 0, len(report))
+	for prefix := range report {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		rule := "prefix " + prefix
+		if prefix == "" {
+			rule = "keys without a retention rule"
+		}
+		logrus.Infof("COMPACT dry run to revision %d: would delete %d rows for %s", targetCompactRev, report[prefix], rule)
+	}
+	logrus.Infof("COMPACT dry run to revision %d: rows kept by retention rules can only be read with the history API; range and watch requests before revision %d would fail as compacted", targetCompactRev, targetCompactRev)
+	return currentRev, nil
+}
+
 // postCompact executes any post-compact database cleanup - vacuuming, WAL truncate, etc.
 func (s *SQLLog) postCompact() error {
 	return s.d.PostCompact(s.ctx)
 }
 
 func (s *SQLLog) CurrentRevision(ctx context.Context) (int64, error) {
-	if s.currentRev != 0 {
-		return s.currentRev, nil
+	if rev := s.currentRev.Load(); rev != 0 {
+		return rev, nil
 	}
 	return s.d.CurrentRevision(ctx)
 }
@@ -281,6 +448,80 @@ func (s *SQLLog) CompactRevision(ctx context.Context) (int64, error) {
 	return s.d.GetCompactRevision(ctx)
 }
 
+// RevisionAt returns the latest revision created at or before the given time. Rows created
+// before the time may have been removed by compaction, so ErrCompacted is returned if the
+// revision found is below the compact revision.
+func (s *SQLLog) RevisionAt(ctx context.Context, t time.Time) (int64, error) {
+	rev, err := s.d.RevisionAt(ctx, t)
+	if err != nil {
+		return 0, err
+	}
+	compactRev, err := s.d.GetCompactRevision(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if rev < compactRev {
+		return 0, server.ErrCompacted
+	}
+	return rev, nil
+}
+
+// History returns the retained revisions of the key, or of all keys with the key as a prefix,
+// between the start and end revisions inclusive.
+func (s *SQLLog) History(ctx context.Context, key string, prefix bool, startRevision, endRevision, limit int64) (int64, []*server.Event, error) {
+	if endRevision <= 0 {
+		endRevision = math.MaxInt64
+	}
+
+	var (
+		rev    int64
+		result []*server.Event
+	)
+	for {
+		rows, err := s.d.History(ctx, key, prefix, startRevision, endRevision, limit)
+		if err != nil {
+			return 0, nil, err
+		}
+		pageRev, _, events, err := RowsToEvents(rows)
+		if err != nil {
+			return 0, nil, err
+		}
+		if len(events) > 0 {
+			rev = pageRev
+		}
+
+		// LIKE treats underscores in the prefix as wildcards, so drop any rows for other keys,
+		// along with any gap fill records
+		for _, event := range events {
+			if s.d.IsFill(event.KV.Key) {
+				continue
+			}
+			if event.KV.Key == key || (prefix && strings.HasPrefix(event.KV.Key, key)) {
+				result = append(result, event)
+			}
+		}
+
+		// rows that were dropped count towards the limit, so keep reading until the limit is
+		// reached or there are no more rows
+		if limit <= 0 || int64(len(events)) < limit || int64(len(result)) >= limit {
+			break
+		}
+		startRevision = events[len(events)-1].KV.ModRevision + 1
+	}
+
+	if rev == 0 {
+		// a zero length result won't have the current revision so get it manually
+		var err error
+		if rev, err = s.CurrentRevision(ctx); err != nil {
+			return 0, nil, err
+		}
+	}
+	if limit > 0 && int64(len(result)) > limit {
+		result = result[:limit]
+	}
+	return rev, result, nil
+}
+
 func (s *SQLLog) After(ctx context.Context, prefix string, revision, limit int64) (int64, []*server.Event, error) {
 	if strings.HasSuffix(prefix, "/") {
 		prefix += "%"
@@ -316,10 +557,11 @@ func (s *SQLLog) List(ctx context.Context, prefix, startKey string, limit, revis
 	var (
 		rows *sql.Rows
 		err  error
+		all  = prefix == ""
 	)
 
 	// It's assumed that when there is a start key that that key exists.
-	if strings.HasSuffix(prefix, "/") {
+	if all || strings.HasSuffix(prefix, "/") {
 		// In the situation of a list start the startKey will not exist so set to ""
 		if prefix == startKey {
 			startKey = ""
@@ -364,6 +606,17 @@ func (s *SQLLog) List(ctx context.Context, prefix, startKey string, limit, revis
 		return rev, nil, server.ErrCompacted
 	}
 
+	if all {
+		// the compact revision is recorded in a key outside of the keyspace seen by clients
+		keys := result[:0]
+		for _, event := range result {
+			if event.KV.Key != "compact_rev_key" {
+				keys = append(keys, event)
+			}
+		}
+		result = keys
+	}
+
 	select {
 	case s.notify <- rev:
 	default:
@@ -395,7 +648,12 @@ func (s *SQLLog) Watch(ctx context.Context, prefix string) <-chan []*server.Even
 	res := make(chan []*server.Event, 100)
 	values, err := s.broadcaster.Subscribe(ctx, s.startWatch)
 	if err != nil {
-		return nil
+		if !errors.Is(err, context.Canceled) {
+			logrus.Errorf("Failed to start watch: %v", err)
+		}
+		// callers range over the channel, so it must be closed rather than nil
+		close(res)
+		return res
 	}
 
 	checkPrefix := strings.HasSuffix(prefix, "/")
@@ -427,12 +685,23 @@ func filter(events interface{}, checkPrefix bool, prefix string) ([]*server.Even
 }
 
 func (s *SQLLog) startWatch() (chan interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, context.Canceled
+	}
+	if err := s.ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	pollStart, err := s.d.CurrentRevision(s.ctx)
 	if err != nil {
 		return nil, err
 	}
 
 	c := make(chan interface{})
+	s.currentRev.Store(pollStart)
 
 	if s.compactIntervalJitter < 0 || s.compactIntervalJitter > 100 {
 		panic("jitterPercent must be between 0 and 100")
@@ -440,23 +709,46 @@ func (s *SQLLog) startWatch() (chan interface{}, error) {
 	maxJitter := float64(s.compactIntervalJitter) / 100.0 * float64(s.compactInterval)
 	jitter := time.Duration(rand.Float64()*2*maxJitter - maxJitter)
 
+	// if the database notifies us of rows inserted by other clients, the
+	// poll interval only needs to cover notifications that were missed
+	interval := pollInterval
+	if notifications := s.d.Notifications(s.ctx); notifications != nil {
+		interval = notifyPollInterval
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			for rev := range notifications {
+				select {
+				case s.notify <- rev:
+				default:
+				}
+			}
+		}()
+	}
+
 	// start compaction and polling at the same time to watch starts
 	// at the oldest revision, but compaction doesn't create gaps
-	go s.compactor(s.compactInterval + jitter)
-	go s.poll(c, pollStart)
+	s.wg.Add(2)
+	go func() {
+		defer s.wg.Done()
+		s.compactor(s.compactInterval + jitter)
+	}()
+	go func() {
+		defer s.wg.Done()
+		s.poll(c, interval)
+	}()
 	return c, nil
 }
 
-func (s *SQLLog) poll(result chan interface{}, pollStart int64) {
-	s.currentRev = pollStart
-
+func (s *SQLLog) poll(result chan interface{}, interval time.Duration) {
 	var (
 		skip        int64
 		skipTime    time.Time
 		waitForMore = true
+		failovers   = s.d.Failovers()
 	)
 
-	wait := time.NewTicker(time.Second)
+	wait := time.NewTicker(interval)
 	defer wait.Stop()
 	defer close(result)
 
@@ -466,7 +758,7 @@ func (s *SQLLog) poll(result chan interface{}, pollStart int64) {
 			case <-s.ctx.Done():
 				return
 			case check := <-s.notify:
-				if check <= s.currentRev {
+				if check <= s.currentRev.Load() {
 					continue
 				}
 			case <-wait.C:
@@ -474,7 +766,18 @@ func (s *SQLLog) poll(result chan interface{}, pollStart int64) {
 		}
 		waitForMore = true
 
-		rows, err := s.d.After(s.ctx, "%", s.currentRev, s.pollBatchSize)
+		if f := s.d.Failovers(); f != failovers {
+			if _, err := s.checkFailoverRevision(); err != nil {
+				logrus.Errorf("Failed to check revision after failover: %v", err)
+				continue
+			}
+			failovers = f
+			// missing revisions may have been committed by the previous writer, and not yet
+			// replicated to the new writer, so restart the wait before they are skipped
+			skip = 0
+		}
+
+		rows, err := s.d.After(s.ctx, "%", s.currentRev.Load(), s.pollBatchSize)
 		if err != nil {
 			if !errors.Is(err, context.Canceled) {
 				logrus.Errorf("fail to list latest changes: %v", err)
@@ -488,15 +791,25 @@ func (s *SQLLog) poll(result chan interface{}, pollStart int64) {
 			continue
 		}
 
-		logrus.Tracef("POLL AFTER %d, limit=%d, events=%d", s.currentRev, s.pollBatchSize, len(events))
+		logrus.Tracef("POLL AFTER %d, limit=%d, events=%d", s.currentRev.Load(), s.pollBatchSize, len(events))
 
 		if len(events) == 0 {
+			// connections to a writer that has failed are replaced by connections to the new
+			// writer without a write finding the database read-only, so also check that the
+			// revision has not gone backwards whenever there is nothing new to send
+			if advanced, err := s.checkFailoverRevision(); err != nil {
+				if !errors.Is(err, context.Canceled) {
+					logrus.Errorf("Failed to check revision for failover: %v", err)
+				}
+			} else if advanced {
+				skip = 0
+			}
 			continue
 		}
 
 		waitForMore = len(events) < 100
 
-		rev := s.currentRev
+		rev := s.currentRev.Load()
 		var (
 			sequential []*server.Event
 			saveLast   bool
@@ -512,6 +825,7 @@ func (s *SQLLog) poll(result chan interface{}, pollStart int64) {
 					// This situation should never happen, but we have it here as a fallback just for unknown reasons
 					// we don't want to pause all watches forever
 					logrus.Errorf("GAP %s, revision=%d, delete=%v, next=%d", event.KV.Key, event.KV.ModRevision, event.Delete, next)
+					metrics.ObserveGapFill(next, metrics.FillResultSkipped)
 				} else if skip != next {
 					// This is the first time we have encountered this missing revision, so record time start
 					// and trigger a quick retry for simple out of order events
@@ -531,12 +845,14 @@ func (s *SQLLog) poll(result chan interface{}, pollStart int64) {
 				} else {
 					if err := s.d.Fill(s.ctx, next); err == nil {
 						logrus.Tracef("FILL, revision=%d, err=%v", next, err)
+						metrics.ObserveGapFill(next, metrics.FillResultFilled)
 						select {
 						case s.notify <- next:
 						default:
 						}
 					} else {
 						logrus.Tracef("FILL FAILED, revision=%d, err=%v", next, err)
+						metrics.ObserveGapFill(next, metrics.ResultError)
 					}
 					break
 				}
@@ -558,7 +874,7 @@ func (s *SQLLog) poll(result chan interface{}, pollStart int64) {
 		}
 
 		if saveLast {
-			s.currentRev = rev
+			s.currentRev.Store(rev)
 			if len(sequential) > 0 {
 				result <- sequential
 			}
@@ -566,12 +882,33 @@ func (s *SQLLog) poll(result chan interface{}, pollStart int64) {
 	}
 }
 
+// checkFailoverRevision ensures that the revision of the database has not gone backwards after
+// failing over to a new writer, as happens if writes acknowledged by the previous writer were
+// not replicated before it failed. Watches have already been sent events up to the current
+// revision, so the revision is advanced to prevent new writes from reusing those revisions.
+// It returns true if the revision was advanced.
+func (s *SQLLog) checkFailoverRevision() (bool, error) {
+	rev, err := s.d.CurrentRevision(s.ctx)
+	if err != nil {
+		return false, err
+	}
+	currentRev := s.currentRev.Load()
+	if rev >= currentRev {
+		return false, nil
+	}
+	logrus.Errorf("Database revision %d is behind the last revision %d sent to watches after failover; writes to the previous writer have been lost", rev, currentRev)
+	if err := s.d.AdvanceRevision(s.ctx, currentRev); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func canSkipRevision(rev, skip int64, skipTime time.Time) bool {
 	return rev == skip && time.Since(skipTime) > time.Second
 }
 
 func (s *SQLLog) Count(ctx context.Context, prefix, startKey string, revision int64) (int64, int64, error) {
-	if strings.HasSuffix(prefix, "/") {
+	if prefix == "" || strings.HasSuffix(prefix, "/") {
 		prefix += "%"
 	}
 
@@ -614,6 +951,8 @@ func scan(rows *sql.Rows, rev *int64, compact *int64, event *server.Event) error
 	event.PrevKV = &server.KeyValue{}
 
 	c := &sql.NullInt64{}
+	compression := &sql.NullInt64{}
+	prevCompression := &sql.NullInt64{}
 
 	err := rows.Scan(
 		rev,
@@ -627,11 +966,19 @@ func scan(rows *sql.Rows, rev *int64, compact *int64, event *server.Event) error
 		&event.KV.Lease,
 		&event.KV.Value,
 		&event.PrevKV.Value,
+		compression,
+		prevCompression,
 	)
 	if err != nil {
 		return err
 	}
 
+	if compression.Int64 != 0 || prevCompression.Int64 != 0 {
+		if err := decompress(util.Compression(compression.Int64), util.Compression(prevCompression.Int64), event); err != nil {
+			return errors.Wrapf(err, "decompressing revision %d", event.KV.ModRevision)
+		}
+	}
+
 	if event.Create {
 		event.KV.CreateRevision = event.KV.ModRevision
 		event.PrevKV = nil
@@ -641,6 +988,22 @@ func scan(rows *sql.Rows, rev *int64, compact *int64, event *server.Event) error
 	return nil
 }
 
+// decompress decompresses the event's value and previous value in place. The previous value
+// may have been compressed with a different algorithm if it was read from the previous revision.
+func decompress(compression, prevCompression util.Compression, event *server.Event) error {
+	value, err := compression.Decompress(event.KV.Value)
+	if err != nil {
+		return err
+	}
+	prevValue, err := prevCompression.Decompress(event.PrevKV.Value)
+	if err != nil {
+		return err
+	}
+	event.KV.Value = value
+	event.PrevKV.Value = prevValue
+	return nil
+}
+
 // safeCompactRev ensures that we never compact the most recent 1000 revisions.
 func safeCompactRev(targetCompactRev int64, currentRev int64, compactMinRetain int64) int64 {
 	safeRev := currentRev - compactMinRetain
@@ -657,6 +1020,64 @@ func (s *SQLLog) DbSize(ctx context.Context) (int64, error) {
 	return s.d.GetSize(ctx)
 }
 
+// Capabilities returns the capabilities of the log. Gaps in the revision sequence are filled
+// when polling for changes.
+func (s *SQLLog) Capabilities() server.Capabilities {
+	return server.Capabilities{
+		Compact: true,
+		GapFill: true,
+		History: true,
+	}
+}
+
 func (s *SQLLog) Compact(ctx context.Context, revision int64) (int64, error) {
 	return s.d.Compact(ctx, revision)
 }
+
+// ManagedCompact compacts the log to the revision using the same transactions as the compactor, so
+// that the compact revision is recorded, and the minimum retained revisions, retention rules
+// and the last revision sent to watches are respected; the log may be compacted to an earlier
+// revision than requested. Only the compaction leader compacts. The current revision is returned.
+func (s *SQLLog) ManagedCompact(ctx context.Context, revision int64) (int64, error) {
+	elected, err := s.d.CompactLeader(ctx, 2*s.compactInterval+s.compactTimeout)
+	if err != nil {
+		return 0, errors.Wrap(err, "compaction leader election failed")
+	}
+	if !elected {
+		return 0, server.ErrNotCompactLeader
+	}
+
+	compactRev, err := s.d.GetCompactRevision(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get compact revision")
+	}
+	currentRev, err := s.d.CurrentRevision(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get current revision")
+	}
+	if revision <= compactRev {
+		return currentRev, server.ErrCompacted
+	}
+	if revision > currentRev {
+		return currentRev, server.ErrFutureRev
+	}
+
+	if s.d.CompactDryRun() {
+		return s.compactDryRun(compactRev, revision)
+	}
+
+	compactedRev, rev, err := s.compactTo(compactRev, revision)
+	if rev > 0 {
+		currentRev = rev
+		metrics.ObserveCompactRevisions(s.route, compactedRev, currentRev)
+	}
+	// ErrCompacted indicates that there was nothing left to compact, or that another server compacted
+	if err == server.ErrCompacted {
+		err = nil
+	}
+	metrics.ObserveCompactResult(s.route, err)
+	if err != nil {
+		return 0, err
+	}
+	return currentRev, nil
+}
@@ -0,0 +1,171 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const compactionPath = "/debug/compaction"
+
+var (
+	CompactRevision = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kine_compact_revision",
+		Help: "Revision that the database has been compacted to, by route",
+	}, []string{"route"})
+
+	CompactCurrentRevision = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kine_compact_current_revision",
+		Help: "Current revision of the database, as of the last compaction, by route",
+	}, []string{"route"})
+
+	CompactLag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kine_compact_lag_revisions",
+		Help: "Number of revisions between the compact revision and the current revision, by route",
+	}, []string{"route"})
+
+	CompactBatchDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "kine_compact_batch_duration_seconds",
+		Help:    "Length of time per compaction batch transaction",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 12),
+	})
+
+	CompactBatchDeletedRows = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "kine_compact_batch_deleted_rows",
+		Help:    "Number of rows deleted per compaction batch transaction",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 10),
+	})
+
+	PostCompactErrorsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "kine_post_compact_errors_total",
+		Help: "Total number of failed post-compaction operations",
+	})
+
+	CompactLastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kine_compact_last_success_timestamp_seconds",
+		Help: "Time of the last successful compaction, in seconds since the epoch, by route",
+	}, []string{"route"})
+)
+
+// CompactionStatus is the progress of compaction, as reported by the compaction debug endpoint.
+type CompactionStatus struct {
+	Leader          bool             `json:"leader"`
+	CompactRevision int64            `json:"compactRevision"`
+	CurrentRevision int64            `json:"currentRevision"`
+	Lag             int64            `json:"lag"`
+	LastSuccess     *time.Time       `json:"lastSuccess,omitempty"`
+	LastError       string           `json:"lastError,omitempty"`
+	LastErrorTime   *time.Time       `json:"lastErrorTime,omitempty"`
+	LastBatch       *CompactionBatch `json:"lastBatch,omitempty"`
+}
+
+// CompactionBatch is the result of a single compaction batch transaction.
+type CompactionBatch struct {
+	Time            time.Time `json:"time"`
+	DurationSeconds float64   `json:"durationSeconds"`
+	DeletedRows     int64     `json:"deletedRows"`
+	Revision        int64     `json:"revision"`
+}
+
+// Each database runs its own compactor, so compaction status is recorded by the prefix of the
+// route that the database serves. The default database has an empty route.
+var (
+	compactionMu     sync.Mutex
+	compactionStatus = map[string]*CompactionStatus{}
+)
+
+// routeStatus returns the compaction status of the route. The caller must hold compactionMu.
+func routeStatus(route string) *CompactionStatus {
+	status, ok := compactionStatus[route]
+	if !ok {
+		status = &CompactionStatus{}
+		compactionStatus[route] = status
+	}
+	return status
+}
+
+// SetCompactLeader records whether this server is the elected compaction leader for the route.
+func SetCompactLeader(route string, leader bool) {
+	compactionMu.Lock()
+	defer compactionMu.Unlock()
+	routeStatus(route).Leader = leader
+	if leader {
+		CompactLeader.WithLabelValues(route).Set(1)
+	} else {
+		CompactLeader.WithLabelValues(route).Set(0)
+	}
+}
+
+// ObserveCompactRevisions records the compact and current revisions of the route's database.
+func ObserveCompactRevisions(route string, compactRev, currentRev int64) {
+	compactionMu.Lock()
+	defer compactionMu.Unlock()
+	status := routeStatus(route)
+	status.CompactRevision = compactRev
+	status.CurrentRevision = currentRev
+	status.Lag = currentRev - compactRev
+	CompactRevision.WithLabelValues(route).Set(float64(compactRev))
+	CompactCurrentRevision.WithLabelValues(route).Set(float64(currentRev))
+	CompactLag.WithLabelValues(route).Set(float64(currentRev - compactRev))
+}
+
+// ObserveCompactBatch records a committed compaction batch transaction on the route's database.
+func ObserveCompactBatch(route string, start time.Time, deletedRows, revision int64) {
+	duration := time.Since(start)
+	CompactBatchDuration.Observe(duration.Seconds())
+	CompactBatchDeletedRows.Observe(float64(deletedRows))
+
+	compactionMu.Lock()
+	defer compactionMu.Unlock()
+	routeStatus(route).LastBatch = &CompactionBatch{
+		Time:            start,
+		DurationSeconds: duration.Seconds(),
+		DeletedRows:     deletedRows,
+		Revision:        revision,
+	}
+}
+
+// ObserveCompactResult records the result of a compaction of the route's database.
+func ObserveCompactResult(route string, err error) {
+	now := time.Now()
+	compactionMu.Lock()
+	defer compactionMu.Unlock()
+	status := routeStatus(route)
+	if err != nil {
+		CompactTotal.WithLabelValues(ResultError).Inc()
+		status.LastError = err.Error()
+		status.LastErrorTime = &now
+		return
+	}
+	CompactTotal.WithLabelValues(ResultSuccess).Inc()
+	CompactLastSuccess.WithLabelValues(route).Set(float64(now.Unix()))
+	status.LastSuccess = &now
+}
+
+// ObservePostCompactError records a failed post-compaction operation.
+func ObservePostCompactError() {
+	PostCompactErrorsTotal.Inc()
+}
+
+// GetCompactionStatus returns the current compaction status of the route's database. The
+// status is empty if the route has no compactor.
+func GetCompactionStatus(route string) CompactionStatus {
+	compactionMu.Lock()
+	defer compactionMu.Unlock()
+	if status, ok := compactionStatus[route]; ok {
+		return *status
+	}
+	return CompactionStatus{}
+}
+
+// compactionHandler returns the compaction status of the route given by the route query
+// parameter, or of the default database if there is none.
+func compactionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(GetCompactionStatus(r.URL.Query().Get("route"))); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	FillResultFilled  = "filled"
+	FillResultSkipped = "skipped"
+
+	// maxGapFills is the number of recent gap fills retained for the admin API.
+	maxGapFills = 100
+)
+
+var (
+	GapFillTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kine_gap_fill_total",
+		Help: "Total number of gaps in the revision sequence, by how they were resolved",
+	}, []string{"result"})
+)
+
+// GapFill is a gap in the revision sequence found while polling for changes.
+type GapFill struct {
+	Time     time.Time
+	Revision int64
+	Result   string
+}
+
+var (
+	gapFillMu sync.Mutex
+	gapFills  []GapFill
+)
+
+// ObserveGapFill records how a gap in the revision sequence was resolved. The result is
+// one of FillResultFilled, FillResultSkipped, or ResultError.
+func ObserveGapFill(revision int64, result string) {
+	GapFillTotal.WithLabelValues(result).Inc()
+
+	gapFillMu.Lock()
+	defer gapFillMu.Unlock()
+	if len(gapFills) == maxGapFills {
+		gapFills = append(gapFills[:0], gapFills[1:]...)
+	}
+	gapFills = append(gapFills, GapFill{
+		Time:     time.Now(),
+		Revision: revision,
+		Result:   result,
+	})
+}
+
+// GetGapFills returns the most recent gap fills, oldest first.
+func GetGapFills() []GapFill {
+	gapFillMu.Lock()
+	defer gapFillMu.Unlock()
+	return append([]GapFill(nil), gapFills...)
+}
@@ -26,15 +26,51 @@ var (
 			1.5, 2.0, 2.5, 3.0, 3.5, 4.0, 4.5, 5, 6, 7, 8, 9, 10, 15, 20, 25, 30},
 	}, []string{"error_code"})
 
+	SQLFailoverTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "kine_sql_failover_total",
+		Help: "Total number of times that writes found the database read-only, and reconnected to find the current writer",
+	})
+
+	ReplicaLag = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "kine_replica_lag_revisions",
+		Help: "Number of revisions that the read replica is behind the primary database, or -1 if the replica is unavailable",
+	})
+
+	CircuitBreakerState = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "kine_circuit_breaker_state",
+		Help: "State of the backend circuit breaker: closed (0), open (1), or half-open while probing (2)",
+	})
+
+	CircuitBreakerRejectedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "kine_circuit_breaker_rejected_total",
+		Help: "Total number of requests failed without calling the backend because the circuit breaker was open",
+	})
+
 	CompactTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "kine_compact_total",
 		Help: "Total number of compactions",
 	}, []string{"result"})
 
+	CompactLeader = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kine_compact_leader",
+		Help: "Whether this server is the elected compaction leader (1) or not (0), by route",
+	}, []string{"route"})
+
 	InsertErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "kine_insert_errors_total",
 		Help: "Total number of insert retries due to unique constraint violations",
 	}, []string{"retriable"})
+
+	CompressionRatio = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kine_compression_ratio",
+		Help:    "Ratio of compressed to uncompressed size for compressed values",
+		Buckets: prometheus.LinearBuckets(0.1, 0.1, 10),
+	}, []string{"algorithm"})
+
+	OldValueSavedBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "kine_old_value_saved_bytes_total",
+		Help: "Total size of previous values not stored because they are resolved from the previous revision",
+	})
 )
 
 var (
@@ -41,6 +41,7 @@ func Serve(ctx context.Context, config Config) {
 	})
 	mux := http.NewServeMux()
 	mux.Handle(metricsPath, handler)
+	mux.HandleFunc(compactionPath, compactionHandler)
 
 	if config.EnableProfiling {
 		mux.HandleFunc("/debug/pprof/", pprof.Index)
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"strings"
+	"time"
+
+	"github.com/k3s-io/kine/pkg/kinepb"
+	"github.com/k3s-io/kine/pkg/metrics"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// explicit interface check
+var _ kinepb.AdminServer = (*adminServer)(nil)
+
+var (
+	ErrAdminDisabled     = status.New(codes.PermissionDenied, "kine admin API is not enabled").Err()
+	ErrAdminUnauthorized = status.New(codes.Unauthenticated, "invalid or missing admin token").Err()
+
+	// ErrManagedCompactNotSupported is returned by the admin Compact RPC if the backend does
+	// not run its own compactor.
+	ErrManagedCompactNotSupported = unsupported("compaction through the compactor")
+)
+
+// adminServer implements the kine.v1.Admin service. It is separate from the bridge, as the
+// admin RPCs require their own authorization, and some method names clash with the etcd API.
+type adminServer struct {
+	bridge *KVServerBridge
+}
+
+// authorize checks that the request carries the admin token as a bearer token.
+func (a *adminServer) authorize(ctx context.Context) error {
+	token := a.bridge.adminToken
+	if token == "" {
+		return ErrAdminDisabled
+	}
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ErrAdminUnauthorized
+	}
+	for _, auth := range md.Get("authorization") {
+		bearer, ok := strings.CutPrefix(auth, "Bearer ")
+		if ok && subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) == 1 {
+			return nil
+		}
+	}
+	return ErrAdminUnauthorized
+}
+
+func (a *adminServer) Compact(ctx context.Context, r *kinepb.CompactRequest) (*kinepb.CompactResponse, error) {
+	if err := a.authorize(ctx); err != nil {
+		return nil, err
+	}
+	if r.Revision <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "revision must be positive")
+	}
+
+	logrus.Infof("ADMIN COMPACT revision=%d", r.Revision)
+	// the raw Compact does not record the compact revision or apply the compactor's leader
+	// election, minimum retain and retention rules, so it is not used as a fallback
+	backend, ok := a.bridge.limited.backend.(ManagedCompactBackend)
+	if !ok {
+		return nil, ErrManagedCompactNotSupported
+	}
+	rev, err := backend.ManagedCompact(ctx, r.Revision)
+	if err != nil {
+		return nil, err
+	}
+	return &kinepb.CompactResponse{
+		CurrentRevision: rev,
+	}, nil
+}
+
+func (a *adminServer) CompactionStatus(ctx context.Context, r *kinepb.CompactionStatusRequest) (*kinepb.CompactionStatusResponse, error) {
+	if err := a.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	s := metrics.GetCompactionStatus(r.Route)
+	resp := &kinepb.CompactionStatusResponse{
+		Leader:          s.Leader,
+		CompactRevision: s.CompactRevision,
+		CurrentRevision: s.CurrentRevision,
+		LastSuccess:     toTimestamp(s.LastSuccess),
+		LastError:       s.LastError,
+		LastErrorTime:   toTimestamp(s.LastErrorTime),
+	}
+	if s.LastBatch != nil {
+		resp.LastBatchRevision = s.LastBatch.Revision
+		resp.LastBatchDeletedRows = s.LastBatch.DeletedRows
+		resp.LastBatchTime = timestamppb.New(s.LastBatch.Time)
+	}
+	return resp, nil
+}
+
+func (a *adminServer) ListWatches(ctx context.Context, _ *kinepb.ListWatchesRequest) (*kinepb.ListWatchesResponse, error) {
+	if err := a.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	resp := &kinepb.ListWatchesResponse{}
+	for _, info := range a.bridge.watches.list() {
+		resp.Watches = append(resp.Watches, &kinepb.Watch{
+			Id:             info.id,
+			Key:            []byte(info.key),
+			StartRevision:  info.startRevision,
+			Revision:       info.revision.Load(),
+			ProgressNotify: info.progressNotify,
+			Created:        timestamppb.New(info.created),
+		})
+	}
+	return resp, nil
+}
+
+func (a *adminServer) ListGapFills(ctx context.Context, _ *kinepb.ListGapFillsRequest) (*kinepb.ListGapFillsResponse, error) {
+	if err := a.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	resp := &kinepb.ListGapFillsResponse{}
+	for _, fill := range metrics.GetGapFills() {
+		resp.GapFills = append(resp.GapFills, &kinepb.GapFill{
+			Revision: fill.Revision,
+			Result:   fill.Result,
+			Time:     timestamppb.New(fill.Time),
+		})
+	}
+	return resp, nil
+}
+
+func (a *adminServer) SetLogLevel(ctx context.Context, r *kinepb.SetLogLevelRequest) (*kinepb.SetLogLevelResponse, error) {
+	if err := a.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	previous := logrus.GetLevel()
+	if r.Level == "" {
+		return &kinepb.SetLogLevelResponse{
+			PreviousLevel: previous.String(),
+			Level:         previous.String(),
+		}, nil
+	}
+	level, err := logrus.ParseLevel(r.Level)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	logrus.SetLevel(level)
+	logrus.Infof("ADMIN SET LOG LEVEL %s => %s", previous, level)
+	return &kinepb.SetLogLevelResponse{
+		PreviousLevel: previous.String(),
+		Level:         level.String(),
+	}, nil
+}
+
+func (a *adminServer) Capabilities(ctx context.Context, _ *kinepb.CapabilitiesRequest) (*kinepb.CapabilitiesResponse, error) {
+	if err := a.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	var capabilities Capabilities
+	if c, ok := a.bridge.limited.backend.(CapabilitiesBackend); ok {
+		capabilities = c.Capabilities()
+	}
+	return &kinepb.CapabilitiesResponse{
+		History:             capabilities.History,
+		Compact:             capabilities.Compact,
+		GapFill:             capabilities.GapFill,
+		Encryption:          capabilities.Encryption,
+		EmulatedEtcdVersion: a.bridge.emulatedETCDVersion,
+	}, nil
+}
+
+func toTimestamp(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
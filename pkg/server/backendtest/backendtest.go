@@ -0,0 +1,160 @@
+// Package backendtest provides conformance tests for implementations of server.Backend.
+package backendtest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/k3s-io/kine/pkg/server"
+)
+
+// Run runs the conformance tests against backends returned by setup. Each test calls setup for
+// a new backend, which must be started, and closed when the test completes. Tests only use keys
+// under "/a/" and "/b/", and make no assumptions about the revision of the first write, so that
+// backends may write other keys when started.
+func Run(t *testing.T, setup func(t *testing.T) server.Backend) {
+	t.Run("CreateUpdateDelete", func(t *testing.T) { testCreateUpdateDelete(t, setup(t)) })
+	t.Run("ListCount", func(t *testing.T) { testListCount(t, setup(t)) })
+	t.Run("Watch", func(t *testing.T) { testWatch(t, setup(t)) })
+}
+
+func testCreateUpdateDelete(t *testing.T, b server.Backend) {
+	ctx := context.Background()
+
+	rev, err := b.Create(ctx, "/a/a", []byte("1"), 0)
+	noErr(t, err)
+
+	_, err = b.Create(ctx, "/a/a", []byte("1"), 0)
+	expEqualErr(t, server.ErrKeyExists, err)
+
+	urev, kv, ok, err := b.Update(ctx, "/a/a", []byte("2"), rev, 0)
+	noErr(t, err)
+	expEqual(t, true, ok)
+	expEqual(t, rev+1, urev)
+	expEqual(t, rev, kv.CreateRevision)
+	expEqual(t, urev, kv.ModRevision)
+
+	// stale revision
+	_, kv, ok, err = b.Update(ctx, "/a/a", []byte("3"), rev, 0)
+	noErr(t, err)
+	expEqual(t, false, ok)
+	expEqual(t, urev, kv.ModRevision)
+
+	drev, kv, ok, err := b.Delete(ctx, "/a/a", urev)
+	noErr(t, err)
+	expEqual(t, true, ok)
+	expEqual(t, urev+1, drev)
+	expEqual(t, "2", string(kv.Value))
+
+	_, kv, err = b.Get(ctx, "/a/a", "", 1, 0)
+	noErr(t, err)
+	if kv != nil {
+		t.Fatalf("expected deleted key, got %v", kv)
+	}
+
+	// read the old value at a historical revision
+	_, kv, err = b.Get(ctx, "/a/a", "", 1, rev)
+	noErr(t, err)
+	expEqual(t, "1", string(kv.Value))
+
+	crev, err := b.Create(ctx, "/a/a", []byte("4"), 0)
+	noErr(t, err)
+	expEqual(t, drev+1, crev)
+}
+
+func testListCount(t *testing.T, b server.Backend) {
+	ctx := context.Background()
+
+	var revs []int64
+	for _, key := range []string{"/a/c", "/a/b", "/a/d", "/b/a"} {
+		rev, err := b.Create(ctx, key, nil, 0)
+		noErr(t, err)
+		revs = append(revs, rev)
+	}
+
+	rev, kvs, err := b.List(ctx, "/a/", "", 0, 0)
+	noErr(t, err)
+	expEqual(t, revs[3], rev)
+	expEqual(t, 3, len(kvs))
+	expEqual(t, "/a/b", kvs[0].Key)
+	expEqual(t, "/a/d", kvs[2].Key)
+
+	_, kvs, err = b.List(ctx, "/a/", "/a/b", 1, 0)
+	noErr(t, err)
+	expEqual(t, 1, len(kvs))
+	expEqual(t, "/a/c", kvs[0].Key)
+
+	// list at the revision before /a/d was created
+	_, kvs, err = b.List(ctx, "/a/", "", 0, revs[1])
+	noErr(t, err)
+	expEqual(t, 2, len(kvs))
+
+	_, count, err := b.Count(ctx, "/a/", "", 0)
+	noErr(t, err)
+	expEqual(t, 3, count)
+
+	_, _, err = b.List(ctx, "/a/", "", 0, revs[3]+10)
+	expEqualErr(t, server.ErrFutureRev, err)
+}
+
+func testWatch(t *testing.T, b server.Backend) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rev, err := b.Create(ctx, "/a/a", []byte("1"), 0)
+	noErr(t, err)
+
+	wr := b.Watch(ctx, "/a/", rev)
+
+	_, err = b.Create(ctx, "/b/a", []byte("1"), 0)
+	noErr(t, err)
+	_, _, _, err = b.Update(ctx, "/a/a", []byte("2"), rev, 0)
+	noErr(t, err)
+	_, _, _, err = b.Delete(ctx, "/a/a", rev+2)
+	noErr(t, err)
+
+	var events []*server.Event
+	timeout := time.After(5 * time.Second)
+	for len(events) < 3 {
+		select {
+		case e := <-wr.Events:
+			events = append(events, e...)
+		case <-timeout:
+			t.Fatalf("timed out waiting for events, got %d", len(events))
+		}
+	}
+
+	expEqual(t, true, events[0].Create)
+	expEqual(t, rev, events[0].KV.ModRevision)
+	expEqual(t, rev+2, events[1].KV.ModRevision)
+	expEqual(t, "1", string(events[1].PrevKV.Value))
+	expEqual(t, true, events[2].Delete)
+	expEqual(t, rev+3, events[2].KV.ModRevision)
+
+	cancel()
+	for range wr.Events {
+	}
+}
+
+func noErr(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expEqualErr(t *testing.T, want, got error) {
+	t.Helper()
+	if !errors.Is(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func expEqual[T comparable](t *testing.T, want, got T) {
+	t.Helper()
+	if got != want {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
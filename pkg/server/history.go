@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+
+	"github.com/k3s-io/kine/pkg/kinepb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// explicit interface check
+var _ kinepb.HistoryServer = (*KVServerBridge)(nil)
+
+// ErrHistoryNotSupported is returned if the backend does not provide access to its history.
+var ErrHistoryNotSupported = unsupported("history")
+
+func (k *KVServerBridge) RevisionAt(ctx context.Context, r *kinepb.RevisionAtRequest) (*kinepb.RevisionAtResponse, error) {
+	return k.limited.revisionAt(ctx, r)
+}
+
+func (l *LimitedServer) revisionAt(ctx context.Context, r *kinepb.RevisionAtRequest) (*kinepb.RevisionAtResponse, error) {
+	history, ok := l.backend.(HistoryBackend)
+	if !ok {
+		return nil, ErrHistoryNotSupported
+	}
+	if err := r.GetTime().CheckValid(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	currentRev, err := l.backend.CurrentRevision(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rev, err := history.RevisionAt(ctx, r.GetTime().AsTime())
+	if err != nil {
+		return nil, err
+	}
+	return &kinepb.RevisionAtResponse{
+		CurrentRevision: currentRev,
+		Revision:        rev,
+	}, nil
+}
+
+func (k *KVServerBridge) KeyHistory(ctx context.Context, r *kinepb.KeyHistoryRequest) (*kinepb.KeyHistoryResponse, error) {
+	return k.limited.keyHistory(ctx, r)
+}
+
+func (l *LimitedServer) keyHistory(ctx context.Context, r *kinepb.KeyHistoryRequest) (*kinepb.KeyHistoryResponse, error) {
+	history, ok := l.backend.(HistoryBackend)
+	if !ok {
+		return nil, ErrHistoryNotSupported
+	}
+	if len(r.Key) == 0 && !r.Prefix {
+		return nil, status.Error(codes.InvalidArgument, "key is required")
+	}
+	if r.StartRevision < 0 || r.EndRevision < 0 || r.Limit < 0 {
+		return nil, status.Error(codes.InvalidArgument, "revisions and limit must not be negative")
+	}
+
+	// request one more than the limit, to find out if there are more revisions
+	limit := r.Limit
+	if limit > 0 {
+		limit++
+	}
+
+	currentRev, events, err := history.History(ctx, string(r.Key), r.Prefix, r.StartRevision, r.EndRevision, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &kinepb.KeyHistoryResponse{
+		CurrentRevision: currentRev,
+	}
+	if r.Limit > 0 && int64(len(events)) > r.Limit {
+		resp.More = true
+		events = events[:r.Limit]
+	}
+	for _, event := range events {
+		resp.Revisions = append(resp.Revisions, &kinepb.KeyRevision{
+			Key:            []byte(event.KV.Key),
+			CreateRevision: event.KV.CreateRevision,
+			ModRevision:    event.KV.ModRevision,
+			Create:         event.Create,
+			Delete:         event.Delete,
+			Lease:          event.KV.Lease,
+			Value:          event.KV.Value,
+		})
+	}
+	return resp, nil
+}
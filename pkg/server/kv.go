@@ -29,10 +29,6 @@ func (k *KVServerBridge) Range(ctx context.Context, r *etcdserverpb.RangeRequest
 		return nil, unsupported("sortTarget")
 	}
 
-	if r.Serializable {
-		return nil, unsupported("serializable")
-	}
-
 	if r.MinModRevision != 0 {
 		return nil, unsupported("minModRevision")
 	}
@@ -49,6 +45,10 @@ func (k *KVServerBridge) Range(ctx context.Context, r *etcdserverpb.RangeRequest
 		return nil, unsupported("maxModRevision")
 	}
 
+	if r.Serializable {
+		ctx = WithSerializable(ctx)
+	}
+
 	resp, err := k.limited.Range(ctx, r)
 	if err != nil {
 		if !errors.Is(err, context.Canceled) {
@@ -120,3 +120,17 @@ func (k *KVServerBridge) Compact(ctx context.Context, r *etcdserverpb.Compaction
 	}
 	return res, err
 }
+
+type serializableKey struct{}
+
+// WithSerializable marks the context as belonging to a serializable read, which a backend may
+// serve from a replica that has not yet caught up with the current revision.
+func WithSerializable(ctx context.Context) context.Context {
+	return context.WithValue(ctx, serializableKey{}, true)
+}
+
+// IsSerializable returns true if the context belongs to a serializable read.
+func IsSerializable(ctx context.Context) bool {
+	serializable, _ := ctx.Value(serializableKey{}).(bool)
+	return serializable
+}
@@ -3,6 +3,7 @@ package server
 import (
 	"time"
 
+	"github.com/k3s-io/kine/pkg/kinepb"
 	"go.etcd.io/etcd/api/v3/etcdserverpb"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/health"
@@ -13,6 +14,8 @@ import (
 type KVServerBridge struct {
 	emulatedETCDVersion string
 	limited             *LimitedServer
+	watches             *watchRegistry
+	adminToken          string
 }
 
 func New(backend Backend, scheme string, notifyInterval time.Duration, emulatedETCDVersion string) *KVServerBridge {
@@ -23,18 +26,36 @@ func New(backend Backend, scheme string, notifyInterval time.Duration, emulatedE
 			backend:        backend,
 			scheme:         scheme,
 		},
+		watches: newWatchRegistry(),
 	}
 }
 
+// SetAdminToken sets the token that must be presented as a bearer token by clients of the
+// kine.v1.Admin service. The admin service is disabled if the token is empty.
+func (k *KVServerBridge) SetAdminToken(token string) {
+	k.adminToken = token
+}
+
 func (k *KVServerBridge) Register(server *grpc.Server) {
 	etcdserverpb.RegisterLeaseServer(server, k)
 	etcdserverpb.RegisterWatchServer(server, k)
 	etcdserverpb.RegisterKVServer(server, k)
 	etcdserverpb.RegisterClusterServer(server, k)
 	etcdserverpb.RegisterMaintenanceServer(server, k)
+	kinepb.RegisterHistoryServer(server, k)
+	kinepb.RegisterAdminServer(server, &adminServer{bridge: k})
 
 	hsrv := health.NewServer()
 	hsrv.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	if hb, ok := k.limited.backend.(HealthBackend); ok {
+		hb.NotifyHealth(func(healthy bool) {
+			if healthy {
+				hsrv.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+			} else {
+				hsrv.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+			}
+		})
+	}
 	healthpb.RegisterHealthServer(server, hsrv)
 
 	reflection.Register(server)
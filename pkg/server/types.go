@@ -3,6 +3,7 @@ package server
 import (
 	"context"
 	"database/sql"
+	"time"
 
 	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
 	"google.golang.org/grpc/codes"
@@ -16,6 +17,8 @@ var (
 	ErrCompacted     = rpctypes.ErrGRPCCompacted
 	ErrFutureRev     = rpctypes.ErrGRPCFutureRev
 	ErrGRPCUnhealthy = rpctypes.ErrGRPCUnhealthy
+
+	ErrNotCompactLeader = status.New(codes.FailedPrecondition, "kine: another server is the compaction leader").Err()
 )
 
 type Backend interface {
@@ -23,6 +26,9 @@ type Backend interface {
 	Get(ctx context.Context, key, rangeEnd string, limit, revision int64) (int64, *KeyValue, error)
 	Create(ctx context.Context, key string, value []byte, lease int64) (int64, error)
 	Delete(ctx context.Context, key string, revision int64) (int64, *KeyValue, bool, error)
+	// List returns the keys under the prefix that follow the start key if the prefix ends with
+	// a "/", all keys that follow the start key if the prefix is empty, and otherwise only the
+	// key equal to the prefix.
 	List(ctx context.Context, prefix, startKey string, limit, revision int64) (int64, []*KeyValue, error)
 	Count(ctx context.Context, prefix, startKey string, revision int64) (int64, int64, error)
 	Update(ctx context.Context, key string, value []byte, revision, lease int64) (int64, *KeyValue, bool, error)
@@ -30,6 +36,57 @@ type Backend interface {
 	DbSize(ctx context.Context) (int64, error)
 	CurrentRevision(ctx context.Context) (int64, error)
 	Compact(ctx context.Context, revision int64) (int64, error)
+	Close() error
+}
+
+// HistoryBackend is implemented by backends that provide access to the history of keys
+// retained since the last compaction.
+type HistoryBackend interface {
+	// RevisionAt returns the latest revision created at or before the given time, or zero if
+	// there were no revisions at that time. ErrCompacted is returned if the revision may have
+	// been removed by compaction.
+	RevisionAt(ctx context.Context, t time.Time) (int64, error)
+	// History returns the retained revisions of the key, or of all keys with the key as a
+	// prefix, between the start and end revisions inclusive, in revision order. An end
+	// revision of zero returns revisions up to the current revision, and a limit of zero
+	// returns all revisions. The current revision is returned along with the events.
+	History(ctx context.Context, key string, prefix bool, startRevision, endRevision, limit int64) (int64, []*Event, error)
+}
+
+// CapabilitiesBackend is implemented by backends that report the optional features they support.
+type CapabilitiesBackend interface {
+	Capabilities() Capabilities
+}
+
+// ManagedCompactBackend is implemented by backends that run their own compactor. The etcd
+// Compact RPC compacts to exactly the requested revision, while the admin Compact RPC compacts
+// through the compactor, so that compaction is subject to its leader election and retention.
+// Wrapping backends return ErrManagedCompactNotSupported if the wrapped backend does not.
+type ManagedCompactBackend interface {
+	// ManagedCompact compacts towards the revision as the compactor would, and may compact
+	// to an earlier revision than requested. ErrNotCompactLeader is returned if another
+	// server is the compaction leader. The current revision is returned.
+	ManagedCompact(ctx context.Context, revision int64) (int64, error)
+}
+
+// HealthBackend is implemented by backends that report when they are unable to serve requests.
+type HealthBackend interface {
+	// NotifyHealth calls the function with the current health of the backend, and again each
+	// time that it changes.
+	NotifyHealth(func(healthy bool))
+}
+
+// Capabilities describes the optional features supported by a backend.
+type Capabilities struct {
+	// Compact is set if the backend can be compacted on request through its compactor, by the
+	// admin Compact RPC. Backends that report it must implement ManagedCompactBackend.
+	Compact bool
+	// GapFill is set if the backend fills gaps in the revision sequence.
+	GapFill bool
+	// Encryption is set if values are encrypted at rest.
+	Encryption bool
+	// History is set if the backend provides access to the history of keys.
+	History bool
 }
 
 type Dialect interface {
@@ -46,12 +103,23 @@ type Dialect interface {
 	GetCompactRevision(ctx context.Context) (int64, error)
 	SetCompactRevision(ctx context.Context, revision int64) error
 	Compact(ctx context.Context, revision int64) (int64, error)
+	CompactReport(ctx context.Context, revision int64) (map[string]int64, error)
+	CompactDryRun() bool
+	CompactLeader(ctx context.Context, ttl time.Duration) (bool, error)
+	RevisionAt(ctx context.Context, t time.Time) (int64, error)
+	History(ctx context.Context, key string, prefix bool, startRevision, endRevision, limit int64) (*sql.Rows, error)
 	PostCompact(ctx context.Context) error
+	SetPollRevision(ctx context.Context, revision int64, ttl time.Duration) error
+	MinPollRevision(ctx context.Context) (int64, error)
 	Fill(ctx context.Context, revision int64) error
 	IsFill(key string) bool
 	BeginTx(ctx context.Context, opts *sql.TxOptions) (Transaction, error)
 	GetSize(ctx context.Context) (int64, error)
 	FillRetryDelay(ctx context.Context)
+	Notifications(ctx context.Context) <-chan int64
+	Failovers() int64
+	AdvanceRevision(ctx context.Context, revision int64) error
+	Close() error
 }
 
 type Transaction interface {
@@ -3,6 +3,7 @@ package server
 import (
 	"context"
 	"math/rand"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -32,6 +33,7 @@ func (s *KVServerBridge) Watch(ws etcdserverpb.Watch_WatchServer) error {
 	w := watcher{
 		server:   ws,
 		backend:  s.limited.backend,
+		registry: s.watches,
 		watches:  map[int64]func(){},
 		progress: map[int64]chan<- int64{},
 	}
@@ -65,6 +67,7 @@ type watcher struct {
 
 	wg       sync.WaitGroup
 	backend  Backend
+	registry *watchRegistry
 	server   etcdserverpb.Watch_WatchServer
 	watches  map[int64]func()
 	progress map[int64]chan<- int64
@@ -94,12 +97,24 @@ func (w *watcher) Start(ctx context.Context, r *etcdserverpb.WatchCreateRequest)
 		w.progress[id] = progressCh
 	}
 
+	info := w.registry.add(id, key, startRevision, r.ProgressNotify)
+
 	logrus.Tracef("WATCH START id=%d, key=%s, revision=%d, progressNotify=%v, watchCount=%d", id, key, startRevision, r.ProgressNotify, len(w.watches))
 
 	go func() {
 		defer w.wg.Done()
+
+		// the created response carries the current revision from before the watch starts, so
+		// that clients watching from the current revision can resume after it without missing
+		// events if the stream is lost
+		var createdRevision int64
+		if startRevision == 0 {
+			if rev, err := w.backend.CurrentRevision(ctx); err == nil {
+				createdRevision = rev
+			}
+		}
 		if err := w.server.Send(&etcdserverpb.WatchResponse{
-			Header:  &etcdserverpb.ResponseHeader{},
+			Header:  txnHeader(createdRevision),
 			Created: true,
 			WatchId: id,
 		}); err != nil {
@@ -168,6 +183,8 @@ func (w *watcher) Start(ctx context.Context, r *etcdserverpb.WatchCreateRequest)
 				logrus.Tracef("WATCH SEND id=%d, key=%s, revision=%d, events=%d, size=%d, reads=%d", id, key, revision, len(wr.Events), wr.Size(), reads)
 				if err := w.server.Send(wr); err != nil {
 					w.Cancel(id, 0, 0, err)
+				} else {
+					info.revision.Store(revision)
 				}
 			}
 		}
@@ -215,6 +232,7 @@ func (w *watcher) Cancel(watchID, revision, compactRev int64, err error) {
 		delete(w.watches, watchID)
 	}
 	w.Unlock()
+	w.registry.remove(watchID)
 
 	reason := ""
 	if err != nil {
@@ -302,3 +320,59 @@ func (w *watcher) ProgressIfSynced(ctx context.Context) (bool, error) {
 	}
 	return false, nil
 }
+
+// watchRegistry tracks the watches open on all watch streams, for the admin API.
+type watchRegistry struct {
+	sync.Mutex
+	watches map[int64]*watchInfo
+}
+
+type watchInfo struct {
+	id             int64
+	key            string
+	startRevision  int64
+	progressNotify bool
+	created        time.Time
+	// revision is the revision of the last response sent to the watcher.
+	revision atomic.Int64
+}
+
+func newWatchRegistry() *watchRegistry {
+	return &watchRegistry{
+		watches: map[int64]*watchInfo{},
+	}
+}
+
+func (r *watchRegistry) add(id int64, key string, startRevision int64, progressNotify bool) *watchInfo {
+	info := &watchInfo{
+		id:             id,
+		key:            key,
+		startRevision:  startRevision,
+		progressNotify: progressNotify,
+		created:        time.Now(),
+	}
+	r.Lock()
+	r.watches[id] = info
+	r.Unlock()
+	return info
+}
+
+func (r *watchRegistry) remove(id int64) {
+	r.Lock()
+	delete(r.watches, id)
+	r.Unlock()
+}
+
+// list returns the open watches, ordered by ID.
+func (r *watchRegistry) list() []*watchInfo {
+	r.Lock()
+	result := make([]*watchInfo, 0, len(r.watches))
+	for _, info := range r.watches {
+		result = append(result, info)
+	}
+	r.Unlock()
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].id < result[j].id
+	})
+	return result
+}
@@ -0,0 +1,81 @@
+package util
+
+import (
+	"fmt"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression identifies the algorithm used to compress a stored value.
+// The numeric values are persisted, and must not be changed.
+type Compression int
+
+const (
+	CompressionNone Compression = 0
+	CompressionS2   Compression = 1
+	CompressionZstd Compression = 2
+)
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+)
+
+// ParseCompression returns the compression algorithm with the given name.
+// An empty name selects no compression.
+func ParseCompression(name string) (Compression, error) {
+	switch name {
+	case "", "none":
+		return CompressionNone, nil
+	case "s2":
+		return CompressionS2, nil
+	case "zstd":
+		return CompressionZstd, nil
+	}
+	return CompressionNone, fmt.Errorf("unsupported compression algorithm %q", name)
+}
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionS2:
+		return "s2"
+	case CompressionZstd:
+		return "zstd"
+	}
+	return fmt.Sprintf("unknown(%d)", int(c))
+}
+
+// Compress returns the value compressed with the algorithm.
+func (c Compression) Compress(value []byte) ([]byte, error) {
+	if len(value) == 0 {
+		return value, nil
+	}
+	switch c {
+	case CompressionNone:
+		return value, nil
+	case CompressionS2:
+		return s2.Encode(nil, value), nil
+	case CompressionZstd:
+		return zstdEncoder.EncodeAll(value, nil), nil
+	}
+	return nil, fmt.Errorf("unsupported compression algorithm %s", c)
+}
+
+// Decompress returns the value decompressed with the algorithm.
+func (c Compression) Decompress(value []byte) ([]byte, error) {
+	if len(value) == 0 {
+		return value, nil
+	}
+	switch c {
+	case CompressionNone:
+		return value, nil
+	case CompressionS2:
+		return s2.Decode(nil, value)
+	case CompressionZstd:
+		return zstdDecoder.DecodeAll(value, nil)
+	}
+	return nil, fmt.Errorf("unsupported compression algorithm %s", c)
+}
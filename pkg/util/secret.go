@@ -0,0 +1,17 @@
+package util
+
+import (
+	"os"
+	"strings"
+)
+
+// ReadSecretFile returns the contents of a file holding a secret, such as a password or token,
+// without any trailing newline. Files written by secret managers often end with a newline that
+// is not part of the secret.
+func ReadSecretFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}